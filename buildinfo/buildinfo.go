@@ -0,0 +1,69 @@
+// Package buildinfo describes the running build of the API.
+//
+// Version, Commit and BuildTime are injected at build time:
+//
+//	go build -ldflags "-X github.com/PaulFWatts/rest_api_golang/buildinfo.Version=v1.4.0 \
+//	  -X github.com/PaulFWatts/rest_api_golang/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/PaulFWatts/rest_api_golang/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without a Commit, the revision recorded by the Go toolchain from version
+// control is used, so plain "go build" in a checkout still reports it.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Set with -ldflags "-X ..." when building a release
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// Info describes a build of the API
+type Info struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	CommitTime string `json:"commitTime,omitempty"`
+	Modified   bool   `json:"modified,omitempty"` // Built from a checkout with uncommitted changes
+	BuildTime  string `json:"buildTime,omitempty"`
+	GoVersion  string `json:"goVersion"`
+}
+
+// Get returns the build information of the running binary
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok || Commit != "" {
+		return fill(info)
+	}
+
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+		case "vcs.time":
+			info.CommitTime = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+
+	return fill(info)
+}
+
+// fill marks a commit that neither the linker nor the toolchain provided
+func fill(info Info) Info {
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	return info
+}
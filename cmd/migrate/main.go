@@ -0,0 +1,98 @@
+// Command migrate inspects and changes the schema version of the API database.
+//
+// Usage:
+//
+//	go run ./cmd/migrate [-db api.db] status
+//	go run ./cmd/migrate [-db api.db] up
+//	go run ./cmd/migrate [-db api.db] down [steps]
+//
+// The database is the API's, chosen by the database settings read from
+// CONFIG_FILE and the environment (DB_DRIVER, DB_PATH, DATABASE_URL) like the
+// API does. -db overrides the path of a SQLite database.
+//
+// The API applies pending migrations on startup, so "up" is only needed to
+// prepare a database ahead of a deploy. "down" reverts the newest migrations,
+// one step unless a number of steps is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/db"
+	"github.com/PaulFWatts/rest_api_golang/migrations"
+)
+
+func main() {
+	cfg, err := config.LoadDatabase()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+
+	flag.StringVar(&cfg.Path, "db", cfg.Path, "path to the SQLite database")
+	flag.Parse()
+
+	err = run(cfg, flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+}
+
+func run(cfg config.Database, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command: status, up or down")
+	}
+
+	database, err := db.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	runner, err := migrations.New(database, cfg.Driver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		statuses, err := runner.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", status.Version, status.Name, applied)
+		}
+
+	case "up":
+		count, err := runner.Up()
+		fmt.Printf("applied %d migration(s)\n", count)
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		count, err := runner.Down(steps)
+		fmt.Printf("reverted %d migration(s)\n", count)
+		return err
+
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	return nil
+}
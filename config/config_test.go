@@ -0,0 +1,148 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// validConfig returns the defaults with the settings that have none filled in
+func validConfig() *Config {
+	c := Default()
+	c.Auth.JWTSecret = "test secret"
+	return c
+}
+
+func TestDefaultsWithSecretAreValid(t *testing.T) {
+	err := validConfig().Validate()
+	if err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidateRequiresSigningKeys(t *testing.T) {
+	c := validConfig()
+	c.Auth.JWTSecret = ""
+
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "auth.jwt_keys") {
+		t.Fatalf("Validate() = %v, want an auth.jwt_keys error", err)
+	}
+
+	c.Auth.JWTKeys = "2025-01=keys/2025-01.pem"
+	err = c.Validate()
+	if err != nil {
+		t.Fatalf("Validate() with jwt_keys = %v, want nil", err)
+	}
+}
+
+func TestValidateDatabase(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(*Database)
+		valid bool
+	}{
+		{"sqlite", func(d *Database) {}, true},
+		{"sqlite without path", func(d *Database) { d.Path = "" }, false},
+		{"postgres", func(d *Database) {
+			d.Driver = "postgres"
+			d.URL = "postgres://api@localhost/api"
+		}, true},
+		{"postgres without url", func(d *Database) { d.Driver = "postgres" }, false},
+		{"postgres with another scheme", func(d *Database) {
+			d.Driver = "postgres"
+			d.URL = "mysql://api@localhost/api"
+		}, false},
+		{"unknown driver", func(d *Database) { d.Driver = "mysql" }, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := validConfig()
+			test.edit(&c.Database)
+
+			err := c.Validate()
+			if (err == nil) != test.valid {
+				t.Errorf("Validate() = %v, want valid %t", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestValidateRateLimitStore(t *testing.T) {
+	for store, valid := range map[string]bool{"memory": true, "database": true, "sqlite": false} {
+		c := validConfig()
+		c.RateLimit.Store = store
+
+		err := c.Validate()
+		if (err == nil) != valid {
+			t.Errorf("Validate() with store %q = %v, want valid %v", store, err, valid)
+		}
+	}
+}
+
+func TestLoadDatabaseIgnoresOtherSettings(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("JWT_KEYS", "")
+	t.Setenv("DB_PATH", "other.db")
+
+	_, err := Load(nil)
+	if err == nil {
+		t.Fatal("Load(nil) without signing keys succeeded")
+	}
+
+	database, err := LoadDatabase()
+	if err != nil {
+		t.Fatalf("LoadDatabase() = %v", err)
+	}
+	if database.Path != "other.db" {
+		t.Errorf("Path = %q, want other.db from DB_PATH", database.Path)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	t.Setenv("JWT_SECRET", "from environment")
+	t.Setenv("BCRYPT_COST", "12")
+
+	c, err := Load([]string{"-password.bcrypt_cost", "10"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.Auth.JWTSecret != "from environment" {
+		t.Errorf("JWTSecret = %q, want the environment's", c.Auth.JWTSecret)
+	}
+	if c.Password.BcryptCost != 10 {
+		t.Errorf("BcryptCost = %d, want 10 from the flag", c.Password.BcryptCost)
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test secret")
+
+	// Without SMTP the links point at the local server
+	c, err := Load([]string{"-server.address", ":9090"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.App.PublicURL != "http://localhost:9090" {
+		t.Errorf("PublicURL = %q, want http://localhost:9090", c.App.PublicURL)
+	}
+
+	// With SMTP the URL must be configured, as the links reach real users
+	_, err = Load([]string{"-mail.smtp_host", "smtp.example.com"})
+	if err == nil || !strings.Contains(err.Error(), "app.public_url") {
+		t.Fatalf("Load with SMTP and no public URL = %v, want an app.public_url error", err)
+	}
+
+	c, err = Load([]string{"-mail.smtp_host", "smtp.example.com", "-app.public_url", "https://api.example.com"})
+	if err != nil {
+		t.Fatalf("Load with SMTP and a public URL: %v", err)
+	}
+	if c.App.PublicURL != "https://api.example.com" {
+		t.Errorf("PublicURL = %q, want the configured one", c.App.PublicURL)
+	}
+
+	_, err = Load([]string{"-app.public_url", "api.example.com"})
+	if err == nil {
+		t.Error("Load with a relative public URL succeeded")
+	}
+}
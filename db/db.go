@@ -2,72 +2,87 @@ package db
 
 import (
 	"database/sql"
+	"fmt"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/migrations"
 
 	_ "github.com/mattn/go-sqlite3" // Importing SQLite driver
 )
 
 var DB *sql.DB
 
-func InitDB() {
+// InitDB opens the database described by the configuration and migrates it
+func InitDB(cfg config.Database) {
 	var err error
-	DB, err = sql.Open("sqlite3", "api.db")
+	DB, err = Open(cfg)
 
 	if err != nil {
 		panic("Could not connect to database.")
 	}
 
-	DB.SetMaxOpenConns(10)
-	DB.SetMaxIdleConns(5)
+	migrate(cfg.Driver)
 
-	createTables()
+	// PostgreSQL's index is a column added by the migrations
+	if cfg.Driver == "postgres" {
+		SearchIndex = "postgres"
+		return
+	}
+	createSearchIndex()
 }
 
-func createTables() {
-	createUsersTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		email TEXT NOT NULL UNIQUE,
-		password TEXT NOT NULL
-	)
-	`
+// Open opens the SQLite or PostgreSQL database chosen by cfg.Driver, with
+// the configured pool size, without connecting or migrating it yet
+//
+// Callers write queries with ? placeholders for both backends; see
+// openPostgres for how PostgreSQL is made to accept them.
+func Open(cfg config.Database) (*sql.DB, error) {
+	var database *sql.DB
+	var err error
 
-	_, err := DB.Exec(createUsersTable)
+	switch cfg.Driver {
+	case "sqlite":
+		database, err = sql.Open("sqlite3", DSN(cfg))
+	case "postgres":
+		database, err = openPostgres(cfg.URL)
+	default:
+		err = fmt.Errorf("unknown database driver %q", cfg.Driver)
+	}
 
 	if err != nil {
-		panic("Could not create users table.")
+		return nil, err
 	}
 
-	createEventsTable := `
-	CREATE TABLE IF NOT EXISTS events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL,
-		description TEXT NOT NULL,
-		location TEXT NOT NULL,
-		dateTime DATETIME NOT NULL,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	)
-	`
+	database.SetMaxOpenConns(cfg.MaxOpenConns)
+	database.SetMaxIdleConns(cfg.MaxIdleConns)
+	return database, nil
+}
+
+// Close closes the database once the server has stopped handling requests
+func Close() error {
+	return DB.Close()
+}
 
-	_, err = DB.Exec(createEventsTable)
+// DSN returns the SQLite data source name for the configured database
+//
+// Immediate transactions take the write lock up front, so read-then-write
+// transactions such as event registration cannot interleave.
+func DSN(cfg config.Database) string {
+	return fmt.Sprintf("%s?_txlock=immediate&_busy_timeout=%d", cfg.Path, cfg.BusyTimeout.Milliseconds())
+}
+
+// migrate applies pending schema migrations and refuses to start if the
+// applied migration history no longer matches the migrations in this build
+func migrate(driver string) {
+	runner, err := migrations.New(DB, driver)
 
 	if err != nil {
-		panic("Could not create events table.")
+		panic("Could not load migrations: " + err.Error())
 	}
 
-		createRegistrationsTable := `
-	CREATE TABLE IF NOT EXISTS registrations (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		event_id INTEGER,
-		user_id INTEGER,
-		FOREIGN KEY(event_id) REFERENCES events(id),
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	)
-	`
-
-	_, err = DB.Exec(createRegistrationsTable)
+	_, err = runner.Up()
 
 	if err != nil {
-		panic("Could not create registrations table.")
+		panic("Could not migrate database: " + err.Error())
 	}
 }
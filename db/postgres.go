@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// openPostgres opens a PostgreSQL database through pgx, adapted so that the
+// queries written for SQLite run unchanged
+//
+// Two differences are smoothed over:
+//   - Queries use ? placeholders, which are rewritten to PostgreSQL's $1, $2, ...
+//   - Times are sent in UTC, since TIMESTAMP columns drop the time zone
+//     rather than converting to it, and SQLite stores them in UTC as well
+//
+// Transactions run concurrently at PostgreSQL's default READ COMMITTED level,
+// unlike SQLite's immediate transactions, which run one at a time. Queries
+// that read and then write, such as event registration, lock the rows they
+// depend on instead; see models.NewSQLRepositories.
+func openPostgres(url string) (*sql.DB, error) {
+	config, err := pgx.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(postgresConnector{stdlib.GetConnector(*config)}), nil
+}
+
+// postgresConnector hands out postgresConns
+type postgresConnector struct {
+	driver.Connector
+}
+
+func (c postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &postgresConn{conn.(*stdlib.Conn)}, nil
+}
+
+// postgresConn is a pgx connection that rebinds placeholders and sends times in UTC
+type postgresConn struct {
+	*stdlib.Conn
+}
+
+func (c *postgresConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebind(query))
+}
+
+func (c *postgresConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.PrepareContext(ctx, rebind(query))
+}
+
+func (c *postgresConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.ExecContext(ctx, rebind(query), args)
+}
+
+func (c *postgresConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.QueryContext(ctx, rebind(query), args)
+}
+
+// CheckNamedValue converts time arguments to UTC and passes everything else
+// through to pgx, which encodes it itself
+func (c *postgresConn) CheckNamedValue(value *driver.NamedValue) error {
+	switch v := value.Value.(type) {
+	case time.Time:
+		value.Value = v.UTC()
+	case *time.Time:
+		if v != nil {
+			value.Value = v.UTC()
+		}
+	}
+	return c.Conn.CheckNamedValue(value)
+}
+
+// rebind rewrites ? placeholders to $1, $2, ... in order, leaving question
+// marks in string literals, quoted identifiers and comments alone
+func rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+
+	var rebound strings.Builder
+	rebound.Grow(len(query) + 8)
+	placeholder := 0
+
+	for i := 0; i < len(query); i++ {
+		// end is the last byte of a literal or comment starting at i
+		end := -1
+		switch {
+		case query[i] == '\'' || query[i] == '"':
+			// A doubled quote ends the literal and starts another, which
+			// copies it through unchanged
+			end = closing(query, i+1, query[i:i+1])
+		case strings.HasPrefix(query[i:], "--"):
+			end = closing(query, i+2, "\n")
+		case strings.HasPrefix(query[i:], "/*"):
+			end = closing(query, i+2, "*/")
+		}
+
+		switch {
+		case end >= 0:
+			rebound.WriteString(query[i : end+1])
+			i = end
+		case query[i] == '?':
+			placeholder++
+			rebound.WriteByte('$')
+			rebound.WriteString(strconv.Itoa(placeholder))
+		default:
+			rebound.WriteByte(query[i])
+		}
+	}
+
+	return rebound.String()
+}
+
+// closing returns the index of the last byte of the first terminator in
+// query at or after from, or of the query's last byte if there is none
+func closing(query string, from int, terminator string) int {
+	index := strings.Index(query[from:], terminator)
+	if index < 0 {
+		return len(query) - 1
+	}
+	return from + index + len(terminator) - 1
+}
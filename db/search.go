@@ -0,0 +1,73 @@
+package db
+
+// SearchIndex is the full-text index InitDB found or created, as a
+// models.SearchIndex: "fts5" on SQLite and "postgres" on PostgreSQL
+var SearchIndex string
+
+// createSearchIndex creates the events_fts index, keeps it in sync with the
+// events table through triggers and rebuilds it from the existing rows
+//
+// FTS5 is only compiled into the SQLite driver when building with
+// -tags sqlite_fts5, as the Makefile does. A build without it refuses to
+// start rather than serve an API whose search silently stopped working.
+func createSearchIndex() {
+	var fts5Available bool
+	err := DB.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5Available)
+
+	if err != nil {
+		panic("Could not check for FTS5 support.")
+	}
+
+	if !fts5Available {
+		panic("SQLite was built without FTS5, which full-text search needs. Build with -tags sqlite_fts5, e.g. with make build.")
+	}
+
+	createIndex := `
+	CREATE VIRTUAL TABLE IF NOT EXISTS events_fts USING fts5(
+		name, description, location,
+		content='events',
+		content_rowid='id',
+		tokenize='unicode61 remove_diacritics 2',
+		prefix='2 3'
+	)
+	`
+
+	_, err = DB.Exec(createIndex)
+
+	if err != nil {
+		panic("Could not create search index.")
+	}
+
+	createTriggers := `
+	CREATE TRIGGER IF NOT EXISTS events_fts_insert AFTER INSERT ON events BEGIN
+		INSERT INTO events_fts(rowid, name, description, location)
+		VALUES (new.id, new.name, new.description, new.location);
+	END;
+	CREATE TRIGGER IF NOT EXISTS events_fts_delete AFTER DELETE ON events BEGIN
+		INSERT INTO events_fts(events_fts, rowid, name, description, location)
+		VALUES ('delete', old.id, old.name, old.description, old.location);
+	END;
+	CREATE TRIGGER IF NOT EXISTS events_fts_update AFTER UPDATE ON events BEGIN
+		INSERT INTO events_fts(events_fts, rowid, name, description, location)
+		VALUES ('delete', old.id, old.name, old.description, old.location);
+		INSERT INTO events_fts(rowid, name, description, location)
+		VALUES (new.id, new.name, new.description, new.location);
+	END;
+	`
+
+	_, err = DB.Exec(createTriggers)
+
+	if err != nil {
+		panic("Could not create search triggers.")
+	}
+
+	// Rebuilding backfills rows written before the index existed, or by
+	// earlier builds without FTS5, which removed the triggers
+	_, err = DB.Exec("INSERT INTO events_fts(events_fts) VALUES ('rebuild')")
+
+	if err != nil {
+		panic("Could not rebuild search index.")
+	}
+
+	SearchIndex = "fts5"
+}
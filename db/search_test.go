@@ -0,0 +1,65 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+)
+
+// initDB runs InitDB and returns the value it panicked with, if any
+func initDB(cfg config.Database) (recovered any) {
+	defer func() { recovered = recover() }()
+	InitDB(cfg)
+	return nil
+}
+
+// TestSearchIndexNeedsFTS5 passes in both builds: make test, with FTS5, gets a
+// working index, and a plain go test checks that the API refuses to start
+func TestSearchIndexNeedsFTS5(t *testing.T) {
+	previous := DB
+	t.Cleanup(func() {
+		DB = previous
+		SearchIndex = ""
+	})
+
+	cfg := config.Default().Database
+	cfg.Path = filepath.Join(t.TempDir(), "test.db")
+	recovered := initDB(cfg)
+	t.Cleanup(func() { DB.Close() })
+
+	var fts5Available bool
+	err := DB.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5Available)
+	if err != nil {
+		t.Fatalf("checking for FTS5: %v", err)
+	}
+
+	if !fts5Available {
+		if recovered == nil {
+			t.Fatal("InitDB started without FTS5")
+		}
+		return
+	}
+
+	if recovered != nil {
+		t.Fatalf("InitDB panicked with FTS5: %v", recovered)
+	}
+	if SearchIndex != "fts5" {
+		t.Fatalf("search index %q with FTS5, want fts5", SearchIndex)
+	}
+
+	_, err = DB.Exec("INSERT INTO events(name, description, location, dateTime) VALUES ('Book club', 'Monthly reading', 'Library', ?)", time.Now().UTC())
+	if err != nil {
+		t.Fatalf("inserting an event: %v", err)
+	}
+
+	var matches int
+	err = DB.QueryRow("SELECT COUNT(*) FROM events_fts WHERE events_fts MATCH 'book'").Scan(&matches)
+	if err != nil {
+		t.Fatalf("searching: %v", err)
+	}
+	if matches != 1 {
+		t.Errorf("search for a new event found %d matches, want 1", matches)
+	}
+}
@@ -0,0 +1,204 @@
+// Package health answers liveness and readiness probes.
+//
+// Liveness only says the process is serving requests. Readiness checks what
+// requests need: a reachable database, a schema with no pending migrations
+// and, with SQLite, enough free disk space for the database file to grow.
+// PostgreSQL runs elsewhere and watches its own disk. While the server is
+// draining for shutdown, readiness fails so that load balancers stop sending
+// new requests.
+//
+// Probes only read: the migration history is verified once by Init, and
+// probes count the applied migrations to notice a schema rolled back later.
+// Failures are logged with their cause, while the report only names the
+// check that failed.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/migrations"
+)
+
+// Check statuses
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is the result of one readiness check
+type Check struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	PendingMigrations *int    `json:"pendingMigrations,omitempty"`
+	Path              string  `json:"path,omitempty"`
+	FreeBytes         *uint64 `json:"freeBytes,omitempty"`
+	MinFreeBytes      *uint64 `json:"minFreeBytes,omitempty"`
+}
+
+// Report is the result of a readiness probe
+type Report struct {
+	Ready    bool             `json:"ready"`
+	Draining bool             `json:"draining,omitempty"`
+	Checks   map[string]Check `json:"checks"`
+}
+
+// errDiskSpaceUnsupported means free disk space cannot be read on this platform,
+// in which case the disk check passes without reporting it
+var errDiskSpaceUnsupported = errors.New("free disk space is not available on this platform")
+
+// checkTimeout bounds how long a readiness probe waits for the database
+const checkTimeout = 2 * time.Second
+
+var (
+	database          *sql.DB
+	databaseDriver    string
+	databasePath      string
+	minFreeBytes      uint64
+	migrationsCheck   Check // Result of verifying the migration history in Init
+	appliedMigrations int   // Number of migrations applied when Init verified them
+	draining          atomic.Bool
+)
+
+// Init sets the database that readiness checks and the free space it needs,
+// and verifies the migration history
+func Init(db *sql.DB, cfg config.Database) {
+	database = db
+	databaseDriver = cfg.Driver
+	databasePath = cfg.Path
+	minFreeBytes = uint64(cfg.MinFreeDiskMB) * 1024 * 1024
+	migrationsCheck, appliedMigrations = verifyMigrations()
+}
+
+// StartDraining makes readiness fail from now on, because the server is shutting down
+func StartDraining() {
+	draining.Store(true)
+}
+
+// Readiness runs every readiness check and reports whether the API can take requests
+//
+// All checks run even when one fails, so the report shows every problem.
+func Readiness(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{
+		Draining: draining.Load(),
+		Checks: map[string]Check{
+			"database":   checkDatabase(ctx),
+			"migrations": checkMigrations(ctx),
+		},
+	}
+	if databaseDriver == "sqlite" {
+		report.Checks["disk"] = checkDisk()
+	}
+
+	report.Ready = !report.Draining
+	for _, check := range report.Checks {
+		if check.Status != StatusOK {
+			report.Ready = false
+		}
+	}
+
+	return report
+}
+
+// checkDatabase pings the database
+func checkDatabase(ctx context.Context) Check {
+	err := database.PingContext(ctx)
+	if err != nil {
+		slog.Warn("Readiness: database ping failed", "error", err)
+		return Check{Status: StatusFail, Error: "database unreachable"}
+	}
+	return Check{Status: StatusOK}
+}
+
+// verifyMigrations checks the migration history against the migrations in
+// this build and counts the pending and applied migrations
+func verifyMigrations() (Check, int) {
+	runner, err := migrations.New(database, databaseDriver)
+	if err != nil {
+		slog.Error("Readiness: could not load migrations", "error", err)
+		return Check{Status: StatusFail, Error: "migrations could not be loaded"}, 0
+	}
+
+	statuses, err := runner.Status()
+	if err != nil {
+		slog.Error("Readiness: could not verify migrations", "error", err)
+		return Check{Status: StatusFail, Error: "migration history could not be verified"}, 0
+	}
+
+	applied := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			applied++
+		}
+	}
+
+	return migrationCount(len(statuses) - applied), applied
+}
+
+// checkMigrations reports the result of verifyMigrations, unless the number
+// of applied migrations changed since then
+//
+// Migrations run at startup, so a migration missing since then means another
+// instance has rolled the schema back, or this database was replaced
+// underneath us; one more means a newer build migrated it. Counting them is a
+// read, unlike verifying them.
+func checkMigrations(ctx context.Context) Check {
+	if migrationsCheck.Status != StatusOK {
+		return migrationsCheck
+	}
+
+	var applied int
+	err := database.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations").Scan(&applied)
+	if err != nil {
+		slog.Warn("Readiness: could not count applied migrations", "error", err)
+		return Check{Status: StatusFail, Error: "migration history could not be read"}
+	}
+
+	if applied > appliedMigrations {
+		return Check{Status: StatusFail, Error: "the database has migrations unknown to this build"}
+	}
+	return migrationCount(appliedMigrations - applied)
+}
+
+// migrationCount is the migrations check for the number of pending migrations
+func migrationCount(pending int) Check {
+	check := Check{Status: StatusOK, PendingMigrations: &pending}
+	if pending > 0 {
+		check.Status = StatusFail
+		check.Error = fmt.Sprintf("%d pending migrations", pending)
+	}
+	return check
+}
+
+// checkDisk reports the free space on the filesystem holding the database file
+func checkDisk() Check {
+	dir := filepath.Dir(databasePath)
+
+	free, err := freeDiskSpace(dir)
+	if errors.Is(err, errDiskSpaceUnsupported) {
+		return Check{Status: StatusOK, Path: dir}
+	}
+	if err != nil {
+		slog.Warn("Readiness: could not read free disk space", "path", dir, "error", err)
+		return Check{Status: StatusFail, Path: dir, Error: "free disk space could not be read"}
+	}
+
+	check := Check{Status: StatusOK, Path: dir, FreeBytes: &free, MinFreeBytes: &minFreeBytes}
+	if free < minFreeBytes {
+		check.Status = StatusFail
+		check.Error = "not enough free disk space"
+	}
+
+	return check
+}
@@ -0,0 +1,78 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/db/dbtest"
+	"github.com/PaulFWatts/rest_api_golang/migrations"
+)
+
+// initSQLite points the checks at a migrated SQLite database and returns it
+func initSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	database := dbtest.Open(t, "sqlite")
+	cfg := config.Default().Database
+	cfg.Path = filepath.Join(t.TempDir(), "test.db")
+	cfg.MinFreeDiskMB = 0
+	Init(database, cfg)
+	return database
+}
+
+func TestReadinessCountsMigrationsRolledBack(t *testing.T) {
+	database := initSQLite(t)
+
+	report := Readiness(context.Background())
+	if !report.Ready {
+		t.Fatalf("not ready after migrating: %+v", report)
+	}
+
+	runner, err := migrations.New(database, "sqlite")
+	if err != nil {
+		t.Fatalf("migrations.New: %v", err)
+	}
+	_, err = runner.Down(1)
+	if err != nil {
+		t.Fatalf("Down: %v", err)
+	}
+
+	report = Readiness(context.Background())
+	check := report.Checks["migrations"]
+	if report.Ready || check.PendingMigrations == nil || *check.PendingMigrations != 1 {
+		t.Errorf("after rolling back a migration: ready %v, migrations %+v, want 1 pending", report.Ready, check)
+	}
+}
+
+func TestReadinessKeepsErrorsOutOfTheReport(t *testing.T) {
+	database := initSQLite(t)
+	database.Close()
+
+	report := Readiness(context.Background())
+	if report.Ready {
+		t.Fatal("ready with the database closed")
+	}
+	for name, want := range map[string]string{"database": "database unreachable", "migrations": "migration history could not be read"} {
+		if check := report.Checks[name]; check.Status != StatusFail || check.Error != want {
+			t.Errorf("%s check = %+v, want it failed with %q", name, check, want)
+		}
+	}
+}
+
+func TestReadinessFailsWhileDraining(t *testing.T) {
+	initSQLite(t)
+	t.Cleanup(func() { draining.Store(false) })
+
+	StartDraining()
+
+	report := Readiness(context.Background())
+	if report.Ready || !report.Draining {
+		t.Errorf("while draining: ready %v, draining %v; want not ready and draining", report.Ready, report.Draining)
+	}
+	if check := report.Checks["database"]; check.Status != StatusOK {
+		t.Errorf("database check while draining = %+v, want ok", check)
+	}
+}
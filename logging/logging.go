@@ -0,0 +1,120 @@
+// Package logging sets up the API's structured logs.
+//
+// Logs are written with log/slog, as JSON by default. Each request gets its
+// own logger carrying the request ID, which handlers fetch with FromContext.
+// Sensitive values never reach the output: attributes named like passwords,
+// tokens or secrets are replaced, email addresses are masked wherever they
+// appear, and JWTs, opaque tokens and token query parameters are removed from
+// messages and errors.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+)
+
+// Init makes a redacting slog logger the default, which also captures the
+// standard library's log package
+func Init(cfg config.Log) {
+	slog.SetDefault(slog.New(NewHandler(os.Stderr, cfg)))
+}
+
+// NewHandler returns a JSON or text handler for the configuration that redacts sensitive values
+func NewHandler(w io.Writer, cfg config.Log) slog.Handler {
+	var level slog.Level
+	// The configuration has been validated, so the level always parses
+	_ = level.UnmarshalText([]byte(cfg.Level))
+
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	if cfg.Format == "text" {
+		return slog.NewTextHandler(w, options)
+	}
+	return slog.NewJSONHandler(w, options)
+}
+
+// redacted replaces the values of sensitive attributes
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute names, lowercased, whose values are never logged
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"refreshtoken":  true,
+	"mfatoken":      true,
+	"authorization": true,
+	"secret":        true,
+	"code":          true,
+}
+
+var (
+	emailPattern = regexp.MustCompile(`([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*@([A-Za-z0-9.-]+\.[A-Za-z]{2,})`)
+	jwtPattern   = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// tokenParamPattern matches the value of a token query parameter, as in
+	// the links of verification, password reset and calendar feed URLs
+	tokenParamPattern = regexp.MustCompile(`(?i)([?&;](?:token|refreshToken|mfaToken)=)[^&;#\s"'<>]*`)
+	// opaqueTokenPattern matches runs of base64url characters; runs as long as
+	// the tokens of utils.GenerateOpaqueToken are removed
+	opaqueTokenPattern = regexp.MustCompile(`[A-Za-z0-9_-]{43,}`)
+)
+
+// opaqueTokenLength is the length of a utils.GenerateOpaqueToken token: 32 bytes in unpadded base64url
+const opaqueTokenLength = 43
+
+// redact is the ReplaceAttr function of every handler; it also sees the
+// message, so text logged through the log package is scrubbed too
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+
+	switch value := attr.Value.Any().(type) {
+	case string:
+		return slog.String(attr.Key, Scrub(value))
+	case error:
+		return slog.String(attr.Key, Scrub(value.Error()))
+	}
+
+	return attr
+}
+
+// Scrub masks email addresses (keeping the first character and the domain)
+// and removes JWTs, opaque tokens and the values of token query parameters
+// from text
+//
+// Opaque tokens have no structure to recognise them by, so any run of exactly
+// 43 base64url characters is removed; longer runs, such as hex digests, are kept.
+func Scrub(text string) string {
+	text = jwtPattern.ReplaceAllString(text, redacted)
+	text = tokenParamPattern.ReplaceAllString(text, "${1}"+redacted)
+	text = opaqueTokenPattern.ReplaceAllStringFunc(text, func(run string) string {
+		if len(run) == opaqueTokenLength {
+			return redacted
+		}
+		return run
+	})
+	return emailPattern.ReplaceAllString(text, "$1***@$2")
+}
+
+// contextKey is the type of the context key holding the request's logger
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of the request, or the default logger
+// outside a request
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
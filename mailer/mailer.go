@@ -0,0 +1,223 @@
+// Package mailer sends transactional emails such as password reset links.
+//
+// Messages go through a Mailer chosen at startup by Init: SMTP for real
+// delivery, a directory of .eml files for local development, or memory for
+// tests, so neither of the latter ever needs a mail server.
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(message Message) error
+}
+
+// current is the mailer used by Send, chosen by Init or Use
+var current Mailer
+
+// Init chooses the mailer from the mail configuration
+//
+// Mailer selects "smtp", "file" or "memory". Without it, SMTP is used when
+// SMTPHost is set and the file mailer otherwise.
+func Init(cfg config.Mail) {
+	kind := cfg.Kind()
+	switch kind {
+	case "smtp":
+		current = &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     strconv.Itoa(cfg.SMTPPort),
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}
+	case "file":
+		current = &FileMailer{Dir: cfg.Dir, From: cfg.From}
+	case "memory":
+		current = &MemoryMailer{}
+	default:
+		panic("Unknown MAILER " + kind + ".")
+	}
+}
+
+// Use replaces the mailer used by Send
+func Use(m Mailer) {
+	current = m
+}
+
+// Send delivers a message with the mailer chosen by Init or Use
+func Send(message Message) error {
+	if current == nil {
+		return errors.New("mailer not initialised")
+	}
+	return current.Send(message)
+}
+
+// pending tracks messages handed to SendInBackground that are still being delivered
+var pending sync.WaitGroup
+
+// SendInBackground delivers a message without making the caller wait, and logs
+// a failure using the description, e.g. "password reset email"
+//
+// Messages still being delivered when the server shuts down are waited for by Wait.
+func SendInBackground(message Message, description string) {
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		err := Send(message)
+		if err != nil {
+			slog.Error("Could not send "+description, "error", err)
+		}
+	}()
+}
+
+// Wait blocks until every message passed to SendInBackground has been
+// delivered or has failed, or until the timeout, and reports whether all finished
+func Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// SMTPMailer sends messages through an SMTP server, using STARTTLS when the server offers it
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string // Leave empty for servers that do not require authentication
+	Password string
+	From     string
+}
+
+// Send delivers the message to the SMTP server
+func (m *SMTPMailer) Send(message Message) error {
+	data, err := encode(m.From, message)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	return smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{message.To}, data)
+}
+
+// FileMailer writes every message as an .eml file into Dir, which mail clients can open
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes the message to a new file in Dir
+func (m *FileMailer) Send(message Message) error {
+	data, err := encode(m.From, message)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(m.Dir, 0o700)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(m.Dir, time.Now().UTC().Format("20060102-150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	return err
+}
+
+// MemoryMailer keeps sent messages in memory
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send records the message
+func (m *MemoryMailer) Send(message Message) error {
+	_, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Messages returns a copy of every message sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message{}, m.messages...)
+}
+
+// encode renders a message in RFC 5322 format with a quoted-printable UTF-8 body
+func encode(from string, message Message) ([]byte, error) {
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(message.Subject, "\r\n") {
+		return nil, errors.New("subject must be a single line")
+	}
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "From: %s\r\n", from)
+	fmt.Fprintf(&buffer, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	writer := quotedprintable.NewWriter(&buffer)
+	_, err = writer.Write([]byte(strings.ReplaceAll(message.Body, "\n", "\r\n")))
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -1,20 +1,97 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
 
-
+	"github.com/PaulFWatts/rest_api_golang/config"
 	"github.com/PaulFWatts/rest_api_golang/db"
+	"github.com/PaulFWatts/rest_api_golang/health"
+	"github.com/PaulFWatts/rest_api_golang/logging"
+	"github.com/PaulFWatts/rest_api_golang/mailer"
+	"github.com/PaulFWatts/rest_api_golang/metrics"
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/ratelimit"
 	"github.com/PaulFWatts/rest_api_golang/routes"
+	"github.com/PaulFWatts/rest_api_golang/server"
+	"github.com/PaulFWatts/rest_api_golang/tracing"
+	"github.com/PaulFWatts/rest_api_golang/utils"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	db.InitDB() // Initialize the database connection and create necessary tables
-	server := gin.Default() // Engine instance with default middleware (logger and recovery)
+	// Settings come from defaults, an optional config file, the environment and flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
+	}
 
-	routes.RegisterRoutes(server) // Register the routes defined in the routes package
+	logging.Init(cfg.Log) // Write structured logs that redact emails and tokens
 
-	server.Run(":8080") // Start the server on port 8080
-}
+	// Send spans to the configured exporter; they are flushed once the server has stopped
+	shutdownTracing, err := tracing.Init(cfg.Tracing)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration: tracing:", err)
+		os.Exit(2)
+	}
+
+	db.InitDB(cfg.Database)                 // Initialize the database connection and create necessary tables
+	health.Init(db.DB, cfg.Database)        // Point the readiness checks at the database
+	metrics.Init(db.DB)                     // Export the database pool statistics
+	ratelimit.Init(cfg.RateLimit, db.DB)    // Choose where rate limit buckets are kept
+	models.SetObserver(metrics.Observer{})  // Count signups, logins, events and registrations
+	utils.InitKeys(cfg.Auth)                // Load the JWT signing keys and token lifetimes
+	utils.InitPasswordHasher(cfg.Password)  // Choose the password hashing scheme
+	models.InitAdmins(cfg.Auth.AdminEmails) // Grant the admin role to the accounts listed in ADMIN_EMAILS
+	mailer.Init(cfg.Mail)                   // Choose the SMTP, file or in-memory mailer
+	router := gin.New()                     // Engine instance; RegisterRoutes adds logging, metrics and recovery middleware
+
+	// Only trust X-Forwarded-For from the configured proxies, so that clients
+	// cannot pick the IP address that login throttling and rate limiting see
+	err = router.SetTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration: server.trusted_proxies:", err)
+		os.Exit(2)
+	}
+
+	// Build the handlers on the database repositories and register their routes
+	api := &routes.Server{App: cfg.App, Limits: cfg.RateLimit, Repositories: models.NewSQLRepositories(db.DB, models.SearchIndex(db.SearchIndex))}
+	api.RegisterRoutes(router)
 
+	// Serve until SIGINT or SIGTERM, then fail readiness and let active requests finish.
+	// Metrics get a listener of their own, which is kept off the public network.
+	err = server.Run(cfg.Server, router, metrics.Handler(), health.StartDraining)
+	if err != nil {
+		slog.Error("Server error", "error", err)
+	}
 
+	// Emails queued by the last requests are still worth sending
+	if !mailer.Wait(cfg.Server.ShutdownTimeout) {
+		slog.Warn("Gave up waiting for emails that are still being sent")
+	}
+
+	// Export the spans of the last requests
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if traceErr := shutdownTracing(flushCtx); traceErr != nil {
+		slog.Error("Could not flush traces", "error", traceErr)
+	}
+
+	// Only close the database once nothing can use it any more
+	closeErr := db.Close()
+	if closeErr != nil {
+		slog.Error("Could not close database", "error", closeErr)
+	}
+
+	if err != nil || closeErr != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,151 @@
+// Package metrics exposes the API's Prometheus metrics.
+//
+// HTTP requests are counted and timed per route template, such as
+// "/events/:id", so the number of series does not grow with the number of
+// events. Database pool statistics are read from db.DB.Stats() on every
+// scrape, and business events reach the counters through Observer, which the
+// models call after recording them.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes the API's own metrics
+const namespace = "api"
+
+// unmatchedRoute labels requests that matched no route, such as 404s
+const unmatchedRoute = "unmatched"
+
+// registry holds every metric served by Handler
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to handle HTTP requests by method and route template.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"method", "route"})
+
+	httpInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being handled.",
+	})
+
+	signups = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "signups_total",
+		Help:      "Accounts created.",
+	})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Finished login attempts by result (success or failure) and reason.",
+	}, []string{"result", "reason"})
+
+	eventsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_created_total",
+		Help:      "Events created.",
+	})
+
+	registrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "Event registrations by status (registered or waitlisted).",
+	}, []string{"status"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, httpInFlight,
+		signups, logins, eventsCreated, registrations,
+	)
+
+	// Start the login results at zero so that rates work before the first failure
+	logins.WithLabelValues("success", "success")
+	logins.WithLabelValues("failure", "invalid_credentials")
+}
+
+// Init adds the connection pool statistics of the database to the metrics
+func Init(database *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(database, "api"))
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// RequestStarted counts a request as in flight until the returned function is called
+func RequestStarted() (finished func()) {
+	httpInFlight.Inc()
+	return httpInFlight.Dec
+}
+
+// ObserveRequest records a handled request
+//
+// The route is the template the request matched, or empty if it matched none.
+func ObserveRequest(method string, route string, status int, duration time.Duration) {
+	if route == "" {
+		route = unmatchedRoute
+		// Unmatched requests can carry any method; keep the label set bounded
+		if !knownMethods[method] {
+			method = "OTHER"
+		}
+	}
+
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// knownMethods are the HTTP methods kept as labels on unmatched requests
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// Observer counts business events reported by the models
+type Observer struct{}
+
+// UserSignedUp counts a new account
+func (Observer) UserSignedUp() {
+	signups.Inc()
+}
+
+// LoginAttempted counts a finished login attempt
+func (Observer) LoginAttempted(success bool, reason string) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	logins.WithLabelValues(result, reason).Inc()
+}
+
+// EventCreated counts a new event
+func (Observer) EventCreated() {
+	eventsCreated.Inc()
+}
+
+// UserRegistered counts a registration for an event
+func (Observer) UserRegistered(status string) {
+	registrations.WithLabelValues(status).Inc()
+}
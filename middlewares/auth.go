@@ -3,20 +3,24 @@ package middlewares
 import (
 	"net/http"
 
+	"github.com/PaulFWatts/rest_api_golang/logging"
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
 	"github.com/PaulFWatts/rest_api_golang/utils"
 	"github.com/gin-gonic/gin"
 )
 
-// Authenticate is a Gin middleware that validates JWT tokens and extracts user information
+// Authenticate returns a Gin middleware that validates JWT tokens and extracts user information
 //
 // This middleware intercepts HTTP requests to protected endpoints, validates the JWT token
-// from the Authorization header, and sets the authenticated user's ID in the Gin context
-// for use by downstream handlers.
+// from the Authorization header, and sets the authenticated user's ID, roles and
+// permissions in the Gin context for use by downstream handlers and RequirePermission.
 //
 // Security Features:
 //   - Validates JWT token signature and expiration
-//   - Extracts user ID from token claims
-//   - Sets user ID in Gin context for handler access
+//   - Rejects tokens issued before the user's tokens were invalidated (token version, read from users)
+//   - Extracts user ID, roles and permissions from token claims
+//   - Sets them in Gin context for handler access
 //   - Aborts request chain if authentication fails
 //
 // Request Headers Required:
@@ -26,6 +30,8 @@ import (
 // Context Values Set:
 //
 //	"userId" (int64): The authenticated user's ID extracted from the token
+//	"roles" ([]string): The user's role names
+//	"permissions" ([]string): The user's permissions
 //
 // Response Codes:
 //   - Continues to next handler if authentication succeeds
@@ -34,26 +40,74 @@ import (
 // Usage:
 //
 //	Apply to routes requiring authentication:
-//	server.POST("/events", middlewares.Authenticate, createEvent)
+//	server.POST("/events", middlewares.Authenticate(repositories.Users), createEvent)
 //
 // Error Handling:
 //   - Generic "Not authorized." message to prevent information disclosure
-//   - Uses AbortWithStatusJSON to stop the request chain
-func Authenticate(context *gin.Context) {
-	token := context.Request.Header.Get("Authorization")
+//   - Responds with problem details (problem.Respond), which stops the request chain
+func Authenticate(users models.UserRepository) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		token := context.Request.Header.Get("Authorization")
 
-	if token == "" {
-		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Not authorized."})
-		return
-	}
+		if token == "" {
+			problem.Respond(context, http.StatusUnauthorized, problem.CodeUnauthorized, "Not authorized.")
+			return
+		}
+
+		claims, err := utils.VerifyToken(token)
+
+		if err != nil {
+			problem.Respond(context, http.StatusUnauthorized, problem.CodeUnauthorized, "Not authorized.")
+			return
+		}
+
+		// A password reset bumps the version, which signs the user out everywhere
+		version, err := users.GetTokenVersion(claims.UserID)
 
-	userId, err := utils.VerifyToken(token)
+		if err != nil || version != claims.TokenVersion {
+			problem.Respond(context, http.StatusUnauthorized, problem.CodeUnauthorized, "Not authorized.")
+			return
+		}
 
-	if err != nil {
-		context.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Not authorized."})
-		return
+		context.Set("userId", claims.UserID)
+		context.Set("roles", claims.Roles)
+		context.Set("permissions", claims.Permissions)
+
+		context.Next()
 	}
+}
+
+// RequireVerifiedEmail returns a Gin middleware that only lets a request through
+// if the authenticated user has verified their email address
+//
+// It must run after Authenticate. The verification state is read from the
+// user repository rather than the token, so it takes effect as soon as the user
+// follows the link in their verification email.
+//
+// Response Codes:
+//   - Continues to next handler if the user's email address is verified
+//   - 403 Forbidden: The email address is not verified yet
+//   - 500 Internal Server Error: Database operation failed
+//
+// Usage:
+//
+//	authenticated.POST("/events", middlewares.RequireVerifiedEmail(repositories.Users), createEvent)
+func RequireVerifiedEmail(users models.UserRepository) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		verified, err := users.IsEmailVerified(context.GetInt64("userId"))
+
+		if err != nil {
+			logging.FromContext(context.Request.Context()).Error("Could not check email verification.",
+				"route", context.FullPath(), "userId", context.GetInt64("userId"), "error", err)
+			problem.Respond(context, http.StatusInternalServerError, problem.CodeInternal, "Could not check email verification.")
+			return
+		}
 
-	context.Set("userId", userId)
-	context.Next()
+		if !verified {
+			problem.Respond(context, http.StatusForbidden, problem.CodeEmailNotVerified, "Verify your email address first.")
+			return
+		}
+
+		context.Next()
+	}
 }
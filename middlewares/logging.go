@@ -0,0 +1,105 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/logging"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits the request IDs accepted from clients and proxies, so
+// that they cannot inject anything into the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID is a Gin middleware that gives every request an ID and a logger
+//
+// The ID is taken from the X-Request-ID header when a proxy or client already
+// set a valid one, and generated otherwise. It is echoed in the response
+// header and attached to a per-request logger, which handlers retrieve with
+// logging.FromContext(context.Request.Context()). When the request is traced,
+// the logger also carries the trace ID, so it must run after Tracing.
+//
+// Context Values Set:
+//
+//	"requestId" (string): The request ID
+func RequestID(context *gin.Context) {
+	id := context.GetHeader(RequestIDHeader)
+	if !validRequestID.MatchString(id) {
+		id = newRequestID()
+	}
+
+	context.Set("requestId", id)
+	context.Header(RequestIDHeader, id)
+
+	logger := slog.Default().With("requestId", id)
+	if span := trace.SpanContextFromContext(context.Request.Context()); span.IsValid() {
+		logger = logger.With("traceId", span.TraceID().String())
+	}
+	context.Request = context.Request.WithContext(logging.NewContext(context.Request.Context(), logger))
+
+	context.Next()
+}
+
+// newRequestID returns 16 random bytes in hex
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	_, _ = rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// RequestLogger is a Gin middleware that logs one line per request
+//
+// It replaces Gin's text logger. Only the path is logged, never the query
+// string, because links such as /verify-email carry tokens in it. It must run
+// after RequestID.
+func RequestLogger(context *gin.Context) {
+	start := time.Now()
+	context.Next()
+
+	status := context.Writer.Status()
+	level := slog.LevelInfo
+	if status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+
+	attrs := []any{
+		"method", context.Request.Method,
+		"path", context.Request.URL.Path,
+		"route", context.FullPath(),
+		"status", status,
+		"durationMs", time.Since(start).Milliseconds(),
+		"bytes", context.Writer.Size(),
+		"clientIp", context.ClientIP(),
+	}
+	if userId, ok := context.Get("userId"); ok {
+		attrs = append(attrs, "userId", userId)
+	}
+
+	logging.FromContext(context.Request.Context()).Log(context.Request.Context(), level, "request", attrs...)
+}
+
+// Recovery is a Gin middleware that turns a panicking handler into a 500
+// response and logs the panic with its stack trace
+//
+// It must run after RequestID, so that the panic is logged with the request ID.
+var Recovery = gin.CustomRecoveryWithWriter(io.Discard, func(context *gin.Context, recovered any) {
+	logging.FromContext(context.Request.Context()).Error("panic while handling request",
+		"route", context.FullPath(),
+		"panic", fmt.Sprint(recovered),
+		"stack", string(debug.Stack()),
+	)
+	problem.Respond(context, http.StatusInternalServerError, problem.CodeInternal, "Something went wrong. Try again later.")
+})
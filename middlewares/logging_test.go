@@ -0,0 +1,130 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/logging"
+	"github.com/gin-gonic/gin"
+)
+
+// captureLogs makes a JSON logger writing to the returned buffer the default until the test ends
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(logging.NewHandler(&buffer, config.Log{Level: "info", Format: "json"})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buffer
+}
+
+// logLines decodes the JSON log lines in the buffer
+func logLines(t *testing.T, buffer *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var lines []map[string]any
+	for _, raw := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		var line map[string]any
+		err := json.Unmarshal([]byte(raw), &line)
+		if err != nil {
+			t.Fatalf("decoding log line %q: %v", raw, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+var generatedRequestID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+func TestRequestIDReachesResponsesAndLogs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		header string
+		want   string // Empty for a generated ID
+	}{
+		{"", ""},
+		{"edge-7f3a:42.b_c", "edge-7f3a:42.b_c"},
+		{"bad id\nlevel=ERROR", ""},
+		{strings.Repeat("a", 129), ""},
+	}
+
+	for _, test := range tests {
+		buffer := captureLogs(t)
+
+		router := gin.New()
+		router.Use(RequestID, RequestLogger)
+		router.GET("/things/:id", func(context *gin.Context) {
+			logging.FromContext(context.Request.Context()).Info("handling", "thing", context.Param("id"))
+			context.Status(http.StatusNoContent)
+		})
+
+		request := httptest.NewRequest(http.MethodGet, "/things/7?token=secret", nil)
+		if test.header != "" {
+			request.Header.Set(RequestIDHeader, test.header)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		id := recorder.Header().Get(RequestIDHeader)
+		if test.want != "" && id != test.want || test.want == "" && !generatedRequestID.MatchString(id) {
+			t.Errorf("header %q: response request ID %q, want %q or a generated one", test.header, id, test.want)
+		}
+
+		lines := logLines(t, buffer)
+		if len(lines) != 2 {
+			t.Fatalf("header %q: logged %d lines, want the handler's and the request's", test.header, len(lines))
+		}
+		for _, line := range lines {
+			if line["requestId"] != id {
+				t.Errorf("header %q: %q logged with request ID %v, want %q", test.header, line["msg"], line["requestId"], id)
+			}
+		}
+
+		requestLine := lines[1]
+		if requestLine["msg"] != "request" || requestLine["path"] != "/things/7" || requestLine["route"] != "/things/:id" || requestLine["status"] != float64(http.StatusNoContent) {
+			t.Errorf("request log line = %v", requestLine)
+		}
+		if strings.Contains(buffer.String(), "secret") {
+			t.Errorf("the query string was logged: %s", buffer.String())
+		}
+	}
+}
+
+func TestRecoveryLogsPanicsWithTheRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	buffer := captureLogs(t)
+
+	router := gin.New()
+	router.Use(RequestID, RequestLogger, Recovery)
+	router.GET("/panic", func(context *gin.Context) { panic("refresh token " + strings.Repeat("x", 43) + " broke") })
+
+	request := httptest.NewRequest(http.MethodGet, "/panic", nil)
+	request.Header.Set(RequestIDHeader, "panic-1")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want 500", recorder.Code)
+	}
+
+	lines := logLines(t, buffer)
+	if len(lines) != 2 {
+		t.Fatalf("logged %d lines, want the panic and the request", len(lines))
+	}
+	panicLine := lines[0]
+	if panicLine["requestId"] != "panic-1" || panicLine["level"] != "ERROR" || panicLine["panic"] != "refresh token [REDACTED] broke" {
+		t.Errorf("panic log line = %v", panicLine)
+	}
+	if lines[1]["status"] != float64(http.StatusInternalServerError) || lines[1]["level"] != "ERROR" {
+		t.Errorf("request log line = %v", lines[1])
+	}
+}
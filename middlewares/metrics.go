@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics is a Gin middleware that counts and times every request for the
+// Prometheus metrics
+//
+// Requests are labelled with the route template they matched, such as
+// "/events/:id", rather than the raw path, so the number of series stays
+// bounded. Requests that match no route share the "unmatched" label.
+//
+// Usage:
+//
+//	Register on the engine before any route, so that every route is measured:
+//	server.Use(middlewares.Metrics)
+func Metrics(context *gin.Context) {
+	finished := metrics.RequestStarted()
+	defer finished()
+
+	start := time.Now()
+	context.Next()
+
+	metrics.ObserveRequest(context.Request.Method, context.FullPath(), context.Writer.Status(), time.Since(start))
+}
@@ -0,0 +1,42 @@
+package middlewares
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission returns a Gin middleware that only lets a request through
+// if the authenticated user holds at least one of the given permissions
+//
+// It must run after Authenticate, which places the permissions from the JWT
+// claims in the Gin context. Passing several permissions is useful for routes
+// that accept either a scoped and an unscoped grant, such as "events:update:own"
+// and "events:update:any"; the handler then decides which one applies.
+//
+// Response Codes:
+//   - Continues to next handler if the user holds one of the permissions
+//   - 403 Forbidden: The user holds none of the permissions
+//
+// Usage:
+//
+//	authenticated.DELETE("/events/:id", middlewares.RequirePermission("events:delete:own", "events:delete:any"), deleteEvent)
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		for _, permission := range permissions {
+			if HasPermission(context, permission) {
+				context.Next()
+				return
+			}
+		}
+
+		problem.Respond(context, http.StatusForbidden, problem.CodeForbidden, "Forbidden.")
+	}
+}
+
+// HasPermission reports whether the authenticated user holds the given permission
+func HasPermission(context *gin.Context, permission string) bool {
+	return slices.Contains(context.GetStringSlice("permissions"), permission)
+}
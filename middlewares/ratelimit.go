@@ -0,0 +1,96 @@
+package middlewares
+
+import (
+	stdcontext "context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/logging"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/PaulFWatts/rest_api_golang/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit returns a Gin middleware that limits each client to the rate for
+// the named group of routes
+//
+// Clients are identified by user ID when Authenticate ran earlier in the
+// chain and by client IP otherwise, and every route in the group shares one
+// bucket per client. A disabled ("off") rate returns a middleware that does
+// nothing.
+//
+// Response Headers (draft-ietf-httpapi-ratelimit-headers):
+//
+//	RateLimit-Limit: Requests allowed in a burst
+//	RateLimit-Remaining: Requests left before the client is limited
+//	RateLimit-Reset: Seconds until the full burst is available again
+//	RateLimit-Policy: The configured rate, e.g. "10;w=60" for 10 per minute
+//	Retry-After: Seconds to wait, on 429 responses only
+//
+// Response Codes:
+//   - Continues to next handler if the client is within the rate
+//   - 429 Too Many Requests: The client must wait before trying again
+//
+// Usage:
+//
+//	public := server.Group("/")
+//	public.Use(middlewares.RateLimit("public", limits.Public))
+//
+// Error Handling:
+//   - If the bucket store fails, the request is let through and the error
+//     logged, so an unavailable store cannot take the API down
+func RateLimit(name string, rate config.Rate) gin.HandlerFunc {
+	return rateLimit(name, rate, ratelimit.Take)
+}
+
+// SharedRateLimit is RateLimit with the buckets in the configured store, which
+// every instance shares when it is the database; see ratelimit.Init
+//
+// It costs a database write per request with the database store, so it suits
+// rarely called routes where limits must hold across instances, such as login.
+func SharedRateLimit(name string, rate config.Rate) gin.HandlerFunc {
+	return rateLimit(name, rate, ratelimit.TakeShared)
+}
+
+func rateLimit(name string, rate config.Rate, take func(ctx stdcontext.Context, key string, rate config.Rate) (ratelimit.Result, error)) gin.HandlerFunc {
+	if rate.Off() {
+		return func(context *gin.Context) { context.Next() }
+	}
+
+	policy := strconv.Itoa(rate.Limit) + ";w=" + strconv.Itoa(seconds(rate.Period))
+
+	return func(context *gin.Context) {
+		key := name + ":ip:" + context.ClientIP()
+		if userId, ok := context.Get("userId"); ok {
+			key = name + ":user:" + strconv.FormatInt(userId.(int64), 10)
+		}
+
+		result, err := take(context.Request.Context(), key, rate)
+		if err != nil {
+			logging.FromContext(context.Request.Context()).Error("Could not check rate limit; allowing request", "policy", name, "error", err)
+			context.Next()
+			return
+		}
+
+		context.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		context.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		context.Header("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		context.Header("RateLimit-Policy", policy)
+
+		if !result.Allowed {
+			context.Header("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			problem.Respond(context, http.StatusTooManyRequests, problem.CodeRateLimited, "Too many requests. Try again later.")
+			return
+		}
+
+		context.Next()
+	}
+}
+
+// seconds rounds a duration up to whole seconds, as the rate limit headers need
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
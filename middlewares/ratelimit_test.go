@@ -0,0 +1,142 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// limitedRouter serves 200s on /a and /b, each limited to the rate in a group
+// of its own, with the user ID taken from the X-User header when there is one
+func limitedRouter(t *testing.T, rate config.Rate) *gin.Engine {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	ratelimit.Use(ratelimit.NewMemoryStore(), ratelimit.NewMemoryStore())
+	t.Cleanup(func() { ratelimit.Use(nil, nil) })
+
+	router := gin.New()
+	router.Use(func(context *gin.Context) {
+		if user := context.GetHeader("X-User"); user != "" {
+			userId, _ := strconv.ParseInt(user, 10, 64)
+			context.Set("userId", userId)
+		}
+	})
+	ok := func(context *gin.Context) { context.Status(http.StatusOK) }
+	router.GET("/a", RateLimit("a", rate), ok)
+	router.GET("/b", RateLimit("b", rate), ok)
+	return router
+}
+
+// get sends a GET from the client address, as the user when user is not empty
+func get(router *gin.Engine, path string, address string, user string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.RemoteAddr = address + ":1234"
+	if user != "" {
+		request.Header.Set("X-User", user)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	router := limitedRouter(t, config.Rate{Limit: 2, Period: time.Minute})
+
+	for i, remaining := range []string{"1", "0"} {
+		recorder := get(router, "/a", "192.0.2.1", "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i+1, recorder.Code)
+		}
+		headers := map[string]string{
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": remaining,
+			"RateLimit-Policy":    "2;w=60",
+		}
+		for name, want := range headers {
+			if got := recorder.Header().Get(name); got != want {
+				t.Errorf("request %d: %s = %q, want %q", i+1, name, got, want)
+			}
+		}
+		if reset, err := strconv.Atoi(recorder.Header().Get("RateLimit-Reset")); err != nil || reset <= 0 || reset > 60 {
+			t.Errorf("request %d: RateLimit-Reset = %q, want 1 to 60 seconds", i+1, recorder.Header().Get("RateLimit-Reset"))
+		}
+		if recorder.Header().Get("Retry-After") != "" {
+			t.Errorf("request %d: Retry-After set on an allowed request", i+1)
+		}
+	}
+
+	recorder := get(router, "/a", "192.0.2.1", "")
+	if recorder.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past the limit: status %d, want 429", recorder.Code)
+	}
+	if got := recorder.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("request past the limit: RateLimit-Remaining = %q, want 0", got)
+	}
+	if retry, err := strconv.Atoi(recorder.Header().Get("Retry-After")); err != nil || retry <= 0 || retry > 30 {
+		t.Errorf("request past the limit: Retry-After = %q, want 1 to 30 seconds", recorder.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	router := limitedRouter(t, config.Rate{Limit: 1, Period: time.Hour})
+
+	requests := []struct {
+		description string
+		path        string
+		address     string
+		user        string
+		want        int
+	}{
+		{"first request", "/a", "192.0.2.1", "", http.StatusOK},
+		{"same client and group", "/a", "192.0.2.1", "", http.StatusTooManyRequests},
+		{"same client, other group", "/b", "192.0.2.1", "", http.StatusOK},
+		{"other client address", "/a", "192.0.2.2", "", http.StatusOK},
+		{"user at a limited address", "/a", "192.0.2.1", "7", http.StatusOK},
+		{"same user at another address", "/a", "192.0.2.3", "7", http.StatusTooManyRequests},
+		{"other user at the same address", "/a", "192.0.2.3", "8", http.StatusOK},
+	}
+
+	for _, request := range requests {
+		recorder := get(router, request.path, request.address, request.user)
+		if recorder.Code != request.want {
+			t.Errorf("%s: status %d, want %d", request.description, recorder.Code, request.want)
+		}
+	}
+}
+
+func TestSharedRateLimitUsesTheSharedStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	local, shared := ratelimit.NewMemoryStore(), ratelimit.NewMemoryStore()
+	ratelimit.Use(local, shared)
+	t.Cleanup(func() { ratelimit.Use(nil, nil) })
+
+	rate := config.Rate{Limit: 1, Period: time.Hour}
+	router := gin.New()
+	router.GET("/login", SharedRateLimit("accounts", rate), func(context *gin.Context) { context.Status(http.StatusOK) })
+
+	if recorder := get(router, "/login", "192.0.2.1", ""); recorder.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", recorder.Code)
+	}
+
+	result, err := shared.Take(t.Context(), "accounts:ip:192.0.2.1", rate)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if result.Allowed {
+		t.Error("the shared store's bucket is still full after a request")
+	}
+	result, err = local.Take(t.Context(), "accounts:ip:192.0.2.1", rate)
+	if err != nil {
+		t.Fatalf("Take: %v", err)
+	}
+	if !result.Allowed {
+		t.Error("the request took a token from the in-memory store")
+	}
+}
@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the server span of every request
+var tracer = otel.Tracer("github.com/PaulFWatts/rest_api_golang/middlewares")
+
+// Tracing is a Gin middleware that wraps every request in an OpenTelemetry server span
+//
+// A W3C traceparent header continues the caller's trace; otherwise a new trace
+// starts. The span is named after the route template, such as
+// "GET /events/:id", and the request context carries it, so spans started by
+// the models and password hashing become its children. Responses with a 5xx
+// status mark the span as failed.
+//
+// Usage:
+//
+//	Register first, so the span covers the other middleware too:
+//	server.Use(middlewares.Tracing)
+func Tracing(context *gin.Context) {
+	request := context.Request
+	ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+
+	route := context.FullPath()
+	name := request.Method + " " + route
+	if route == "" {
+		name = request.Method
+	}
+
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(request.Method),
+			semconv.HTTPRoute(route),
+			semconv.URLPath(request.URL.Path),
+			semconv.ClientAddress(context.ClientIP()),
+			semconv.UserAgentOriginal(request.UserAgent()),
+		),
+	)
+	defer span.End()
+
+	context.Request = request.WithContext(ctx)
+	context.Next()
+
+	status := context.Writer.Status()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if userId, ok := context.Get("userId"); ok {
+		span.SetAttributes(semconv.EnduserID(strconv.FormatInt(userId.(int64), 10)))
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
@@ -0,0 +1,386 @@
+// Package migrations applies versioned, checksummed schema changes to the database.
+//
+// Each migration is a pair of SQL files named "<version>_<name>.up.sql" and
+// "<version>_<name>.down.sql", embedded into the binary at build time. Applied
+// migrations are recorded in the schema_migrations table together with a
+// checksum of both files, so edits to a migration that has already run are
+// detected instead of silently diverging from the databases it was applied to.
+//
+// SQLite and PostgreSQL each have their own migrations, in the sqlite and
+// postgres directories. Both must have the same versions and names, so that
+// a change to the schema is made for both backends at once.
+//
+// On PostgreSQL, Up and Down hold an advisory lock while they run, so that
+// instances started at the same time migrate one after the other.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed sqlite/*.sql postgres/*.sql
+var files embed.FS
+
+// fileName matches migration files such as "0001_initial_schema.up.sql"
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// Status describes a migration and whether it has been applied
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// ErrTampered is returned when the applied history does not match the embedded migrations
+var ErrTampered = errors.New("migration history does not match the embedded migrations")
+
+// lockKey identifies the PostgreSQL advisory lock held while migrating
+const lockKey int64 = 0x6d69677261746573 // "migrates"
+
+// handle is what the runner's queries run on: the database, or the single
+// connection holding the migration lock
+type handle interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// Runner applies an ordered list of migrations to a database
+type Runner struct {
+	db         *sql.DB
+	handle     handle
+	driver     string
+	migrations []Migration
+}
+
+// New returns a Runner for the embedded migrations of the driver, sqlite or postgres
+//
+// The database must have been opened by db.Open, since the runner's own
+// queries use ? placeholders.
+func New(db *sql.DB, driver string) (*Runner, error) {
+	if driver != "sqlite" && driver != "postgres" {
+		return nil, fmt.Errorf("no migrations for database driver %q", driver)
+	}
+
+	migrations, err := Load(files, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Runner{db: db, handle: db, driver: driver, migrations: migrations}, nil
+}
+
+// Load reads every migration in dir, sorted by version
+//
+// Every version must have both an up and a down file, and versions must be unique.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %q", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names", version)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+
+		sum := sha256.Sum256([]byte(migration.Up + "\x00" + migration.Down))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// applied is a row of the schema_migrations table
+type applied struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func (r *Runner) ensureTable() error {
+	timestamp := "DATETIME"
+	if r.driver == "postgres" {
+		timestamp = "TIMESTAMP"
+	}
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at ` + timestamp + ` NOT NULL
+	)`
+
+	_, err := r.handle.ExecContext(context.Background(), query)
+	return err
+}
+
+func (r *Runner) history() ([]applied, error) {
+	rows, err := r.handle.QueryContext(context.Background(), "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []applied
+
+	for rows.Next() {
+		var row applied
+		err := rows.Scan(&row.version, &row.name, &row.checksum, &row.appliedAt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, row)
+	}
+
+	return history, rows.Err()
+}
+
+// verify checks that the applied history is exactly a prefix of the known migrations
+//
+// This rejects migrations that were edited, renamed or removed after being
+// applied, as well as a database that is ahead of this binary.
+func (r *Runner) verify(history []applied) error {
+	for i, row := range history {
+		if i >= len(r.migrations) {
+			return fmt.Errorf("%w: version %d is applied but unknown to this build", ErrTampered, row.version)
+		}
+
+		migration := r.migrations[i]
+		if migration.Version != row.version {
+			return fmt.Errorf("%w: expected version %d, found %d", ErrTampered, migration.Version, row.version)
+		}
+		if migration.Name != row.name || migration.Checksum != row.checksum {
+			return fmt.Errorf("%w: version %d (%s) has changed since it was applied", ErrTampered, row.version, row.name)
+		}
+	}
+
+	return nil
+}
+
+// Pending returns the migrations that have not been applied yet
+func (r *Runner) Pending() ([]Migration, error) {
+	err := r.ensureTable()
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := r.history()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.verify(history)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.migrations[len(history):], nil
+}
+
+// Up applies every pending migration in order, each in its own transaction
+//
+// Returns the number of migrations applied. If the applied history has been
+// tampered with, nothing is applied and an error wrapping ErrTampered is returned.
+// Another instance migrating at the same time is waited for, after which
+// only the migrations it did not apply are pending.
+func (r *Runner) Up() (count int, err error) {
+	err = r.locked(func(r *Runner) error {
+		count, err = r.up()
+		return err
+	})
+	return count, err
+}
+
+func (r *Runner) up() (int, error) {
+	pending, err := r.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, migration := range pending {
+		err := r.run(migration.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec("INSERT INTO schema_migrations(version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+				migration.Version, migration.Name, migration.Checksum, time.Now().UTC())
+			return err
+		})
+
+		if err != nil {
+			return i, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return len(pending), nil
+}
+
+// Down reverts the most recently applied migrations, newest first
+func (r *Runner) Down(steps int) (count int, err error) {
+	err = r.locked(func(r *Runner) error {
+		count, err = r.down(steps)
+		return err
+	})
+	return count, err
+}
+
+func (r *Runner) down(steps int) (int, error) {
+	pending, err := r.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	appliedCount := len(r.migrations) - len(pending)
+	reverted := 0
+
+	for reverted < steps && appliedCount > 0 {
+		migration := r.migrations[appliedCount-1]
+
+		err := r.run(migration.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+			return err
+		})
+
+		if err != nil {
+			return reverted, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		appliedCount--
+		reverted++
+	}
+
+	return reverted, nil
+}
+
+// locked calls f with a runner working on a connection that holds the
+// migration lock, waiting for the lock if another instance has it
+//
+// SQLite has no such lock, and f runs on the database as it is.
+func (r *Runner) locked(f func(r *Runner) error) error {
+	if r.driver != "postgres" {
+		return f(r)
+	}
+
+	ctx := context.Background()
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", lockKey)
+	if err != nil {
+		return fmt.Errorf("taking the migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock(?)", lockKey)
+
+	locked := *r
+	locked.handle = conn
+	return f(&locked)
+}
+
+func (r *Runner) run(script string, record func(tx *sql.Tx) error) error {
+	tx, err := r.handle.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(script)
+	if err != nil {
+		return err
+	}
+
+	err = record(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Status lists every known migration and when it was applied
+func (r *Runner) Status() ([]Status, error) {
+	err := r.ensureTable()
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := r.history()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.verify(history)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(r.migrations))
+
+	for i, migration := range r.migrations {
+		statuses[i].Migration = migration
+		if i < len(history) {
+			appliedAt := history[i].appliedAt
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,329 @@
+// The tests are in an external package because dbtest, which opens their
+// databases, migrates them with this package
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/db/dbtest"
+	"github.com/PaulFWatts/rest_api_golang/migrations"
+	"github.com/PaulFWatts/rest_api_golang/models"
+)
+
+// newRunner returns a runner for the driver's migrations on the database
+func newRunner(t *testing.T, database *sql.DB, driver string) *migrations.Runner {
+	t.Helper()
+
+	runner, err := migrations.New(database, driver)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return runner
+}
+
+// migrate applies every migration to a new database of the driver and returns
+// the database and how many migrations there are
+func migrate(t *testing.T, driver string) (*sql.DB, int) {
+	t.Helper()
+
+	database := dbtest.OpenUnmigrated(t, driver)
+	count, err := newRunner(t, database, driver).Up()
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	return database, count
+}
+
+// appliedCount returns how many migrations Status reports as applied
+func appliedCount(t *testing.T, runner *migrations.Runner) int {
+	t.Helper()
+
+	statuses, err := runner.Status()
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	applied := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			applied++
+		}
+	}
+	return applied
+}
+
+func TestUpAndDown(t *testing.T) {
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			database, total := migrate(t, driver)
+			runner := newRunner(t, database, driver)
+
+			if applied := appliedCount(t, runner); total == 0 || applied != total {
+				t.Fatalf("Up applied %d migrations and Status reports %d, want every one", total, applied)
+			}
+			if count, err := runner.Up(); count != 0 || err != nil {
+				t.Errorf("Up on a migrated database = %d, %v, want nothing applied", count, err)
+			}
+
+			// Each step down reverts the newest migration, and up applies it again
+			for _, steps := range []int{1, 3} {
+				count, err := runner.Down(steps)
+				if count != steps || err != nil {
+					t.Fatalf("Down(%d) = %d, %v", steps, count, err)
+				}
+				if applied := appliedCount(t, runner); applied != total-steps {
+					t.Errorf("after Down(%d) %d migrations are applied, want %d", steps, applied, total-steps)
+				}
+				count, err = runner.Up()
+				if count != steps || err != nil {
+					t.Fatalf("Up after Down(%d) = %d, %v, want %d", steps, count, err, steps)
+				}
+			}
+
+			// Down past the first migration stops there and drops every table
+			count, err := runner.Down(total + 1)
+			if count != total || err != nil {
+				t.Fatalf("Down(%d) = %d, %v, want %d", total+1, count, err, total)
+			}
+			if applied := appliedCount(t, runner); applied != 0 {
+				t.Errorf("after reverting everything %d migrations are applied", applied)
+			}
+			for _, table := range []string{"users", "events", "registrations", "rate_limits"} {
+				if _, err := database.Exec("SELECT COUNT(*) FROM " + table); err == nil {
+					t.Errorf("table %s still exists after reverting every migration", table)
+				}
+			}
+
+			if count, err := runner.Up(); count != total || err != nil {
+				t.Errorf("Up after reverting everything = %d, %v, want %d", count, err, total)
+			}
+		})
+	}
+}
+
+func TestTamperedHistoryIsRejected(t *testing.T) {
+	tamperings := map[string]string{
+		"edited migration":     "UPDATE schema_migrations SET checksum = 'edited' WHERE version = 2",
+		"renamed migration":    "UPDATE schema_migrations SET name = 'renamed' WHERE version = 3",
+		"removed migration":    "DELETE FROM schema_migrations WHERE version = 2",
+		"newer than the build": "INSERT INTO schema_migrations(version, name, checksum, applied_at) VALUES (9999, 'future', 'unknown', CURRENT_TIMESTAMP)",
+	}
+
+	for _, driver := range dbtest.Drivers() {
+		for name, tampering := range tamperings {
+			t.Run(driver+"/"+name, func(t *testing.T) {
+				database, _ := migrate(t, driver)
+				_, err := database.Exec(tampering)
+				if err != nil {
+					t.Fatalf("tampering with the history: %v", err)
+				}
+				runner := newRunner(t, database, driver)
+
+				if _, err := runner.Up(); !errors.Is(err, migrations.ErrTampered) {
+					t.Errorf("Up: err = %v, want %v", err, migrations.ErrTampered)
+				}
+				if _, err := runner.Down(1); !errors.Is(err, migrations.ErrTampered) {
+					t.Errorf("Down: err = %v, want %v", err, migrations.ErrTampered)
+				}
+				if _, err := runner.Pending(); !errors.Is(err, migrations.ErrTampered) {
+					t.Errorf("Pending: err = %v, want %v", err, migrations.ErrTampered)
+				}
+				if _, err := runner.Status(); !errors.Is(err, migrations.ErrTampered) {
+					t.Errorf("Status: err = %v, want %v", err, migrations.ErrTampered)
+				}
+			})
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"test/0002_second.up.sql":   {Data: []byte("CREATE TABLE b (id INTEGER);")},
+		"test/0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"test/0001_first.up.sql":    {Data: []byte("CREATE TABLE a (id INTEGER);")},
+		"test/0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+	}
+
+	loaded, err := migrations.Load(fsys, "test")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded) != 2 || loaded[0].Version != 1 || loaded[0].Name != "first" || loaded[1].Version != 2 || loaded[1].Name != "second" {
+		t.Fatalf("Load = %+v, want first and second in order", loaded)
+	}
+	if loaded[0].Up != "CREATE TABLE a (id INTEGER);" || loaded[0].Down != "DROP TABLE a;" {
+		t.Errorf("first migration = %+v", loaded[0])
+	}
+
+	// Editing either file of a migration changes its checksum
+	for _, file := range []string{"test/0001_first.up.sql", "test/0001_first.down.sql"} {
+		edited := fstest.MapFS{}
+		for name, content := range fsys {
+			edited[name] = content
+		}
+		edited[file] = &fstest.MapFile{Data: append(slices.Clone(fsys[file].Data), ' ')}
+
+		reloaded, err := migrations.Load(edited, "test")
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if reloaded[0].Checksum == loaded[0].Checksum {
+			t.Errorf("editing %s left the checksum unchanged", file)
+		}
+		if reloaded[1].Checksum != loaded[1].Checksum {
+			t.Errorf("editing %s changed another migration's checksum", file)
+		}
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"missing down file": {"test/0001_first.up.sql": {Data: []byte("SELECT 1;")}},
+		"different names": {
+			"test/0001_first.up.sql":   {Data: []byte("SELECT 1;")},
+			"test/0001_other.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"unexpected file": {"test/README.md": {Data: []byte("notes")}},
+	}
+	for name, fsys := range invalid {
+		if _, err := migrations.Load(fsys, "test"); err == nil {
+			t.Errorf("%s: Load succeeded", name)
+		}
+	}
+}
+
+// TestDialectsMatch checks that every schema change was made for both backends
+func TestDialectsMatch(t *testing.T) {
+	sqlite, err := migrations.Load(os.DirFS("."), "sqlite")
+	if err != nil {
+		t.Fatalf("Load sqlite: %v", err)
+	}
+	postgres, err := migrations.Load(os.DirFS("."), "postgres")
+	if err != nil {
+		t.Fatalf("Load postgres: %v", err)
+	}
+
+	names := func(loaded []migrations.Migration) []string {
+		var names []string
+		for _, migration := range loaded {
+			names = append(names, fmt.Sprintf("%04d_%s", migration.Version, migration.Name))
+		}
+		return names
+	}
+	if !slices.Equal(names(sqlite), names(postgres)) {
+		t.Errorf("sqlite migrations %v, postgres migrations %v, want the same", names(sqlite), names(postgres))
+	}
+}
+
+// TestConcurrentUp starts several instances' migrations at once; only
+// PostgreSQL has a migration lock, so it needs TEST_DATABASE_URL
+func TestConcurrentUp(t *testing.T) {
+	for _, driver := range dbtest.Drivers() {
+		if driver != "postgres" {
+			continue
+		}
+
+		t.Run(driver, func(t *testing.T) {
+			database := dbtest.OpenUnmigrated(t, driver)
+
+			const instances = 4
+			counts := make([]int, instances)
+			errs := make([]error, instances)
+
+			var wg sync.WaitGroup
+			for i := range instances {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					counts[i], errs[i] = newRunner(t, database, driver).Up()
+				}()
+			}
+			wg.Wait()
+
+			statuses, err := newRunner(t, database, driver).Status()
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+
+			applied := 0
+			for i := range instances {
+				if errs[i] != nil {
+					t.Errorf("instance %d: Up: %v", i, errs[i])
+				}
+				applied += counts[i]
+			}
+			if applied != len(statuses) {
+				t.Errorf("instances applied %d migrations in total, want each of the %d once", applied, len(statuses))
+			}
+			for _, status := range statuses {
+				if status.AppliedAt == nil {
+					t.Errorf("migration %d_%s was not applied", status.Version, status.Name)
+				}
+			}
+		})
+	}
+}
+
+// TestLegacyEventTimesMoveToUTC migrates a database from before migrations
+// whose events were saved with the offsets they were sent with
+func TestLegacyEventTimesMoveToUTC(t *testing.T) {
+	database := dbtest.OpenUnmigrated(t, "sqlite")
+
+	_, err := database.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT NOT NULL UNIQUE, password TEXT NOT NULL);
+		CREATE TABLE events (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, description TEXT NOT NULL,
+			location TEXT NOT NULL, dateTime DATETIME NOT NULL, user_id INTEGER, FOREIGN KEY(user_id) REFERENCES users(id));
+		INSERT INTO users(email, password) VALUES ('owner@example.com', 'x');
+		INSERT INTO events(name, description, location, dateTime, user_id) VALUES
+			('Berlin', 'Legacy', 'Hall', '2030-03-04 20:00:00+02:00', 1),
+			('UTC', 'Legacy', 'Hall', '2030-03-04 18:30:00+00:00', 1),
+			('New York', 'Legacy', 'Hall', '2030-03-04 13:15:00.25-05:00', 1),
+			('Auckland', 'Legacy', 'Hall', '2030-03-05T06:45:00+13:00', 1)`)
+	if err != nil {
+		t.Fatalf("creating the legacy schema: %v", err)
+	}
+
+	_, err = newRunner(t, database, "sqlite").Up()
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	rows, err := database.Query("SELECT dateTime || '' FROM events ORDER BY id")
+	if err != nil {
+		t.Fatalf("reading event times: %v", err)
+	}
+	defer rows.Close()
+	var stored []string
+	for rows.Next() {
+		var dateTime string
+		if err := rows.Scan(&dateTime); err != nil {
+			t.Fatalf("reading event times: %v", err)
+		}
+		stored = append(stored, dateTime)
+	}
+	want := []string{"2030-03-04 18:00:00+00:00", "2030-03-04 18:30:00+00:00", "2030-03-04 18:15:00.25+00:00", "2030-03-04 17:45:00+00:00"}
+	if !slices.Equal(stored, want) {
+		t.Errorf("stored event times = %q, want %q", stored, want)
+	}
+
+	// Filters and sorting compare the stored text, which now orders by instant
+	repositories := models.NewSQLRepositories(database, "")
+	from := time.Date(2030, time.March, 4, 18, 0, 0, 0, time.UTC)
+	page, err := repositories.Events.List(context.Background(), models.EventFilter{From: from}, models.PageRequest{Sort: "dateTime"})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var names []string
+	for _, event := range page.Items {
+		names = append(names, event.Name)
+	}
+	if wantNames := []string{"Berlin", "New York", "UTC"}; !slices.Equal(names, wantNames) {
+		t.Errorf("events from %s = %v, want %v", from, names, wantNames)
+	}
+}
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS registrations_event_status;
+DROP INDEX IF EXISTS registrations_event_user;
+
+ALTER TABLE registrations DROP COLUMN created_at;
+ALTER TABLE registrations DROP COLUMN status;
+
+ALTER TABLE events DROP COLUMN capacity;
@@ -0,0 +1,14 @@
+DROP INDEX IF EXISTS registrations_event_occurrence_status;
+DROP INDEX IF EXISTS registrations_event_occurrence_user;
+
+-- Registrations for single occurrences have no equivalent without recurrence
+DELETE FROM registrations WHERE occurrence != '';
+ALTER TABLE registrations DROP COLUMN occurrence;
+
+CREATE UNIQUE INDEX IF NOT EXISTS registrations_event_user ON registrations(event_id, user_id);
+CREATE INDEX IF NOT EXISTS registrations_event_status ON registrations(event_id, status, id);
+
+DROP TABLE IF EXISTS event_occurrences;
+
+ALTER TABLE events DROP COLUMN exdates;
+ALTER TABLE events DROP COLUMN rrule;
@@ -0,0 +1,24 @@
+ALTER TABLE events ADD COLUMN rrule TEXT NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN exdates TEXT NOT NULL DEFAULT '';
+
+-- Per-occurrence changes to a recurring event, keyed by the occurrence's
+-- original start in RFC 3339 UTC. NULL columns inherit from the series.
+CREATE TABLE IF NOT EXISTS event_occurrences (
+	event_id BIGINT NOT NULL,
+	occurrence TEXT NOT NULL,
+	cancelled INTEGER NOT NULL DEFAULT 0,
+	name TEXT,
+	description TEXT,
+	location TEXT,
+	dateTime TIMESTAMP,
+	PRIMARY KEY(event_id, occurrence),
+	FOREIGN KEY(event_id) REFERENCES events(id)
+);
+
+-- Registrations for a recurring event belong to one occurrence; '' is the whole event
+ALTER TABLE registrations ADD COLUMN occurrence TEXT NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS registrations_event_status;
+DROP INDEX IF EXISTS registrations_event_user;
+CREATE UNIQUE INDEX IF NOT EXISTS registrations_event_occurrence_user ON registrations(event_id, occurrence, user_id);
+CREATE INDEX IF NOT EXISTS registrations_event_occurrence_status ON registrations(event_id, occurrence, status, id);
@@ -0,0 +1,5 @@
+-- Start of the last occurrence of a series whose rule has UNTIL or COUNT, so
+-- that listing occurrences skips series that ended before the window. NULL
+-- for single events and open-ended series; series saved before this column
+-- existed stay NULL, and are treated as open-ended, until they are next updated.
+ALTER TABLE events ADD COLUMN series_end TIMESTAMP;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS events_search;
+ALTER TABLE events DROP COLUMN search;
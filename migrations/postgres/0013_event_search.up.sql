@@ -0,0 +1,11 @@
+-- Full-text search document for models.SQLEventRepository.Search, weighted
+-- so name matches rank above description matches, and those above location
+-- matches. The 'simple' configuration neither stems nor drops stop words,
+-- like SQLite's events_fts index.
+ALTER TABLE events ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', name), 'A') ||
+	setweight(to_tsvector('simple', description), 'B') ||
+	setweight(to_tsvector('simple', location), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS events_search ON events USING GIN (search);
@@ -0,0 +1,3 @@
+-- TIMESTAMP columns hold no offset, and every PostgreSQL connection has sent
+-- times in UTC, so there is nothing to rewrite; this keeps the versions of
+-- both dialects in step
@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS roles (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id INTEGER NOT NULL,
+	permission_id INTEGER NOT NULL,
+	PRIMARY KEY(role_id, permission_id),
+	FOREIGN KEY(role_id) REFERENCES roles(id),
+	FOREIGN KEY(permission_id) REFERENCES permissions(id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id INTEGER NOT NULL,
+	role_id INTEGER NOT NULL,
+	PRIMARY KEY(user_id, role_id),
+	FOREIGN KEY(user_id) REFERENCES users(id),
+	FOREIGN KEY(role_id) REFERENCES roles(id)
+);
+
+INSERT OR IGNORE INTO roles(name) VALUES ('user'), ('admin');
+
+INSERT OR IGNORE INTO permissions(name) VALUES
+	('events:create'),
+	('events:update:own'),
+	('events:update:any'),
+	('events:delete:own'),
+	('events:delete:any'),
+	('events:register'),
+	('users:roles:manage');
+
+INSERT OR IGNORE INTO role_permissions(role_id, permission_id)
+	SELECT roles.id, permissions.id FROM roles, permissions
+	WHERE roles.name = 'user'
+	AND permissions.name IN ('events:create', 'events:update:own', 'events:delete:own', 'events:register');
+
+INSERT OR IGNORE INTO role_permissions(role_id, permission_id)
+	SELECT roles.id, permissions.id FROM roles, permissions
+	WHERE roles.name = 'admin';
+
+-- Accounts created before roles existed get the default role
+INSERT OR IGNORE INTO user_roles(user_id, role_id)
+	SELECT users.id, roles.id FROM users, roles
+	WHERE roles.name = 'user'
+	AND users.id NOT IN (SELECT user_id FROM user_roles);
@@ -0,0 +1,12 @@
+ALTER TABLE events ADD COLUMN capacity INTEGER;
+
+ALTER TABLE registrations ADD COLUMN status TEXT NOT NULL DEFAULT 'registered';
+ALTER TABLE registrations ADD COLUMN created_at DATETIME;
+UPDATE registrations SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+
+-- Registering twice used to be possible; keep the earliest registration of each user
+DELETE FROM registrations
+WHERE id NOT IN (SELECT MIN(id) FROM registrations GROUP BY event_id, user_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS registrations_event_user ON registrations(event_id, user_id);
+CREATE INDEX IF NOT EXISTS registrations_event_status ON registrations(event_id, status, id);
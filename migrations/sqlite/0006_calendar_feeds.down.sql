@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS calendar_tokens;
+
+ALTER TABLE events DROP COLUMN updated_at;
+ALTER TABLE events DROP COLUMN sequence;
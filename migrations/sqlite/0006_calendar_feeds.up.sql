@@ -0,0 +1,12 @@
+-- SEQUENCE and LAST-MODIFIED for iCalendar exports; bumped by every change to an event or its occurrences
+ALTER TABLE events ADD COLUMN sequence INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE events ADD COLUMN updated_at DATETIME;
+UPDATE events SET updated_at = CURRENT_TIMESTAMP WHERE updated_at IS NULL;
+
+-- One secret calendar feed token per user; only its SHA-256 hash is stored
+CREATE TABLE IF NOT EXISTS calendar_tokens (
+	user_id INTEGER PRIMARY KEY,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
@@ -0,0 +1,14 @@
+-- Incremented whenever all of a user's tokens must stop working, e.g. after a password reset
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS password_resets (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	used_at DATETIME,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_id ON password_resets(user_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS verification_emails;
+
+ALTER TABLE users DROP COLUMN email_verified_at;
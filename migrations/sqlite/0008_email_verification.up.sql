@@ -0,0 +1,21 @@
+-- NULL until the user follows the link in their verification email
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+
+-- Accounts created before email verification existed are treated as verified
+UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE email_verified_at IS NULL;
+
+-- Emails are now stored trimmed and lower-cased; addresses whose normalised
+-- form is already taken by another account are left as they are
+UPDATE users SET email = lower(trim(email))
+WHERE email <> lower(trim(email))
+AND NOT EXISTS (SELECT 1 FROM users AS other WHERE other.id <> users.id AND lower(trim(other.email)) = lower(trim(users.email)));
+
+-- When verification emails were sent, for rate limiting
+CREATE TABLE IF NOT EXISTS verification_emails (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	sent_at DATETIME NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS verification_emails_user_id ON verification_emails(user_id, sent_at);
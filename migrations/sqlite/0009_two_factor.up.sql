@@ -0,0 +1,16 @@
+-- TOTP secret (base32); set when enrolment starts, enabled once the first code is confirmed
+ALTER TABLE users ADD COLUMN totp_secret TEXT;
+ALTER TABLE users ADD COLUMN totp_enabled_at DATETIME;
+-- Time step of the last accepted code, so a code cannot be used twice
+ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+
+-- One-time recovery codes for when the authenticator is lost; only their SHA-256 hashes are stored
+CREATE TABLE IF NOT EXISTS recovery_codes (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at DATETIME,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id ON recovery_codes(user_id);
@@ -0,0 +1,21 @@
+-- Audit log of every login attempt; user_id is NULL when no account has the email
+CREATE TABLE IF NOT EXISTS login_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email TEXT NOT NULL,
+	user_id INTEGER,
+	ip TEXT NOT NULL,
+	success INTEGER NOT NULL,
+	reason TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_email ON login_attempts(email, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip ON login_attempts(ip, created_at);
+
+-- Consecutive failures per account ("email:...") and per client ("ip:...")
+CREATE TABLE IF NOT EXISTS login_throttles (
+	key TEXT PRIMARY KEY,
+	failures INTEGER NOT NULL,
+	locked_until DATETIME,
+	updated_at DATETIME NOT NULL
+);
@@ -0,0 +1,10 @@
+-- Token buckets for request rate limiting, keyed by policy and client ("accounts:ip:...", "authenticated:user:...")
+CREATE TABLE IF NOT EXISTS rate_limits (
+	key TEXT PRIMARY KEY,
+	tokens REAL NOT NULL,
+	updated_at DATETIME NOT NULL,
+	-- When the bucket will have refilled; rows past it are deleted
+	full_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS rate_limits_full_at ON rate_limits(full_at);
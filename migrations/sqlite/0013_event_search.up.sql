@@ -0,0 +1,2 @@
+-- SQLite's search index, events_fts, needs FTS5, which depends on how the
+-- driver was built, so db.InitDB creates it at startup instead
@@ -0,0 +1 @@
+-- The original offsets are not kept, and UTC times mean the same instants
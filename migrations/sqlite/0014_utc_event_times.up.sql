@@ -0,0 +1,10 @@
+-- Events saved before times were converted to UTC keep the offset they were
+-- sent with, such as "2030-03-04 20:00:00+02:00". Range filters and sorting
+-- compare dateTime as text, so rewrite those rows in the driver's UTC form,
+-- "2030-03-04 18:00:00+00:00". SQLite's date functions keep milliseconds, so
+-- any finer digits of those rows are dropped.
+UPDATE events
+SET dateTime = strftime('%Y-%m-%d %H:%M:%S', dateTime)
+	|| CASE WHEN strftime('%f', dateTime) LIKE '%.000' THEN '' ELSE rtrim(substr(strftime('%f', dateTime), 3), '0') END
+	|| '+00:00'
+WHERE dateTime NOT LIKE '%+00:00' AND strftime('%s', dateTime) IS NOT NULL;
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/utils"
+	"github.com/teambition/rrule-go"
+)
+
+// ErrCalendarTokenInvalid is returned for unknown or revoked calendar feed tokens
+var ErrCalendarTokenInvalid = errors.New("calendar token invalid")
+
+// IssueCalendarToken creates a secret token for the user's calendar feed and returns it
+//
+// Each user has at most one token, so issuing a new one revokes the previous
+// one. Only the SHA-256 hash of the token is stored.
+func (r *SQLTokenRepository) IssueCalendarToken(userId int64) (string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := `
+	INSERT INTO calendar_tokens(user_id, token_hash, created_at)
+	VALUES (?, ?, ?)
+	ON CONFLICT(user_id) DO UPDATE SET token_hash = excluded.token_hash, created_at = excluded.created_at`
+	stmt, err := r.DB.Prepare(query)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(userId, utils.HashToken(token), time.Now().UTC())
+
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RevokeCalendarToken revokes the user's calendar feed token, if they have one
+func (r *SQLTokenRepository) RevokeCalendarToken(userId int64) error {
+	_, err := r.DB.Exec("DELETE FROM calendar_tokens WHERE user_id = ?", userId)
+	return err
+}
+
+// UserIDForCalendarToken returns the ID of the user a calendar feed token belongs to
+func (r *SQLTokenRepository) UserIDForCalendarToken(token string) (int64, error) {
+	var userId int64
+	err := r.DB.QueryRow("SELECT user_id FROM calendar_tokens WHERE token_hash = ?", utils.HashToken(token)).Scan(&userId)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrCalendarTokenInvalid
+	}
+
+	return userId, err
+}
+
+// SeriesExceptions returns the occurrences of a recurring event that were
+// changed or cancelled without changing the rest of the series
+//
+// Changes to times that are no longer part of the series are left out.
+func (r *SQLEventRepository) SeriesExceptions(e Event) ([]Occurrence, []time.Time, error) {
+	if !e.IsRecurring() {
+		return nil, nil, nil
+	}
+
+	set, err := e.recurrence()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	overrides, err := loadOverrides(context.Background(), r.DB, []int64{e.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e.seriesExceptions(set, overrides[e.ID])
+}
+
+// seriesExceptions splits the overrides of a recurring event with the
+// recurrence set into changed occurrences and cancelled times
+func (e Event) seriesExceptions(set *rrule.Set, overrides map[string]occurrenceOverride) ([]Occurrence, []time.Time, error) {
+	var modified []Occurrence
+	var cancelled []time.Time
+
+	for key, override := range overrides {
+		start, err := parseOccurrenceKey(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if start == nil || !hasOccurrence(set, *start) {
+			continue
+		}
+
+		if override.cancelled {
+			cancelled = append(cancelled, *start)
+		} else {
+			modified = append(modified, e.occurrence(*start, &override))
+		}
+	}
+
+	return modified, cancelled, nil
+}
+
+// ListRegisteredOccurrences returns every event and occurrence the user holds a seat for
+//
+// Single events are returned as one occurrence. Occurrences of recurring
+// events have their per-occurrence changes applied; registrations for
+// occurrences that are no longer part of the series are left out.
+func (r *SQLRegistrationRepository) ListRegisteredOccurrences(userId int64) ([]Occurrence, error) {
+	query := `
+	SELECT ` + eventColumns + `, registrations.occurrence
+	FROM registrations
+	JOIN events ON events.id = registrations.event_id
+	WHERE registrations.user_id = ? AND registrations.status = ?
+	ORDER BY events.id, registrations.occurrence`
+
+	rows, err := r.DB.Query(query, userId, RegistrationRegistered)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	var keys []string
+
+	for rows.Next() {
+		var key string
+		event, err := scanEventWith(rows, &key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	occurrences := []Occurrence{}
+
+	for i, event := range events {
+		start, err := parseOccurrenceKey(keys[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if start == nil {
+			expanded, err := event.expand(event.DateTime, event.DateTime, nil)
+			if err != nil {
+				return nil, err
+			}
+			occurrences = append(occurrences, expanded...)
+			continue
+		}
+
+		occurrence, err := event.getOccurrence(r.DB, *start)
+		if errors.Is(err, ErrNoSuchOccurrence) || errors.Is(err, ErrInvalidRecurrence) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		occurrences = append(occurrences, *occurrence)
+	}
+
+	return occurrences, nil
+}
@@ -1,84 +1,205 @@
 package models
 
 import (
-	"github.com/PaulFWatts/rest_api_golang/db"
+	"context"
+	"database/sql"
+	"errors"
 	"time"
-)
 
+	"go.opentelemetry.io/otel/attribute"
+)
 
 type Event struct {
 	ID          int64
-	Name        string    `binding:"required"`
-	Description string    `binding:"required"`
-	Location    string    `binding:"required"`
-	DateTime    time.Time `binding:"required"`
+	Name        string
+	Description string
+	Location    string
+	DateTime    time.Time
 	UserID      int64
+	Capacity    *int64      // Maximum number of registered attendees; nil means unlimited
+	RRule       string      // Optional RFC 5545 recurrence rule such as "FREQ=WEEKLY;COUNT=10"; DateTime is the first occurrence
+	ExDates     []time.Time // Occurrences excluded from the recurrence rule
+	Sequence    int64       // Revision number, incremented by every change to the event or its occurrences
+	UpdatedAt   time.Time
 }
 
-var events = []Event{}
+// ErrEventNotFound is returned when no event has the given ID
+var ErrEventNotFound = errors.New("event not found")
+
+// eventColumns lists the events columns in the order scanEvent expects them
+const eventColumns = "events.id, events.name, events.description, events.location, events.dateTime, events.user_id, events.capacity, events.rrule, events.exdates, events.sequence, events.updated_at"
+
+// scanEvent reads a row selected with eventColumns
+func scanEvent(row scanner) (Event, error) {
+	return scanEventWith(row)
+}
+
+// scanEventWith reads a row selected with eventColumns followed by extra columns
+func scanEventWith(row scanner, extra ...any) (Event, error) {
+	var event Event
+	var exdates string
+	dest := []any{&event.ID, &event.Name, &event.Description, &event.Location, &event.DateTime, &event.UserID, &event.Capacity, &event.RRule, &exdates, &event.Sequence, &event.UpdatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return event, err
+	}
+
+	event.ExDates, err = parseExDates(exdates)
+	return event, err
+}
+
+// Save inserts a new event and sets its ID
+func (r *SQLEventRepository) Save(ctx context.Context, e *Event) (err error) {
+	ctx, span := startSpan(ctx, "EventRepository.Save", attribute.Int64("user.id", e.UserID))
+	defer func() { endSpan(span, err) }()
+
+	exdates, err := e.normalizeRecurrence()
+	if err != nil {
+		return err
+	}
+	seriesEnd, err := e.seriesEnd()
+	if err != nil {
+		return err
+	}
 
-func (e *Event) Save() error {
 	query := `
-	INSERT INTO events(name, description, location, dateTime, user_id) 
-	VALUES (?, ?, ?, ?, ?)`
-	stmt, err := db.DB.Prepare(query)
+	INSERT INTO events(name, description, location, dateTime, user_id, capacity, rrule, exdates, series_end, sequence, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?)
+	RETURNING id`
+	stmt, err := r.DB.PrepareContext(ctx, query)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	result, err := stmt.Exec(e.Name, e.Description, e.Location, e.DateTime, e.UserID)
+	e.Sequence = 0
+	e.UpdatedAt = time.Now().UTC()
+	// Times are stored in UTC so that date range filters and sorting compare correctly
+	var id int64
+	err = stmt.QueryRowContext(ctx, e.Name, e.Description, e.Location, e.DateTime.UTC(), e.UserID, e.Capacity, e.RRule, exdates, seriesEnd, e.UpdatedAt).Scan(&id)
 	if err != nil {
 		return err
 	}
-	id, err := result.LastInsertId()
 	e.ID = id
-	return err
+	span.SetAttributes(attribute.Int64("event.id", id))
+
+	observer.EventCreated()
+	return nil
 }
 
-func GetAllEvents() ([]Event, error) {
-	query := "SELECT * FROM events"
-	rows, err := db.DB.Query(query)
+// GetByID returns the event with the ID, or ErrEventNotFound
+func (r *SQLEventRepository) GetByID(ctx context.Context, id int64) (_ *Event, err error) {
+	ctx, span := startSpan(ctx, "EventRepository.GetByID", attribute.Int64("event.id", id))
+	defer func() { endSpan(span, err) }()
+
+	query := "SELECT " + eventColumns + " FROM events WHERE id = ?"
+	row := r.DB.QueryRowContext(ctx, query, id)
+
+	event, err := scanEvent(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEventNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var events []Event
+	return &event, nil
+}
 
-	for rows.Next() {
-		var event Event
-		err := rows.Scan(&event.ID, &event.Name, &event.Description, &event.Location, &event.DateTime, &event.UserID)
+// EventFilter narrows the events returned by EventRepository.List; zero values are ignored
+type EventFilter struct {
+	From     time.Time // Events starting at or after this time
+	To       time.Time // Events starting at or before this time
+	Location string    // Case-insensitive substring of the location
+	OwnerID  int64     // Events created by this user
+	Text     string    // Case-insensitive substring of the name or description
+}
 
-		if err != nil {
-			return nil, err
-		}
+// eventSorts are the sort keys accepted by EventRepository.List
+var eventSorts = map[string]SortField[Event]{
+	"id":       {Column: "events.id", Type: ColumnInt, Value: func(e Event) any { return e.ID }},
+	"name":     {Column: "events.name", Type: ColumnText, Value: func(e Event) any { return e.Name }},
+	"dateTime": {Column: "events.dateTime", Type: ColumnTime, Value: func(e Event) any { return e.DateTime }},
+}
+
+// List returns one page of events matching the filter
+//
+// Sort keys are "id" (the default), "name" and "dateTime", each optionally
+// prefixed with "-" for descending order.
+func (r *SQLEventRepository) List(ctx context.Context, filter EventFilter, request PageRequest) (_ *Page[Event], err error) {
+	ctx, span := startSpan(ctx, "EventRepository.List")
+	defer func() { endSpan(span, err) }()
+
+	query := ListQuery[Event]{
+		From:        "events",
+		Columns:     eventColumns,
+		IDColumn:    "events.id",
+		ID:          func(e Event) int64 { return e.ID },
+		Sorts:       eventSorts,
+		DefaultSort: "id",
+		Scan:        scanEvent,
+	}
 
-		events = append(events, event)
+	if !filter.From.IsZero() {
+		query.Filter("events.dateTime >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		query.Filter("events.dateTime <= ?", filter.To.UTC())
 	}
+	filter.apply(&query)
 
-	return events, nil
+	return query.Run(ctx, r.DB, request)
 }
 
-func GetEventByID(id int64) (*Event, error) {
-	query := "SELECT * FROM events WHERE id = ?"
-	row := db.DB.QueryRow(query, id)
+// apply adds the filter's location, owner and text conditions to an events query
+//
+// Text matches ignore case. SQLite's LIKE already does, but PostgreSQL's does
+// not, so both sides are lower-cased.
+func (filter EventFilter) apply(query *ListQuery[Event]) {
+	if filter.Location != "" {
+		query.Filter(`LOWER(events.location) LIKE LOWER(?) ESCAPE '\'`, likePattern(filter.Location))
+	}
+	if filter.OwnerID != 0 {
+		query.Filter("events.user_id = ?", filter.OwnerID)
+	}
+	if filter.Text != "" {
+		pattern := likePattern(filter.Text)
+		query.Filter(`(LOWER(events.name) LIKE LOWER(?) ESCAPE '\' OR LOWER(events.description) LIKE LOWER(?) ESCAPE '\')`, pattern, pattern)
+	}
+}
 
-	var event Event
-	err := row.Scan(&event.ID, &event.Name, &event.Description, &event.Location, &event.DateTime, &event.UserID)
+// Update saves the event's fields. If the capacity grew, waitlisted users are
+// promoted into the new seats in the same transaction. Lowering the capacity
+// never removes users who are already registered.
+//
+// Changing the start or recurrence rule of a series keeps existing
+// per-occurrence changes and registrations; those for occurrences that are
+// no longer part of the series are simply not shown.
+func (r *SQLEventRepository) Update(ctx context.Context, event Event) (err error) {
+	ctx, span := startSpan(ctx, "EventRepository.Update", attribute.Int64("event.id", event.ID))
+	defer func() { endSpan(span, err) }()
+
+	exdates, err := event.normalizeRecurrence()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	seriesEnd, err := event.seriesEnd()
+	if err != nil {
+		return err
 	}
 
-	return &event, nil
-}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-func (event Event) Update() error {
 	query := `
 	UPDATE events
-	SET name = ?, description = ?, location = ?, dateTime = ?
+	SET name = ?, description = ?, location = ?, dateTime = ?, capacity = ?, rrule = ?, exdates = ?, series_end = ?,
+		sequence = sequence + 1, updated_at = ?
 	WHERE id = ?
 	`
-	stmt, err := db.DB.Prepare(query)
+	stmt, err := tx.PrepareContext(ctx, query)
 
 	if err != nil {
 		return err
@@ -86,42 +207,51 @@ func (event Event) Update() error {
 
 	defer stmt.Close()
 
-	_, err = stmt.Exec(event.Name, event.Description, event.Location, event.DateTime, event.ID)
-	return err
-}
-
-func (event Event) Delete() error {
-	query := "DELETE FROM events WHERE id = ?"
-	stmt, err := db.DB.Prepare(query)
+	_, err = stmt.ExecContext(ctx, event.Name, event.Description, event.Location, event.DateTime.UTC(), event.Capacity, event.RRule, exdates, seriesEnd, time.Now().UTC(), event.ID)
 
 	if err != nil {
 		return err
 	}
 
-	defer stmt.Close()
+	err = promoteWaitlisted(tx, event.ID)
+
+	if err != nil {
+		return err
+	}
 
-	_, err = stmt.Exec(event.ID)
-	return err
+	return tx.Commit()
 }
 
-func (e Event) Register(userId int64) error {
-	query := "INSERT INTO registrations(event_id, user_id) VALUES (?, ?)"
-	stmt, err := db.DB.Prepare(query)
+// Delete removes the event, its per-occurrence changes and its registrations
+func (r *SQLEventRepository) Delete(ctx context.Context, event Event) (err error) {
+	ctx, span := startSpan(ctx, "EventRepository.Delete", attribute.Int64("event.id", event.ID))
+	defer func() { endSpan(span, err) }()
 
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	defer stmt.Close()
+	// Held so that no registration is added while the event is deleted
+	_, err = lockEvent(tx, event.ID)
+	if err != nil {
+		return err
+	}
 
-	_, err = stmt.Exec(e.ID, userId)
+	// PostgreSQL enforces the foreign keys to the event, so these go first
+	_, err = tx.ExecContext(ctx, "DELETE FROM registrations WHERE event_id = ?", event.ID)
+	if err != nil {
+		return err
+	}
 
-	return err
-}
+	_, err = tx.ExecContext(ctx, "DELETE FROM event_occurrences WHERE event_id = ?", event.ID)
+	if err != nil {
+		return err
+	}
 
-func (e Event) CancelRegistration(userId int64) error {
-	query := "DELETE FROM registrations WHERE event_id = ? AND user_id = ?"
-	stmt, err := db.DB.Prepare(query)
+	query := "DELETE FROM events WHERE id = ?"
+	stmt, err := tx.PrepareContext(ctx, query)
 
 	if err != nil {
 		return err
@@ -129,7 +259,10 @@ func (e Event) CancelRegistration(userId int64) error {
 
 	defer stmt.Close()
 
-	_, err = stmt.Exec(e.ID, userId)
+	_, err = stmt.ExecContext(ctx, event.ID)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return tx.Commit()
 }
@@ -0,0 +1,376 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page size limits applied to every list endpoint
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// ErrInvalidCursor is returned when a cursor cannot be decoded or belongs to a different sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrInvalidSort is returned when the requested sort key is not supported by the list
+var ErrInvalidSort = errors.New("invalid sort")
+
+// PageRequest describes which page of a list to return
+//
+// Sort is a sort key such as "dateTime"; a leading "-" sorts in descending
+// order. Cursor is an opaque value taken from a previous Page's Next or Prev.
+type PageRequest struct {
+	Limit  int
+	Cursor string
+	Sort   string
+}
+
+// Page is one page of a list along with cursors for the neighbouring pages
+//
+// Next and Prev are empty when there is no page in that direction. Total is the
+// number of items matching the filters, independent of the cursor.
+type Page[T any] struct {
+	Items []T
+	Total int
+	Limit int
+	Next  string
+	Prev  string
+}
+
+// Column types understood by cursors
+const (
+	ColumnInt = iota
+	ColumnText
+	ColumnTime
+)
+
+// SortField maps a public sort key to a column and knows how to read that
+// column's value from a scanned item, which becomes the position in a cursor
+type SortField[T any] struct {
+	Column string
+	Type   int
+	Value  func(T) any
+}
+
+// ListQuery describes a filtered, sortable and keyset-paginated SELECT
+//
+// From and Columns are inserted verbatim into the query, so they must never
+// contain user input; filters go through Where and Args as placeholders.
+// Keyset pagination orders by the sort column and then IDColumn, so pages stay
+// stable when rows are inserted or deleted between requests.
+type ListQuery[T any] struct {
+	From        string
+	Columns     string
+	IDColumn    string
+	ID          func(T) int64
+	Where       []string
+	Args        []any
+	Sorts       map[string]SortField[T]
+	DefaultSort string
+	Scan        func(scanner) (T, error)
+}
+
+// scanner is satisfied by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// cursor is the decoded form of an opaque page cursor
+type cursor struct {
+	Sort     string `json:"s"`
+	Value    string `json:"v"`
+	ID       int64  `json:"i"`
+	Tie      string `json:"t,omitempty"`
+	Backward bool   `json:"b,omitempty"`
+}
+
+// pageKey identifies an item within its sort value: by ID and then by Tie,
+// which tells apart items that share an ID, such as the occurrences of a series
+type pageKey struct {
+	ID  int64
+	Tie string
+}
+
+// compare orders keys by ID and then by Tie
+func (k pageKey) compare(other pageKey) int {
+	c := cmp.Compare(k.ID, other.ID)
+	if c == 0 {
+		c = strings.Compare(k.Tie, other.Tie)
+	}
+	return c
+}
+
+// Filter appends a WHERE condition with its placeholder arguments
+func (q *ListQuery[T]) Filter(condition string, args ...any) {
+	q.Where = append(q.Where, condition)
+	q.Args = append(q.Args, args...)
+}
+
+// Run executes the query on the database and returns the requested page
+func (q *ListQuery[T]) Run(ctx context.Context, database *sql.DB, request PageRequest) (*Page[T], error) {
+	limit := pageLimit(request.Limit)
+
+	sortKey := request.Sort
+	if sortKey == "" {
+		sortKey = q.DefaultSort
+	}
+	descending := strings.HasPrefix(sortKey, "-")
+	field, ok := q.Sorts[strings.TrimPrefix(sortKey, "-")]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	where := append([]string{}, q.Where...)
+	args := append([]any{}, q.Args...)
+
+	var after *cursor
+	if request.Cursor != "" {
+		decoded, value, err := decodeCursor(request.Cursor, sortKey, field.Type)
+		if err != nil {
+			return nil, err
+		}
+		after = decoded
+
+		// Walking backwards flips the comparison; the rows are reversed again below
+		operator := ">"
+		if descending != after.Backward {
+			operator = "<"
+		}
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))",
+			field.Column, operator, field.Column, q.IDColumn, operator))
+		args = append(args, value, value, after.ID)
+	}
+
+	whereClause := ""
+	if len(where) > 0 {
+		whereClause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	direction := "ASC"
+	if descending != (after != nil && after.Backward) {
+		direction = "DESC"
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s %s, %s %s LIMIT ?",
+		q.Columns, q.From, whereClause, field.Column, direction, q.IDColumn, direction)
+
+	rows, err := database.QueryContext(ctx, query, append(args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []T{}
+
+	for rows.Next() {
+		item, err := q.Scan(rows)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page := newPage(items, limit, after, sortKey, field, func(item T) pageKey { return pageKey{ID: q.ID(item)} })
+
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s", q.From)
+	if len(q.Where) > 0 {
+		countQuery += " WHERE " + strings.Join(q.Where, " AND ")
+	}
+
+	err = database.QueryRowContext(ctx, countQuery, q.Args...).Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// pageItems pages a list held in memory the same way ListQuery.Run pages a query
+//
+// It is used for lists that cannot be produced by a single SELECT. Items are
+// ordered by the sort field and then by key, which must be unique per item.
+func pageItems[T any](items []T, request PageRequest, sorts map[string]SortField[T], defaultSort string, key func(T) pageKey) (*Page[T], error) {
+	limit := pageLimit(request.Limit)
+
+	sortKey := request.Sort
+	if sortKey == "" {
+		sortKey = defaultSort
+	}
+	descending := strings.HasPrefix(sortKey, "-")
+	field, ok := sorts[strings.TrimPrefix(sortKey, "-")]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	var after *cursor
+	var position any
+	if request.Cursor != "" {
+		decoded, value, err := decodeCursor(request.Cursor, sortKey, field.Type)
+		if err != nil {
+			return nil, err
+		}
+		after, position = decoded, value
+	}
+
+	// Order the items in the direction of travel, as ORDER BY does in Run
+	reverse := descending != (after != nil && after.Backward)
+	compare := func(a T, value any, aKey, valueKey pageKey) int {
+		c := compareValues(field.Value(a), value)
+		if c == 0 {
+			c = aKey.compare(valueKey)
+		}
+		if reverse {
+			c = -c
+		}
+		return c
+	}
+
+	sorted := append([]T{}, items...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compare(sorted[i], field.Value(sorted[j]), key(sorted[i]), key(sorted[j])) < 0
+	})
+
+	fetched := []T{}
+	for _, item := range sorted {
+		if after != nil && compare(item, position, key(item), pageKey{ID: after.ID, Tie: after.Tie}) <= 0 {
+			continue
+		}
+		fetched = append(fetched, item)
+		if len(fetched) > limit {
+			break
+		}
+	}
+
+	page := newPage(fetched, limit, after, sortKey, field, key)
+	page.Total = len(items)
+	return page, nil
+}
+
+// pageLimit applies the default and maximum page size to a requested limit
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return limit
+}
+
+// newPage builds a page from up to limit+1 items fetched in the direction of
+// travel from the cursor after, and sets the cursors of the neighbouring pages
+func newPage[T any](items []T, limit int, after *cursor, sortKey string, field SortField[T], key func(T) pageKey) *Page[T] {
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+
+	page := &Page[T]{Items: items, Limit: limit}
+
+	if after != nil && after.Backward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+
+	if len(items) > 0 {
+		first, last := items[0], items[len(items)-1]
+		backward := after != nil && after.Backward
+
+		// A forward page has a next page if more rows were found and a previous
+		// page if it started from a cursor; a backward page is the mirror image
+		hasNext, hasPrev := hasMore, after != nil
+		if backward {
+			hasNext, hasPrev = true, hasMore
+		}
+
+		if hasNext {
+			page.Next = encodeCursor(sortKey, key(last), field.Value(last), false)
+		}
+		if hasPrev {
+			page.Prev = encodeCursor(sortKey, key(first), field.Value(first), true)
+		}
+	}
+
+	return page
+}
+
+// compareValues compares two sort values of the same type, as returned by SortField.Value
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+func encodeCursor(sortKey string, key pageKey, value any, backward bool) string {
+	c := cursor{Sort: sortKey, ID: key.ID, Tie: key.Tie, Backward: backward}
+
+	switch v := value.(type) {
+	case time.Time:
+		c.Value = v.UTC().Format(time.RFC3339Nano)
+	case int64:
+		c.Value = strconv.FormatInt(v, 10)
+	default:
+		c.Value = fmt.Sprint(v)
+	}
+
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string, sortKey string, columnType int) (*cursor, any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.Sort != sortKey {
+		return nil, nil, ErrInvalidCursor
+	}
+
+	var value any
+	switch columnType {
+	case ColumnTime:
+		value, err = time.Parse(time.RFC3339Nano, c.Value)
+	case ColumnInt:
+		value, err = strconv.ParseInt(c.Value, 10, 64)
+	default:
+		value = c.Value
+	}
+
+	if err != nil {
+		return nil, nil, ErrInvalidCursor
+	}
+
+	return &c, value, nil
+}
+
+// likePattern escapes LIKE wildcards in s and wraps it for a substring match with ESCAPE '\'
+func likePattern(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(s) + "%"
+}
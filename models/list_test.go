@@ -0,0 +1,182 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// listStart is the start of the earliest event in these tests
+var listStart = time.Date(2030, time.June, 1, 9, 0, 0, 0, time.UTC)
+
+// createEventsAt saves an event owned by the user at each offset from
+// listStart, named after its position, and returns them in that order
+func createEventsAt(t *testing.T, repositories Repositories, ownerId int64, offsets ...time.Duration) []Event {
+	t.Helper()
+
+	events := make([]Event, len(offsets))
+	for i, offset := range offsets {
+		events[i] = Event{
+			Name:        string(rune('A' + i)),
+			Description: "A test event",
+			Location:    "Hall",
+			DateTime:    listStart.Add(offset),
+			UserID:      ownerId,
+		}
+		err := repositories.Events.Save(context.Background(), &events[i])
+		if err != nil {
+			t.Fatalf("Save event: %v", err)
+		}
+	}
+	return events
+}
+
+// eventIDs returns the IDs of the events
+func eventIDs(events []Event) []int64 {
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+// pageAll lists every event matching the filter a page at a time, forwards
+// and then backwards from the last page, and returns the IDs in each direction
+func pageAll(t *testing.T, repositories Repositories, filter EventFilter, request PageRequest) (forward []int64, backward []int64) {
+	t.Helper()
+
+	var page *Page[Event]
+	for {
+		var err error
+		page, err = repositories.Events.List(context.Background(), filter, request)
+		if err != nil {
+			t.Fatalf("List %+v: %v", request, err)
+		}
+		forward = append(forward, eventIDs(page.Items)...)
+		if page.Next == "" {
+			break
+		}
+		if len(forward) > page.Total {
+			t.Fatalf("paging forward did not end: %v", forward)
+		}
+		request.Cursor = page.Next
+	}
+
+	backward = eventIDs(page.Items)
+	for page.Prev != "" {
+		var err error
+		request.Cursor = page.Prev
+		page, err = repositories.Events.List(context.Background(), filter, request)
+		if err != nil {
+			t.Fatalf("List %+v: %v", request, err)
+		}
+		backward = append(eventIDs(page.Items), backward...)
+		if len(backward) > page.Total {
+			t.Fatalf("paging backward did not end: %v", backward)
+		}
+	}
+	return forward, backward
+}
+
+func TestEventPages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		owner := createUser(t, repositories, "owner@example.com")
+		// C and D start at the same time, so the ID decides their order
+		events := createEventsAt(t, repositories, owner, 3*time.Hour, time.Hour, 5*time.Hour, 5*time.Hour, 0, 2*time.Hour)
+		a, b, c, d, e, f := events[0].ID, events[1].ID, events[2].ID, events[3].ID, events[4].ID, events[5].ID
+
+		tests := []struct {
+			sort string
+			want []int64
+		}{
+			{"", []int64{a, b, c, d, e, f}},
+			{"-id", []int64{f, e, d, c, b, a}},
+			{"name", []int64{a, b, c, d, e, f}},
+			{"dateTime", []int64{e, b, f, a, c, d}},
+			{"-dateTime", []int64{d, c, a, f, b, e}},
+		}
+
+		for _, test := range tests {
+			for _, limit := range []int{1, 2, 4, 6} {
+				forward, backward := pageAll(t, repositories, EventFilter{}, PageRequest{Limit: limit, Sort: test.sort})
+				if !slices.Equal(forward, test.want) {
+					t.Errorf("sort %q, limit %d: pages forward = %v, want %v", test.sort, limit, forward, test.want)
+				}
+				if !slices.Equal(backward, test.want) {
+					t.Errorf("sort %q, limit %d: pages backward = %v, want %v", test.sort, limit, backward, test.want)
+				}
+			}
+		}
+	})
+}
+
+func TestEventPageTotalsAndCursors(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		owner := createUser(t, repositories, "owner@example.com")
+		createEventsAt(t, repositories, owner, 0, time.Hour, 2*time.Hour)
+
+		first, err := repositories.Events.List(context.Background(), EventFilter{}, PageRequest{Limit: 2, Sort: "dateTime"})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if first.Total != 3 || first.Limit != 2 || len(first.Items) != 2 {
+			t.Errorf("first page has %d items of %d with limit %d, want 2 of 3 with limit 2", len(first.Items), first.Total, first.Limit)
+		}
+		if first.Next == "" || first.Prev != "" {
+			t.Errorf("first page next = %q, prev = %q, want only a next cursor", first.Next, first.Prev)
+		}
+
+		last, err := repositories.Events.List(context.Background(), EventFilter{}, PageRequest{Limit: 2, Sort: "dateTime", Cursor: first.Next})
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if last.Total != 3 || len(last.Items) != 1 {
+			t.Errorf("last page has %d items of %d, want 1 of 3", len(last.Items), last.Total)
+		}
+		if last.Next != "" || last.Prev == "" {
+			t.Errorf("last page next = %q, prev = %q, want only a prev cursor", last.Next, last.Prev)
+		}
+
+		// A cursor only applies to the sort it was made for
+		errorTests := []struct {
+			request PageRequest
+			want    error
+		}{
+			{PageRequest{Sort: "name", Cursor: first.Next}, ErrInvalidCursor},
+			{PageRequest{Sort: "-dateTime", Cursor: first.Next}, ErrInvalidCursor},
+			{PageRequest{Sort: "dateTime", Cursor: "not a cursor"}, ErrInvalidCursor},
+			{PageRequest{Sort: "dateTime", Cursor: first.Next[:len(first.Next)-4]}, ErrInvalidCursor},
+			{PageRequest{Sort: "location"}, ErrInvalidSort},
+		}
+		for _, test := range errorTests {
+			_, err := repositories.Events.List(context.Background(), EventFilter{}, test.request)
+			if !errors.Is(err, test.want) {
+				t.Errorf("List %+v: err = %v, want %v", test.request, err, test.want)
+			}
+		}
+	})
+}
+
+func TestEventTimeFiltersCompareInstants(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		owner := createUser(t, repositories, "owner@example.com")
+
+		// Events sent with an offset are the same instants in UTC
+		berlin := time.FixedZone("CEST", 2*60*60)
+		events := createEventsAt(t, repositories, owner, 0, time.Hour, 2*time.Hour, 3*time.Hour)
+		events[1].DateTime = events[1].DateTime.In(berlin)
+		err := repositories.Events.Update(context.Background(), events[1])
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		filter := EventFilter{From: listStart.Add(time.Hour).In(berlin), To: listStart.Add(2 * time.Hour).In(time.FixedZone("EDT", -4*60*60))}
+		forward, _ := pageAll(t, repositories, filter, PageRequest{Limit: 1, Sort: "dateTime"})
+		want := []int64{events[1].ID, events[2].ID}
+		if !slices.Equal(forward, want) {
+			t.Errorf("events from %s to %s = %v, want %v", filter.From, filter.To, forward, want)
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// loginThrottle is the backoff policy for one kind of throttle key
+//
+// The first FreeFailures consecutive failures are not throttled. Every failure
+// after that locks the key for BaseDelay, doubled for each further failure, up
+// to MaxDelay. Failures older than FailureWindow are forgotten.
+type loginThrottle struct {
+	Prefix       string
+	FreeFailures int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+}
+
+var (
+	// accountThrottle limits guessing the password of one account, from any number of clients
+	accountThrottle = loginThrottle{Prefix: "email:", FreeFailures: 5, BaseDelay: time.Second, MaxDelay: 15 * time.Minute}
+	// clientThrottle limits one client guessing passwords across many accounts
+	clientThrottle = loginThrottle{Prefix: "ip:", FreeFailures: 20, BaseDelay: time.Second, MaxDelay: time.Hour}
+)
+
+// FailureWindow is how long a failed login counts towards throttling
+const FailureWindow = 24 * time.Hour
+
+// Reasons recorded for login attempts
+const (
+	LoginSucceeded          = "success"
+	LoginInvalidCredentials = "invalid_credentials"
+	LoginInvalidMFACode     = "invalid_mfa_code"
+	LoginMFARequired        = "mfa_required"
+	LoginThrottled          = "throttled"
+)
+
+// LoginAttempt is one entry of the login audit log
+type LoginAttempt struct {
+	Email   string
+	UserID  *int64 // nil when no account has the email
+	IP      string
+	Success bool
+	Reason  string
+}
+
+// LoginReservation is a login attempt counted as a failure against its
+// throttles before the password is checked, see ReserveAttempt
+type LoginReservation struct {
+	// Wait is how long logins are locked; when it is positive nothing was counted
+	Wait time.Duration
+
+	account reservedThrottle
+	client  reservedThrottle
+}
+
+// reservedThrottle is one throttle key a reservation counted against
+type reservedThrottle struct {
+	key string
+	// lockedUntil is the lock the reservation set, if it set one
+	lockedUntil *time.Time
+}
+
+// ReserveAttempt counts a login attempt for the email from the IP address as a
+// failure before the credentials are checked, unless logins are locked
+//
+// Counting first and checking second makes the lockout hold under concurrency:
+// guesses sent in parallel each take a failure and the one over the limit
+// sets the lock, so no more than the free failures get past it. When either
+// key is locked nothing is counted and the reservation's Wait says for how
+// long. Pass the reservation to RecordAttempt, which gives back what a
+// correct password should not have cost.
+//
+// The email does not have to belong to an account: unknown addresses are
+// throttled exactly like known ones, so a lockout reveals nothing about which
+// accounts exist.
+func (r *SQLLoginRepository) ReserveAttempt(email string, ip string) (*LoginReservation, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Locks are compared for equality when released, so keep only what both databases store
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	reservation := &LoginReservation{}
+
+	for _, throttle := range []struct {
+		policy   loginThrottle
+		value    string
+		reserved *reservedThrottle
+	}{{accountThrottle, email, &reservation.account}, {clientThrottle, ip, &reservation.client}} {
+		reserved, wait, err := throttle.policy.reserve(tx, throttle.value, now)
+		if err != nil {
+			return nil, err
+		}
+		*throttle.reserved = reserved
+		reservation.Wait = max(reservation.Wait, wait)
+	}
+
+	// Rolling back leaves the key that was not locked as it was
+	if reservation.Wait > 0 {
+		return &LoginReservation{Wait: reservation.Wait}, nil
+	}
+
+	return reservation, tx.Commit()
+}
+
+// reserve counts a failure against the key, or returns how long it is locked
+//
+// The upsert is a single statement, which holds the row for the rest of the
+// transaction on PostgreSQL; SQLite transactions hold the whole database.
+func (t loginThrottle) reserve(tx *sql.Tx, value string, now time.Time) (reservedThrottle, time.Duration, error) {
+	key := t.key(value)
+
+	query := `
+	INSERT INTO login_throttles(key, failures, locked_until, updated_at)
+	VALUES (?, 1, NULL, ?)
+	ON CONFLICT(key) DO UPDATE SET
+		failures = CASE WHEN login_throttles.updated_at < ? THEN 1 ELSE login_throttles.failures + 1 END,
+		updated_at = excluded.updated_at
+	WHERE login_throttles.locked_until IS NULL OR login_throttles.locked_until <= ?
+	RETURNING failures`
+	var failures int
+	err := tx.QueryRow(query, key, now, now.Add(-FailureWindow), now).Scan(&failures)
+
+	// No row comes back when the update was skipped, so the key is locked
+	if errors.Is(err, sql.ErrNoRows) {
+		var lockedUntil time.Time
+		err = tx.QueryRow("SELECT locked_until FROM login_throttles WHERE key = ?", key).Scan(&lockedUntil)
+		if err != nil {
+			return reservedThrottle{}, 0, err
+		}
+		return reservedThrottle{}, lockedUntil.Sub(now), nil
+	}
+	if err != nil {
+		return reservedThrottle{}, 0, err
+	}
+
+	lockedUntil := t.lock(failures, now)
+	_, err = tx.Exec("UPDATE login_throttles SET locked_until = ? WHERE key = ?", lockedUntil, key)
+	if err != nil {
+		return reservedThrottle{}, 0, err
+	}
+
+	return reservedThrottle{key: key, lockedUntil: lockedUntil}, 0, nil
+}
+
+// RecordAttempt adds an attempt to the audit log and settles its reservation
+//
+// A wrong password or two-factor code keeps the failure the reservation
+// counted against both the account and the client IP address. A success
+// resets the account's counter and gives the client back its failure; the
+// rest of the client's counter is left alone so that logging into one account
+// does not reset guessing against others. A correct password still waiting
+// for a two-factor code gives both failures back. Throttled attempts have no
+// reservation and are only logged.
+//
+// Once the attempt is committed, the observer hears about it, except for
+// attempts waiting for a two-factor code, which finish with a later attempt.
+func (r *SQLLoginRepository) RecordAttempt(reservation *LoginReservation, attempt LoginAttempt) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	email := normalizeThrottleEmail(attempt.Email)
+
+	query := `
+	INSERT INTO login_attempts(email, user_id, ip, success, reason, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(query, email, attempt.UserID, attempt.IP, attempt.Success, attempt.Reason, now)
+	if err != nil {
+		return err
+	}
+
+	if reservation != nil {
+		var release []reservedThrottle
+		switch {
+		case attempt.Success:
+			_, err = tx.Exec("DELETE FROM login_throttles WHERE key = ?", accountThrottle.key(email))
+			if err != nil {
+				return err
+			}
+			release = []reservedThrottle{reservation.client}
+		case attempt.Reason == LoginMFARequired:
+			release = []reservedThrottle{reservation.account, reservation.client}
+		}
+
+		for _, reserved := range release {
+			err = reserved.release(tx)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Only attempts that made it into the audit log are counted
+	if attempt.Reason != LoginMFARequired {
+		observer.LoginAttempted(attempt.Success, attempt.Reason)
+	}
+
+	return nil
+}
+
+// release gives back the failure the reservation counted, and the lock it
+// set unless a later failure has replaced it
+func (r reservedThrottle) release(tx *sql.Tx) error {
+	if r.key == "" {
+		return nil
+	}
+
+	query := `
+	UPDATE login_throttles
+	SET failures = failures - 1, locked_until = CASE WHEN locked_until = ? THEN NULL ELSE locked_until END
+	WHERE key = ? AND failures > 0`
+	_, err := tx.Exec(query, r.lockedUntil, r.key)
+
+	return err
+}
+
+// lock returns until when a key with the failure count is locked, or nil
+// while the free failures are not used up
+func (t loginThrottle) lock(failures int, now time.Time) *time.Time {
+	if failures <= t.FreeFailures {
+		return nil
+	}
+	lockedUntil := now.Add(t.delay(failures - t.FreeFailures))
+	return &lockedUntil
+}
+
+// delay returns the lockout after the nth throttled failure: BaseDelay doubled n-1 times, capped at MaxDelay
+func (t loginThrottle) delay(n int) time.Duration {
+	delay := t.BaseDelay
+	for i := 1; i < n && delay < t.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, t.MaxDelay)
+}
+
+// key returns the login_throttles key for an email address or IP address
+func (t loginThrottle) key(value string) string {
+	if t.Prefix == accountThrottle.Prefix {
+		value = normalizeThrottleEmail(value)
+	}
+	return t.Prefix + value
+}
+
+// normalizeThrottleEmail normalises an email like NormalizeEmail, falling back to
+// trimming and lower-casing for input that is not a valid address
+func normalizeThrottleEmail(email string) string {
+	normalized, err := NormalizeEmail(email)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(email))
+	}
+	return normalized
+}
@@ -0,0 +1,802 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/utils"
+)
+
+// memoryRolePermissions mirrors the roles and permissions seeded by the migrations
+var memoryRolePermissions = map[string][]string{
+	RoleUser: {PermissionEventsCreate, PermissionEventsDeleteOwn, PermissionEventsRegister, PermissionEventsUpdateOwn},
+	RoleAdmin: {PermissionEventsCreate, PermissionEventsDeleteAny, PermissionEventsDeleteOwn, PermissionEventsRegister,
+		PermissionEventsUpdateAny, PermissionEventsUpdateOwn, PermissionUsersRoles},
+}
+
+// memoryStore is the data shared by the memory repositories, guarded by one
+// mutex so that every operation is atomic like a database transaction
+type memoryStore struct {
+	mu            sync.Mutex
+	lastIDs       map[string]int64
+	events        map[int64]Event
+	overrides     map[int64]map[string]occurrenceOverride
+	users         map[int64]*memoryUser
+	registrations []memoryRegistration
+
+	refreshTokens      map[string]*memoryRefreshToken  // by token hash
+	calendarTokens     map[int64]string                // token hash by user ID
+	passwordResets     map[string]*memoryPasswordReset // by token hash
+	verificationEmails map[int64][]time.Time           // send times by user ID
+	loginAttempts      []LoginAttempt                  // the audit log
+	loginThrottles     map[string]*memoryLoginThrottle // by throttle key
+}
+
+// memoryUser is a stored account with its password hash and second factors
+type memoryUser struct {
+	User
+	hash  string
+	roles []string
+
+	totpSecret    string // empty until an enrolment is started
+	totpEnabledAt *time.Time
+	totpLastStep  int64
+	recoveryCodes map[string]bool // hashes of the unused recovery codes
+}
+
+// memoryRegistration is a row of the registrations table
+type memoryRegistration struct {
+	id        int64
+	eventId   int64
+	key       string
+	userId    int64
+	status    string
+	createdAt time.Time
+}
+
+// nextID returns the next ID of the table, counting from 1 like the databases do
+func (s *memoryStore) nextID(table string) int64 {
+	s.lastIDs[table]++
+	return s.lastIDs[table]
+}
+
+// NewMemoryRepositories returns empty repositories that keep everything in
+// memory, so handlers can be tested with httptest and without any disk I/O
+//
+// They follow the same rules as the SQL repositories, except that Search
+// always returns ErrSearchUnavailable.
+func NewMemoryRepositories() Repositories {
+	store := &memoryStore{
+		lastIDs:   map[string]int64{},
+		events:    map[int64]Event{},
+		overrides: map[int64]map[string]occurrenceOverride{},
+		users:     map[int64]*memoryUser{},
+
+		refreshTokens:      map[string]*memoryRefreshToken{},
+		calendarTokens:     map[int64]string{},
+		passwordResets:     map[string]*memoryPasswordReset{},
+		verificationEmails: map[int64][]time.Time{},
+		loginThrottles:     map[string]*memoryLoginThrottle{},
+	}
+
+	return Repositories{
+		Events:         &MemoryEventRepository{store: store},
+		Users:          &MemoryUserRepository{store: store},
+		Registrations:  &MemoryRegistrationRepository{store: store},
+		Tokens:         &MemoryTokenRepository{store: store},
+		MFA:            &MemoryMFARepository{store: store},
+		PasswordResets: &MemoryPasswordResetRepository{store: store},
+		Logins:         &MemoryLoginRepository{store: store},
+	}
+}
+
+// MemoryEventRepository is the EventRepository returned by NewMemoryRepositories
+type MemoryEventRepository struct {
+	store *memoryStore
+}
+
+// Save inserts a new event and sets its ID
+func (r *MemoryEventRepository) Save(_ context.Context, e *Event) error {
+	_, err := e.normalizeRecurrence()
+	if err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	e.ID = r.store.nextID("events")
+	e.DateTime = e.DateTime.UTC()
+	e.Sequence = 0
+	e.UpdatedAt = time.Now().UTC()
+	r.store.events[e.ID] = *e
+
+	observer.EventCreated()
+	return nil
+}
+
+// GetByID returns the event with the ID, or ErrEventNotFound
+func (r *MemoryEventRepository) GetByID(_ context.Context, id int64) (*Event, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	event, found := r.store.events[id]
+	if !found {
+		return nil, ErrEventNotFound
+	}
+	return &event, nil
+}
+
+// Update saves the event's fields and promotes waitlisted users into new seats
+func (r *MemoryEventRepository) Update(_ context.Context, event Event) error {
+	_, err := event.normalizeRecurrence()
+	if err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored, found := r.store.events[event.ID]
+	if !found {
+		return nil
+	}
+
+	stored.Name = event.Name
+	stored.Description = event.Description
+	stored.Location = event.Location
+	stored.DateTime = event.DateTime.UTC()
+	stored.Capacity = event.Capacity
+	stored.RRule = event.RRule
+	stored.ExDates = event.ExDates
+	stored.Sequence++
+	stored.UpdatedAt = time.Now().UTC()
+	r.store.events[event.ID] = stored
+
+	r.store.promoteWaitlisted(event.ID)
+	return nil
+}
+
+// Delete removes the event, its per-occurrence changes and its registrations
+func (r *MemoryEventRepository) Delete(_ context.Context, event Event) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	delete(r.store.events, event.ID)
+	delete(r.store.overrides, event.ID)
+	r.store.registrations = slices.DeleteFunc(r.store.registrations, func(registration memoryRegistration) bool {
+		return registration.eventId == event.ID
+	})
+	return nil
+}
+
+// List returns one page of events matching the filter
+func (r *MemoryEventRepository) List(_ context.Context, filter EventFilter, request PageRequest) (*Page[Event], error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	events := []Event{}
+	for _, event := range r.store.events {
+		if !filter.From.IsZero() && event.DateTime.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && event.DateTime.After(filter.To) {
+			continue
+		}
+		if filter.matches(event) {
+			events = append(events, event)
+		}
+	}
+
+	return pageItems(events, request, eventSorts, "id", func(e Event) pageKey { return pageKey{ID: e.ID} })
+}
+
+// matches reports whether the event meets the filter's location, owner and
+// text conditions, as apply does in SQL
+func (filter EventFilter) matches(e Event) bool {
+	contains := func(s, substr string) bool {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+	}
+
+	if filter.Location != "" && !contains(e.Location, filter.Location) {
+		return false
+	}
+	if filter.OwnerID != 0 && e.UserID != filter.OwnerID {
+		return false
+	}
+	if filter.Text != "" && !contains(e.Name, filter.Text) && !contains(e.Description, filter.Text) {
+		return false
+	}
+	return true
+}
+
+// ListOccurrences returns one page of the occurrences within the filter's date range
+func (r *MemoryEventRepository) ListOccurrences(_ context.Context, filter EventFilter, request PageRequest) (*Page[Occurrence], error) {
+	from, to := filter.From.UTC(), filter.To.UTC()
+	if from.IsZero() || to.IsZero() || to.Before(from) || to.Sub(from) > MaxOccurrenceWindow {
+		return nil, ErrInvalidWindow
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	occurrences := []Occurrence{}
+
+	for _, event := range r.store.events {
+		if !filter.matches(event) {
+			continue
+		}
+
+		expanded, err := event.expand(from, to, r.store.overrides[event.ID])
+		if err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, expanded...)
+		if len(occurrences) > MaxOccurrencesPerRequest {
+			return nil, ErrTooManyOccurrences
+		}
+	}
+
+	return pageItems(occurrences, request, occurrenceSorts, "start", Occurrence.pageKey)
+}
+
+// Search always returns ErrSearchUnavailable, as there is no full-text index in memory
+func (r *MemoryEventRepository) Search(query string, limit int) ([]SearchResult, int, error) {
+	return nil, 0, ErrSearchUnavailable
+}
+
+// GetOccurrence returns the occurrence of a recurring event that originally starts at start
+func (r *MemoryEventRepository) GetOccurrence(e Event, start time.Time) (*Occurrence, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.getOccurrence(e, start)
+}
+
+// UpdateOccurrence changes one occurrence of a recurring event
+func (r *MemoryEventRepository) UpdateOccurrence(e Event, start time.Time, changes OccurrenceChanges) (*Occurrence, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	_, err := r.store.getOccurrence(e, start)
+	if err != nil {
+		return nil, err
+	}
+
+	if changes.DateTime != nil {
+		dateTime := changes.DateTime.UTC()
+		changes.DateTime = &dateTime
+	}
+	r.store.setOverride(e.ID, occurrenceKey(start), occurrenceOverride{changes: changes})
+	r.store.touch(&e)
+
+	return r.store.getOccurrence(e, start)
+}
+
+// CancelOccurrence cancels one occurrence of a recurring event and removes its registrations
+func (r *MemoryEventRepository) CancelOccurrence(e Event, start time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	_, err := r.store.getOccurrence(e, start)
+	if err != nil {
+		return err
+	}
+
+	key := occurrenceKey(start)
+	override := r.store.overrides[e.ID][key]
+	override.cancelled = true
+	r.store.setOverride(e.ID, key, override)
+
+	r.store.registrations = slices.DeleteFunc(r.store.registrations, func(registration memoryRegistration) bool {
+		return registration.eventId == e.ID && registration.key == key
+	})
+
+	r.store.touch(&e)
+	return nil
+}
+
+// SeriesExceptions returns the changed occurrences and cancelled times of a recurring event
+func (r *MemoryEventRepository) SeriesExceptions(e Event) ([]Occurrence, []time.Time, error) {
+	if !e.IsRecurring() {
+		return nil, nil, nil
+	}
+
+	set, err := e.recurrence()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return e.seriesExceptions(set, r.store.overrides[e.ID])
+}
+
+// getOccurrence looks up an occurrence with the stored overrides
+func (s *memoryStore) getOccurrence(e Event, start time.Time) (*Occurrence, error) {
+	return e.findOccurrence(start, func(key string) (*occurrenceOverride, error) {
+		override, found := s.overrides[e.ID][key]
+		if !found {
+			return nil, nil
+		}
+		return &override, nil
+	})
+}
+
+// setOverride stores the per-occurrence changes of one occurrence
+func (s *memoryStore) setOverride(eventId int64, key string, override occurrenceOverride) {
+	if s.overrides[eventId] == nil {
+		s.overrides[eventId] = map[string]occurrenceOverride{}
+	}
+	s.overrides[eventId][key] = override
+}
+
+// touch increments the event's sequence number after one of its occurrences changed
+func (s *memoryStore) touch(e *Event) {
+	stored, found := s.events[e.ID]
+	if !found {
+		return
+	}
+
+	stored.Sequence++
+	stored.UpdatedAt = time.Now().UTC()
+	s.events[e.ID] = stored
+
+	e.Sequence, e.UpdatedAt = stored.Sequence, stored.UpdatedAt
+}
+
+// MemoryUserRepository is the UserRepository returned by NewMemoryRepositories
+type MemoryUserRepository struct {
+	store *memoryStore
+}
+
+// Save inserts a new, unverified user with the default role
+//
+// A second account with the same email address fails, as the unique index
+// makes it fail in the database.
+func (r *MemoryUserRepository) Save(ctx context.Context, u *User) error {
+	email, err := NormalizeEmail(u.Email)
+	if err != nil {
+		return err
+	}
+	u.Email = email
+
+	err = CheckPasswordPolicy(u.Password, u.Email)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(ctx, u.Password)
+	if err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	if r.store.findUser(u.Email) != nil {
+		return errors.New("UNIQUE constraint failed: users.email")
+	}
+
+	u.ID = r.store.nextID("users")
+	r.store.users[u.ID] = &memoryUser{
+		User:  User{ID: u.ID, Email: u.Email},
+		hash:  hashedPassword,
+		roles: []string{RoleUser},
+	}
+
+	observer.UserSignedUp()
+	return nil
+}
+
+// findUser returns the account with the email address, or nil
+func (s *memoryStore) findUser(email string) *memoryUser {
+	for _, user := range s.users {
+		if user.Email == email {
+			return user
+		}
+	}
+	return nil
+}
+
+// findUserByEmail returns the user whose stored email is the first of the email's candidates to match
+func (s *memoryStore) findUserByEmail(email string) *memoryUser {
+	for _, candidate := range emailCandidates(email) {
+		if user := s.findUser(candidate); user != nil {
+			return user
+		}
+	}
+	return nil
+}
+
+// GetByID returns the user with the ID, without the password hash, or ErrUserNotFound
+func (r *MemoryUserRepository) GetByID(id int64) (*User, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, found := r.store.users[id]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+
+	return &User{ID: user.ID, Email: user.Email, TokenVersion: user.TokenVersion, EmailVerifiedAt: user.EmailVerifiedAt}, nil
+}
+
+// ValidateCredentials checks the user's email and password and loads the
+// account's ID, token version and MFA status
+func (r *MemoryUserRepository) ValidateCredentials(ctx context.Context, u *User) error {
+	r.store.mu.Lock()
+	user := r.store.findUserByEmail(u.Email)
+	var hash string
+	if user != nil {
+		u.ID, u.Email, u.TokenVersion, u.MFAEnabled, hash = user.ID, user.Email, user.TokenVersion, user.MFAEnabled, user.hash
+	}
+	r.store.mu.Unlock()
+
+	if user == nil || !utils.CheckPasswordHash(ctx, u.Password, hash) {
+		return errors.New("credentials invalid")
+	}
+	return nil
+}
+
+// LoadRoles loads the user's role names and the union of their permissions
+func (r *MemoryUserRepository) LoadRoles(u *User) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	roles := []string{}
+	permissions := []string{}
+
+	if user, found := r.store.users[u.ID]; found {
+		roles = append(roles, user.roles...)
+		for _, role := range user.roles {
+			permissions = append(permissions, memoryRolePermissions[role]...)
+		}
+	}
+
+	slices.Sort(roles)
+	slices.Sort(permissions)
+	u.Roles = roles
+	u.Permissions = slices.Compact(permissions)
+	return nil
+}
+
+// SetRoles replaces every role of a user, or returns ErrUnknownRole
+func (r *MemoryUserRepository) SetRoles(userId int64, roles []string) error {
+	for _, role := range roles {
+		if _, found := memoryRolePermissions[role]; !found {
+			return ErrUnknownRole
+		}
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, found := r.store.users[userId]
+	if !found {
+		return ErrUserNotFound
+	}
+
+	user.roles = slices.Compact(slices.Sorted(slices.Values(roles)))
+	return nil
+}
+
+// GetTokenVersion returns the user's current token version
+func (r *MemoryUserRepository) GetTokenVersion(userId int64) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, found := r.store.users[userId]
+	if !found {
+		return 0, ErrUserNotFound
+	}
+	return user.TokenVersion, nil
+}
+
+// IsEmailVerified reports whether the user has verified their email address
+func (r *MemoryUserRepository) IsEmailVerified(userId int64) (bool, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, found := r.store.users[userId]
+	if !found {
+		return false, ErrUserNotFound
+	}
+	return user.EmailVerifiedAt != nil, nil
+}
+
+// VerifyEmail marks the user's address as verified if it is still email,
+// or returns ErrEmailVerificationInvalid
+func (r *MemoryUserRepository) VerifyEmail(userId int64, email string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, found := r.store.users[userId]
+	if !found || user.Email != email {
+		return ErrEmailVerificationInvalid
+	}
+	if user.EmailVerifiedAt == nil {
+		now := time.Now().UTC()
+		user.EmailVerifiedAt = &now
+	}
+	return nil
+}
+
+// MemoryRegistrationRepository is the RegistrationRepository returned by NewMemoryRepositories
+type MemoryRegistrationRepository struct {
+	store *memoryStore
+}
+
+// Register registers a user for the event, or adds them to the waitlist if it is full
+func (r *MemoryRegistrationRepository) Register(_ context.Context, e Event, userId int64, occurrence *time.Time) (*Registration, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	// The stored capacity counts, as in the SQL Register
+	stored, found := r.store.events[e.ID]
+	if !found {
+		return nil, ErrEventNotFound
+	}
+
+	key := ""
+	if e.IsRecurring() {
+		if occurrence == nil {
+			return nil, ErrOccurrenceRequired
+		}
+		_, err := r.store.getOccurrence(e, *occurrence)
+		if err != nil {
+			return nil, err
+		}
+		key = occurrenceKey(*occurrence)
+	} else if occurrence != nil {
+		return nil, ErrNoSuchOccurrence
+	}
+
+	if r.store.findRegistration(e.ID, key, userId) != nil {
+		return nil, ErrAlreadyRegistered
+	}
+
+	status := RegistrationRegistered
+	if stored.Capacity != nil && r.store.countRegistered(e.ID, key) >= *stored.Capacity {
+		status = RegistrationWaitlisted
+	}
+
+	r.store.registrations = append(r.store.registrations, memoryRegistration{
+		id:        r.store.nextID("registrations"),
+		eventId:   e.ID,
+		key:       key,
+		userId:    userId,
+		status:    status,
+		createdAt: time.Now().UTC(),
+	})
+
+	registration, err := r.store.getRegistration(e.ID, key, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	observer.UserRegistered(registration.Status)
+	return registration, nil
+}
+
+// Cancel removes a user's registration and promotes the first waitlisted user into a freed seat
+func (r *MemoryRegistrationRepository) Cancel(e Event, userId int64, occurrence *time.Time) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	key := registrationKey(occurrence)
+	if r.store.findRegistration(e.ID, key, userId) == nil {
+		return ErrNotRegistered
+	}
+
+	r.store.registrations = slices.DeleteFunc(r.store.registrations, func(registration memoryRegistration) bool {
+		return registration.eventId == e.ID && registration.key == key && registration.userId == userId
+	})
+
+	r.store.promoteWaitlisted(e.ID)
+	return nil
+}
+
+// Get returns the user's registration for the event, or ErrNotRegistered
+func (r *MemoryRegistrationRepository) Get(e Event, userId int64, occurrence *time.Time) (*Registration, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.getRegistration(e.ID, registrationKey(occurrence), userId)
+}
+
+// ListAttendees returns a page of the users registered or waitlisted for the event
+func (r *MemoryRegistrationRepository) ListAttendees(e Event, occurrence *time.Time, status string, request PageRequest) (*Page[Attendee], error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	attendees := []Attendee{}
+
+	for _, registration := range r.store.registrations {
+		if registration.eventId != e.ID {
+			continue
+		}
+		if occurrence != nil && registration.key != occurrenceKey(*occurrence) {
+			continue
+		}
+		if status != "" && registration.status != status {
+			continue
+		}
+
+		user, found := r.store.users[registration.userId]
+		if !found {
+			continue
+		}
+
+		start, err := parseOccurrenceKey(registration.key)
+		if err != nil {
+			return nil, err
+		}
+
+		attendees = append(attendees, Attendee{
+			ID:         registration.id,
+			Occurrence: start,
+			UserID:     registration.userId,
+			Email:      user.Email,
+			Status:     registration.status,
+			CreatedAt:  registration.createdAt,
+		})
+	}
+
+	return pageItems(attendees, request, attendeeSorts, "createdAt", func(a Attendee) pageKey { return pageKey{ID: a.ID} })
+}
+
+// ListForUser returns a page of the events a user is registered or waitlisted for
+func (r *MemoryRegistrationRepository) ListForUser(userId int64, request PageRequest) (*Page[UserRegistration], error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	registrations := []UserRegistration{}
+
+	for _, registration := range r.store.registrations {
+		event, found := r.store.events[registration.eventId]
+		if registration.userId != userId || !found {
+			continue
+		}
+
+		stored, err := r.store.getRegistration(registration.eventId, registration.key, userId)
+		if err != nil {
+			return nil, err
+		}
+
+		registrations = append(registrations, UserRegistration{
+			ID:         registration.id,
+			Event:      event,
+			Occurrence: stored.Occurrence,
+			Status:     stored.Status,
+			Position:   stored.Position,
+			CreatedAt:  stored.CreatedAt,
+		})
+	}
+
+	return pageItems(registrations, request, userRegistrationSorts, "createdAt", func(r UserRegistration) pageKey { return pageKey{ID: r.ID} })
+}
+
+// ListRegisteredOccurrences returns every event and occurrence the user holds a seat for
+func (r *MemoryRegistrationRepository) ListRegisteredOccurrences(userId int64) ([]Occurrence, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	occurrences := []Occurrence{}
+
+	for _, registration := range r.store.registrations {
+		event, found := r.store.events[registration.eventId]
+		if registration.userId != userId || registration.status != RegistrationRegistered || !found {
+			continue
+		}
+
+		start, err := parseOccurrenceKey(registration.key)
+		if err != nil {
+			return nil, err
+		}
+
+		if start == nil {
+			expanded, err := event.expand(event.DateTime, event.DateTime, nil)
+			if err != nil {
+				return nil, err
+			}
+			occurrences = append(occurrences, expanded...)
+			continue
+		}
+
+		occurrence, err := r.store.getOccurrence(event, *start)
+		if errors.Is(err, ErrNoSuchOccurrence) || errors.Is(err, ErrInvalidRecurrence) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		occurrences = append(occurrences, *occurrence)
+	}
+
+	return occurrences, nil
+}
+
+// findRegistration returns the user's registration for an occurrence, or nil
+func (s *memoryStore) findRegistration(eventId int64, key string, userId int64) *memoryRegistration {
+	for i, registration := range s.registrations {
+		if registration.eventId == eventId && registration.key == key && registration.userId == userId {
+			return &s.registrations[i]
+		}
+	}
+	return nil
+}
+
+// getRegistration returns a registration with its position within its status
+func (s *memoryStore) getRegistration(eventId int64, key string, userId int64) (*Registration, error) {
+	found := s.findRegistration(eventId, key, userId)
+	if found == nil {
+		return nil, ErrNotRegistered
+	}
+
+	position := 0
+	for _, registration := range s.registrations {
+		if registration.eventId == eventId && registration.key == key && registration.status == found.status && registration.id <= found.id {
+			position++
+		}
+	}
+
+	occurrence, err := parseOccurrenceKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Registration{
+		EventID:    eventId,
+		Occurrence: occurrence,
+		UserID:     userId,
+		Status:     found.status,
+		Position:   position,
+		CreatedAt:  found.createdAt,
+	}, nil
+}
+
+// countRegistered counts the seats taken for an occurrence
+func (s *memoryStore) countRegistered(eventId int64, key string) int64 {
+	var count int64
+	for _, registration := range s.registrations {
+		if registration.eventId == eventId && registration.key == key && registration.status == RegistrationRegistered {
+			count++
+		}
+	}
+	return count
+}
+
+// promoteWaitlisted moves waitlisted users into free seats, first come first
+// served; registrations are kept in ID order, so the slice order is the queue
+func (s *memoryStore) promoteWaitlisted(eventId int64) {
+	event, found := s.events[eventId]
+	if !found {
+		return
+	}
+
+	registered := map[string]int64{}
+	for _, registration := range s.registrations {
+		if registration.eventId == eventId && registration.status == RegistrationRegistered {
+			registered[registration.key]++
+		}
+	}
+
+	for i, registration := range s.registrations {
+		if registration.eventId != eventId || registration.status != RegistrationWaitlisted {
+			continue
+		}
+		if event.Capacity != nil && registered[registration.key] >= *event.Capacity {
+			continue
+		}
+
+		s.registrations[i].status = RegistrationRegistered
+		registered[registration.key]++
+	}
+}
@@ -0,0 +1,524 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/utils"
+)
+
+// memoryRefreshToken is a row of the refresh_tokens table
+type memoryRefreshToken struct {
+	userId    int64
+	familyId  string
+	expiresAt time.Time
+	rotatedAt *time.Time
+	revokedAt *time.Time
+}
+
+// memoryPasswordReset is a row of the password_resets table
+type memoryPasswordReset struct {
+	userId    int64
+	expiresAt time.Time
+	usedAt    *time.Time
+}
+
+// memoryLoginThrottle is a row of the login_throttles table
+type memoryLoginThrottle struct {
+	failures    int
+	lockedUntil *time.Time
+	updatedAt   time.Time
+}
+
+// ReserveVerificationEmail records that a verification email is about to be
+// sent, or returns how long to wait when too many were sent already
+func (r *MemoryUserRepository) ReserveVerificationEmail(userId int64) (time.Duration, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, found := r.store.users[userId]
+	if !found {
+		return 0, ErrUserNotFound
+	}
+	if user.EmailVerifiedAt != nil {
+		return 0, ErrEmailAlreadyVerified
+	}
+
+	now := time.Now().UTC()
+
+	var sent []time.Time
+	for _, sentAt := range r.store.verificationEmails[userId] {
+		if sentAt.After(now.Add(-24 * time.Hour)) {
+			sent = append(sent, sentAt)
+		}
+	}
+
+	var wait time.Duration
+	if len(sent) > 0 {
+		wait = max(wait, sent[len(sent)-1].Add(VerificationEmailInterval).Sub(now))
+	}
+	if len(sent) >= VerificationEmailsPerDay {
+		wait = max(wait, sent[len(sent)-VerificationEmailsPerDay].Add(24*time.Hour).Sub(now))
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+
+	r.store.verificationEmails[userId] = append(sent, now)
+	return 0, nil
+}
+
+// MemoryTokenRepository is the TokenRepository returned by NewMemoryRepositories
+type MemoryTokenRepository struct {
+	store *memoryStore
+}
+
+// IssueRefreshToken starts a new refresh token family for the user and returns the raw token
+func (r *MemoryTokenRepository) IssueRefreshToken(userId int64) (string, error) {
+	familyId, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.insertRefreshToken(userId, familyId)
+}
+
+// insertRefreshToken stores a new token of the family and returns it
+func (s *memoryStore) insertRefreshToken(userId int64, familyId string) (string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.refreshTokens[utils.HashToken(token)] = &memoryRefreshToken{
+		userId:    userId,
+		familyId:  familyId,
+		expiresAt: time.Now().UTC().Add(utils.RefreshTokenTTL),
+	}
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same
+// family; presenting a rotated token again revokes the whole family
+func (r *MemoryTokenRepository) RotateRefreshToken(token string) (string, int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored, found := r.store.refreshTokens[utils.HashToken(token)]
+	if !found || stored.revokedAt != nil {
+		return "", 0, ErrRefreshTokenInvalid
+	}
+	if stored.rotatedAt != nil {
+		r.store.revokeFamily(stored.familyId)
+		return "", 0, ErrRefreshTokenReused
+	}
+	if time.Now().After(stored.expiresAt) {
+		return "", 0, ErrRefreshTokenInvalid
+	}
+
+	now := time.Now().UTC()
+	stored.rotatedAt = &now
+
+	newToken, err := r.store.insertRefreshToken(stored.userId, stored.familyId)
+	if err != nil {
+		return "", 0, err
+	}
+	return newToken, stored.userId, nil
+}
+
+// RevokeRefreshToken revokes the family the refresh token belongs to
+func (r *MemoryTokenRepository) RevokeRefreshToken(token string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	stored, found := r.store.refreshTokens[utils.HashToken(token)]
+	if !found {
+		return ErrRefreshTokenInvalid
+	}
+
+	r.store.revokeFamily(stored.familyId)
+	return nil
+}
+
+// revokeFamily revokes every token of the family that is not revoked yet
+func (s *memoryStore) revokeFamily(familyId string) {
+	now := time.Now().UTC()
+	for _, stored := range s.refreshTokens {
+		if stored.familyId == familyId && stored.revokedAt == nil {
+			stored.revokedAt = &now
+		}
+	}
+}
+
+// IssueCalendarToken creates the user's calendar feed token, revoking the previous one
+func (r *MemoryTokenRepository) IssueCalendarToken(userId int64) (string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	r.store.calendarTokens[userId] = utils.HashToken(token)
+	return token, nil
+}
+
+// RevokeCalendarToken revokes the user's calendar feed token, if they have one
+func (r *MemoryTokenRepository) RevokeCalendarToken(userId int64) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	delete(r.store.calendarTokens, userId)
+	return nil
+}
+
+// UserIDForCalendarToken returns the ID of the user a calendar feed token belongs to
+func (r *MemoryTokenRepository) UserIDForCalendarToken(token string) (int64, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	hash := utils.HashToken(token)
+	for userId, stored := range r.store.calendarTokens {
+		if stored == hash {
+			return userId, nil
+		}
+	}
+	return 0, ErrCalendarTokenInvalid
+}
+
+// MemoryMFARepository is the MFARepository returned by NewMemoryRepositories
+type MemoryMFARepository struct {
+	store *memoryStore
+}
+
+// GetStatus returns whether the user has two-factor authentication and how many recovery codes are left
+func (r *MemoryMFARepository) GetStatus(userId int64) (*MFAStatus, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, found := r.store.users[userId]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+
+	return &MFAStatus{
+		Enabled:                user.totpEnabledAt != nil,
+		EnabledAt:              user.totpEnabledAt,
+		RecoveryCodesRemaining: len(user.recoveryCodes),
+	}, nil
+}
+
+// BeginTOTPEnrolment generates a new TOTP secret for the user and returns it
+func (r *MemoryMFARepository) BeginTOTPEnrolment(userId int64) (string, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, found := r.store.users[userId]
+	if !found || user.totpEnabledAt != nil {
+		return "", ErrMFAAlreadyEnabled
+	}
+
+	user.totpSecret = secret
+	return secret, nil
+}
+
+// ConfirmTOTPEnrolment enables two-factor authentication and returns fresh recovery codes
+func (r *MemoryMFARepository) ConfirmTOTPEnrolment(userId int64, code string) ([]string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user, found := r.store.users[userId]
+	if !found {
+		return nil, ErrUserNotFound
+	}
+	if user.totpEnabledAt != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.totpSecret == "" {
+		return nil, ErrMFAEnrolmentNotStarted
+	}
+
+	step, ok := utils.ValidateTOTP(user.totpSecret, code, time.Now())
+	if !ok {
+		return nil, ErrMFACodeInvalid
+	}
+
+	codes, err := user.replaceRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	user.totpEnabledAt = &now
+	user.totpLastStep = step
+	user.MFAEnabled = true
+	return codes, nil
+}
+
+// VerifySecondFactor checks and uses up a TOTP code or recovery code
+func (r *MemoryMFARepository) VerifySecondFactor(userId int64, code string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	return r.store.verifySecondFactor(userId, code)
+}
+
+// DisableTOTP turns two-factor authentication off; a current code is required
+func (r *MemoryMFARepository) DisableTOTP(userId int64, code string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	err := r.store.verifySecondFactor(userId, code)
+	if err != nil {
+		return err
+	}
+
+	user := r.store.users[userId]
+	user.totpSecret, user.totpEnabledAt, user.totpLastStep = "", nil, 0
+	user.recoveryCodes = nil
+	user.MFAEnabled = false
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes; a current code is required
+func (r *MemoryMFARepository) RegenerateRecoveryCodes(userId int64, code string) ([]string, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	err := r.store.verifySecondFactor(userId, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.store.users[userId].replaceRecoveryCodes()
+}
+
+// verifySecondFactor checks a TOTP code or recovery code like the SQL
+// verifySecondFactor does and uses it up
+func (s *memoryStore) verifySecondFactor(userId int64, code string) error {
+	user, found := s.users[userId]
+	if !found {
+		return ErrUserNotFound
+	}
+	if user.totpEnabledAt == nil || user.totpSecret == "" {
+		return ErrMFANotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+
+	if step, ok := utils.ValidateTOTP(user.totpSecret, code, time.Now()); ok {
+		if step <= user.totpLastStep {
+			return ErrMFACodeInvalid
+		}
+		user.totpLastStep = step
+		return nil
+	}
+
+	hash := utils.HashToken(normalizeRecoveryCode(code))
+	if !user.recoveryCodes[hash] {
+		return ErrMFACodeInvalid
+	}
+	delete(user.recoveryCodes, hash)
+	return nil
+}
+
+// replaceRecoveryCodes gives the user RecoveryCodeCount new recovery codes and returns them
+func (u *memoryUser) replaceRecoveryCodes() ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	u.recoveryCodes = map[string]bool{}
+	for _, hash := range hashes {
+		u.recoveryCodes[hash] = true
+	}
+	return codes, nil
+}
+
+// MemoryPasswordResetRepository is the PasswordResetRepository returned by NewMemoryRepositories
+type MemoryPasswordResetRepository struct {
+	store *memoryStore
+}
+
+// Create issues a password reset token for the account with the email and returns
+// it with the account's stored email, or returns ErrUserNotFound
+func (r *MemoryPasswordResetRepository) Create(email string) (string, string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	user := r.store.findUserByEmail(email)
+	if user == nil {
+		return "", "", ErrUserNotFound
+	}
+
+	r.store.passwordResets[utils.HashToken(token)] = &memoryPasswordReset{
+		userId:    user.ID,
+		expiresAt: time.Now().UTC().Add(PasswordResetTTL),
+	}
+	return token, user.Email, nil
+}
+
+// Reset sets a new password with a reset token and, like the SQL Reset, uses
+// up the user's reset tokens, revokes their refresh and calendar feed tokens
+// and increments their token version
+func (r *MemoryPasswordResetRepository) Reset(ctx context.Context, token string, password string) error {
+	hash := utils.HashToken(token)
+
+	r.store.mu.Lock()
+	reset, found := r.store.passwordResets[hash]
+	valid := found && reset.usedAt == nil && !time.Now().After(reset.expiresAt)
+	var email string
+	if valid {
+		email = r.store.users[reset.userId].Email
+	}
+	r.store.mu.Unlock()
+
+	if !valid {
+		return ErrResetTokenInvalid
+	}
+
+	err := CheckPasswordPolicy(password, email)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	// The token may have been used while the password was hashed
+	if reset.usedAt != nil {
+		return ErrResetTokenInvalid
+	}
+
+	now := time.Now().UTC()
+	user := r.store.users[reset.userId]
+	user.hash = hashedPassword
+	user.TokenVersion++
+
+	for _, other := range r.store.passwordResets {
+		if other.userId == user.ID && other.usedAt == nil {
+			other.usedAt = &now
+		}
+	}
+	for _, stored := range r.store.refreshTokens {
+		if stored.userId == user.ID && stored.revokedAt == nil {
+			stored.revokedAt = &now
+		}
+	}
+	delete(r.store.calendarTokens, user.ID)
+
+	return nil
+}
+
+// MemoryLoginRepository is the LoginRepository returned by NewMemoryRepositories
+type MemoryLoginRepository struct {
+	store *memoryStore
+}
+
+// ReserveAttempt counts a login attempt as a failure unless logins for the
+// email from the IP address are locked, like the SQL ReserveAttempt
+func (r *MemoryLoginRepository) ReserveAttempt(email string, ip string) (*LoginReservation, error) {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	now := time.Now().UTC()
+	keys := []struct {
+		policy loginThrottle
+		key    string
+	}{{accountThrottle, accountThrottle.key(email)}, {clientThrottle, clientThrottle.key(ip)}}
+
+	var wait time.Duration
+	for _, throttle := range keys {
+		stored, found := r.store.loginThrottles[throttle.key]
+		if found && stored.lockedUntil != nil && stored.lockedUntil.After(now) {
+			wait = max(wait, stored.lockedUntil.Sub(now))
+		}
+	}
+	if wait > 0 {
+		return &LoginReservation{Wait: wait}, nil
+	}
+
+	reservation := &LoginReservation{}
+	for i, throttle := range keys {
+		stored, found := r.store.loginThrottles[throttle.key]
+		if !found {
+			stored = &memoryLoginThrottle{}
+			r.store.loginThrottles[throttle.key] = stored
+		}
+
+		if now.Sub(stored.updatedAt) > FailureWindow {
+			stored.failures = 0
+		}
+		stored.failures++
+		stored.lockedUntil = throttle.policy.lock(stored.failures, now)
+		stored.updatedAt = now
+
+		reserved := reservedThrottle{key: throttle.key, lockedUntil: stored.lockedUntil}
+		if i == 0 {
+			reservation.account = reserved
+		} else {
+			reservation.client = reserved
+		}
+	}
+
+	return reservation, nil
+}
+
+// RecordAttempt adds an attempt to the audit log and settles its reservation
+// like the SQL RecordAttempt does
+func (r *MemoryLoginRepository) RecordAttempt(reservation *LoginReservation, attempt LoginAttempt) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	attempt.Email = normalizeThrottleEmail(attempt.Email)
+	r.store.loginAttempts = append(r.store.loginAttempts, attempt)
+
+	if reservation != nil {
+		var release []reservedThrottle
+		switch {
+		case attempt.Success:
+			delete(r.store.loginThrottles, accountThrottle.key(attempt.Email))
+			release = []reservedThrottle{reservation.client}
+		case attempt.Reason == LoginMFARequired:
+			release = []reservedThrottle{reservation.account, reservation.client}
+		}
+
+		for _, reserved := range release {
+			stored, found := r.store.loginThrottles[reserved.key]
+			if !found || stored.failures == 0 {
+				continue
+			}
+			stored.failures--
+			if reserved.lockedUntil != nil && stored.lockedUntil != nil && stored.lockedUntil.Equal(*reserved.lockedUntil) {
+				stored.lockedUntil = nil
+			}
+		}
+	}
+
+	if attempt.Reason != LoginMFARequired {
+		observer.LoginAttempted(attempt.Success, attempt.Reason)
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/utils"
+)
+
+// RecoveryCodeCount is how many recovery codes are issued when two-factor authentication is enabled
+const RecoveryCodeCount = 10
+
+var (
+	// ErrMFAAlreadyEnabled is returned when enrolling a user who already has two-factor authentication
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	// ErrMFANotEnabled is returned when a second factor is checked for a user without two-factor authentication
+	ErrMFANotEnabled = errors.New("two-factor authentication not enabled")
+	// ErrMFAEnrolmentNotStarted is returned when confirming an enrolment that was never started
+	ErrMFAEnrolmentNotStarted = errors.New("two-factor enrolment not started")
+	// ErrMFACodeInvalid is returned for wrong, expired or already used TOTP and recovery codes
+	ErrMFACodeInvalid = errors.New("two-factor code invalid")
+)
+
+// MFAStatus describes a user's two-factor authentication settings
+type MFAStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabledAt"`
+	RecoveryCodesRemaining int        `json:"recoveryCodesRemaining"`
+}
+
+// GetStatus returns whether the user has two-factor authentication and how many recovery codes are left
+func (r *SQLMFARepository) GetStatus(userId int64) (*MFAStatus, error) {
+	var status MFAStatus
+	err := r.DB.QueryRow("SELECT totp_enabled_at FROM users WHERE id = ?", userId).Scan(&status.EnabledAt)
+	if err != nil {
+		return nil, err
+	}
+	status.Enabled = status.EnabledAt != nil
+
+	err = r.DB.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userId).
+		Scan(&status.RecoveryCodesRemaining)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// BeginTOTPEnrolment generates a new TOTP secret for the user and returns it
+//
+// Two-factor authentication is not enabled until ConfirmTOTPEnrolment is called
+// with a code generated from the secret, which proves the user's authenticator
+// app has it. Starting again replaces a secret that was never confirmed.
+func (r *SQLMFARepository) BeginTOTPEnrolment(userId int64) (string, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := r.DB.Exec("UPDATE users SET totp_secret = ? WHERE id = ? AND totp_enabled_at IS NULL", secret, userId)
+	if err != nil {
+		return "", err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if affected == 0 {
+		return "", ErrMFAAlreadyEnabled
+	}
+
+	return secret, nil
+}
+
+// ConfirmTOTPEnrolment enables two-factor authentication once the user proves
+// they can generate codes, and returns a fresh set of recovery codes
+//
+// The recovery codes are only stored hashed, so this is the only time they can be shown.
+func (r *SQLMFARepository) ConfirmTOTPEnrolment(userId int64, code string) ([]string, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockUser(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	err = tx.QueryRow("SELECT totp_secret, totp_enabled_at FROM users WHERE id = ?", userId).Scan(&secret, &enabledAt)
+
+	if err != nil {
+		return nil, err
+	}
+	if enabledAt.Valid {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if !secret.Valid {
+		return nil, ErrMFAEnrolmentNotStarted
+	}
+
+	step, ok := utils.ValidateTOTP(secret.String, code, time.Now())
+	if !ok {
+		return nil, ErrMFACodeInvalid
+	}
+
+	_, err = tx.Exec("UPDATE users SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?", time.Now().UTC(), step, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// VerifySecondFactor checks a TOTP code or an unused recovery code for the user
+//
+// Each TOTP code and each recovery code is accepted only once. Returns
+// ErrMFANotEnabled if the user has no two-factor authentication and
+// ErrMFACodeInvalid if the code does not match.
+func (r *SQLMFARepository) VerifySecondFactor(userId int64, code string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = verifySecondFactor(tx, userId, code)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns two-factor authentication off and deletes the recovery codes;
+// a current TOTP code or recovery code is required
+func (r *SQLMFARepository) DisableTOTP(userId int64, code string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = verifySecondFactor(tx, userId, code)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with a fresh set
+// and returns it; a current TOTP code or recovery code is required
+func (r *SQLMFARepository) RegenerateRecoveryCodes(userId int64, code string) ([]string, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = verifySecondFactor(tx, userId, code)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, tx.Commit()
+}
+
+// verifySecondFactor checks a TOTP code or recovery code inside a transaction and uses it up
+//
+// The user's row is held from the start, so the same code cannot pass two
+// concurrent checks.
+func verifySecondFactor(tx *sql.Tx, userId int64, code string) error {
+	err := lockUser(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	var secret sql.NullString
+	var enabledAt sql.NullTime
+	var lastStep int64
+	err = tx.QueryRow("SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = ?", userId).
+		Scan(&secret, &enabledAt, &lastStep)
+	if err != nil {
+		return err
+	}
+	if !enabledAt.Valid || !secret.Valid {
+		return ErrMFANotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+
+	// A code from a step that was already used is a replay, even if it is still within the window
+	if step, ok := utils.ValidateTOTP(secret.String, code, time.Now()); ok {
+		if step <= lastStep {
+			return ErrMFACodeInvalid
+		}
+		_, err = tx.Exec("UPDATE users SET totp_last_step = ? WHERE id = ?", step, userId)
+		return err
+	}
+
+	result, err := tx.Exec("UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().UTC(), userId, utils.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrMFACodeInvalid
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores the hashes of RecoveryCodeCount new ones
+func replaceRecoveryCodes(tx *sql.Tx, userId int64) ([]string, error) {
+	_, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes(user_id, code_hash) VALUES (?, ?)", userId, hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCodes returns RecoveryCodeCount new recovery codes as shown
+// to the user, and the hashes to store for them
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, RecoveryCodeCount)
+	hashes := make([]string, 0, RecoveryCodeCount)
+
+	for range RecoveryCodeCount {
+		token, err := utils.GenerateOpaqueToken()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// 10 characters of the random token, shown as "xxxxx-xxxxx" for readability
+		raw := strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(token))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, utils.HashToken(raw))
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode lower-cases a recovery code and removes the separator
+// and any spaces, so codes typed by hand still match
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
@@ -0,0 +1,34 @@
+package models
+
+// Observer is told about business events after the models have recorded them
+//
+// It lets packages such as metrics follow what happens without the models
+// depending on them. Methods are called synchronously and must be fast.
+type Observer interface {
+	// UserSignedUp is called when an account has been created
+	UserSignedUp()
+	// LoginAttempted is called for every finished login attempt, with the
+	// reason recorded in the audit log (LoginSucceeded, LoginInvalidCredentials, ...)
+	LoginAttempted(success bool, reason string)
+	// EventCreated is called when an event has been created
+	EventCreated()
+	// UserRegistered is called when a user has registered for an event, with
+	// RegistrationRegistered or RegistrationWaitlisted
+	UserRegistered(status string)
+}
+
+// noopObserver ignores every event; it is used until SetObserver is called
+type noopObserver struct{}
+
+func (noopObserver) UserSignedUp()               {}
+func (noopObserver) LoginAttempted(bool, string) {}
+func (noopObserver) EventCreated()               {}
+func (noopObserver) UserRegistered(string)       {}
+
+// observer is told about business events, see SetObserver
+var observer Observer = noopObserver{}
+
+// SetObserver sets the observer that is told about business events
+func SetObserver(o Observer) {
+	observer = o
+}
@@ -0,0 +1,667 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// MaxOccurrenceWindow is the longest date range EventRepository.ListOccurrences expands recurring events over
+const MaxOccurrenceWindow = 366 * 24 * time.Hour
+
+// MaxOccurrencesPerSeries is how many occurrences of one series ListOccurrences
+// expands within its window; later occurrences of the series are left out
+const MaxOccurrencesPerSeries = 1000
+
+// MaxOccurrencesPerRequest is how many occurrences ListOccurrences expands in
+// total before failing with ErrTooManyOccurrences
+const MaxOccurrencesPerRequest = 5000
+
+// maxSeriesEndScan bounds how many occurrences are walked to find where a series ends
+const maxSeriesEndScan = 100000
+
+// ErrInvalidRecurrence is returned for a recurrence rule that cannot be parsed or repeats more than daily
+var ErrInvalidRecurrence = errors.New("invalid recurrence rule")
+
+// ErrInvalidWindow is returned when occurrences are listed without a bounded date range
+var ErrInvalidWindow = errors.New("invalid occurrence window")
+
+// ErrTooManyOccurrences is returned when the window holds more than MaxOccurrencesPerRequest occurrences
+var ErrTooManyOccurrences = errors.New("too many occurrences")
+
+// ErrOccurrenceRequired is returned when registering for a recurring event without choosing an occurrence
+var ErrOccurrenceRequired = errors.New("occurrence required")
+
+// ErrNoSuchOccurrence is returned when a time is not an occurrence of the event, or the occurrence was cancelled
+var ErrNoSuchOccurrence = errors.New("no such occurrence")
+
+// Occurrence is a single instance of an event
+//
+// OriginalStart identifies the occurrence within its series and is what
+// registrations and per-occurrence changes refer to; it is nil for events
+// without a recurrence rule. Start is when the occurrence actually takes
+// place, which differs from OriginalStart if the occurrence was rescheduled.
+type Occurrence struct {
+	EventID       int64
+	OriginalStart *time.Time
+	Start         time.Time
+	Name          string
+	Description   string
+	Location      string
+	UserID        int64
+	Capacity      *int64
+	Modified      bool // Changed without changing the rest of the series
+	Sequence      int64
+	UpdatedAt     time.Time
+}
+
+// OccurrenceChanges overrides fields of one occurrence; nil fields keep the series' value
+type OccurrenceChanges struct {
+	Name        *string    `json:"name" binding:"omitempty,min=1"`
+	Description *string    `json:"description" binding:"omitempty,min=1"`
+	Location    *string    `json:"location" binding:"omitempty,min=1"`
+	DateTime    *time.Time `json:"dateTime"`
+}
+
+// occurrenceOverride is a row of the event_occurrences table
+type occurrenceOverride struct {
+	cancelled bool
+	changes   OccurrenceChanges
+}
+
+// IsRecurring reports whether the event has a recurrence rule
+func (e Event) IsRecurring() bool {
+	return e.RRule != ""
+}
+
+// occurrenceKey formats an occurrence's original start as stored in the database
+func occurrenceKey(start time.Time) string {
+	return start.UTC().Format(time.RFC3339)
+}
+
+// parseOccurrenceKey is the inverse of occurrenceKey; the empty key means the whole event
+func parseOccurrenceKey(key string) (*time.Time, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	start, err := time.Parse(time.RFC3339, key)
+	if err != nil {
+		return nil, err
+	}
+	return &start, nil
+}
+
+func parseExDates(value string) ([]time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var exdates []time.Time
+	for _, key := range strings.Split(value, ",") {
+		exdate, err := time.Parse(time.RFC3339, key)
+		if err != nil {
+			return nil, err
+		}
+		exdates = append(exdates, exdate)
+	}
+	return exdates, nil
+}
+
+// normalizeRecurrence validates the recurrence rule and returns the EXDATEs as stored in the database
+//
+// Recurring events are expanded in UTC with one-second precision, so their
+// start is normalized accordingly. New rules may repeat at most daily; hourly
+// rules saved before that limit still expand, within MaxOccurrencesPerSeries.
+func (e *Event) normalizeRecurrence() (string, error) {
+	if !e.IsRecurring() {
+		if len(e.ExDates) > 0 {
+			return "", fmt.Errorf("%w: exdates need a recurrence rule", ErrInvalidRecurrence)
+		}
+		return "", nil
+	}
+
+	e.RRule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(e.RRule)), "RRULE:")
+	e.DateTime = e.DateTime.UTC().Truncate(time.Second)
+
+	_, err := e.recurrence()
+	if err != nil {
+		return "", err
+	}
+
+	option, err := rrule.StrToROption(e.RRule)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	if option.Freq == rrule.HOURLY {
+		return "", fmt.Errorf("%w: events cannot repeat more than daily", ErrInvalidRecurrence)
+	}
+
+	keys := make([]string, len(e.ExDates))
+	for i, exdate := range e.ExDates {
+		keys[i] = occurrenceKey(exdate)
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ","), nil
+}
+
+// recurrence builds the event's recurrence set starting at DateTime
+func (e Event) recurrence() (*rrule.Set, error) {
+	if strings.Contains(e.RRule, "DTSTART") {
+		return nil, fmt.Errorf("%w: the series starts at the event's dateTime", ErrInvalidRecurrence)
+	}
+
+	option, err := rrule.StrToROption(e.RRule)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	if option.Freq == rrule.MINUTELY || option.Freq == rrule.SECONDLY {
+		return nil, fmt.Errorf("%w: events cannot repeat more than hourly", ErrInvalidRecurrence)
+	}
+
+	start := e.DateTime.UTC().Truncate(time.Second)
+	option.Dtstart = start
+
+	rule, err := rrule.NewRRule(*option)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+
+	set := &rrule.Set{}
+	set.DTStart(start)
+	set.RRule(rule)
+	for _, exdate := range e.ExDates {
+		set.ExDate(exdate.UTC().Truncate(time.Second))
+	}
+
+	return set, nil
+}
+
+// seriesEnd returns the original start of the series' last occurrence, or nil
+// if the event is not recurring, its rule has neither UNTIL nor COUNT, or the
+// series is too long to walk
+func (e Event) seriesEnd() (*time.Time, error) {
+	if !e.IsRecurring() {
+		return nil, nil
+	}
+
+	option, err := rrule.StrToROption(e.RRule)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	if option.Until.IsZero() && option.Count == 0 {
+		return nil, nil
+	}
+
+	set, err := e.recurrence()
+	if err != nil {
+		return nil, err
+	}
+
+	var last time.Time
+	next := set.Iterator()
+	for i := 0; ; i++ {
+		start, ok := next()
+		if !ok {
+			break
+		}
+		if i == maxSeriesEndScan {
+			return nil, nil
+		}
+		last = start.UTC()
+	}
+	if last.IsZero() {
+		return nil, nil
+	}
+	return &last, nil
+}
+
+// hasOccurrence reports whether the series has an occurrence starting at start
+func hasOccurrence(set *rrule.Set, start time.Time) bool {
+	return len(set.Between(start, start, true)) > 0
+}
+
+// occurrence applies an override to the occurrence of the series starting at originalStart
+func (e Event) occurrence(originalStart time.Time, override *occurrenceOverride) Occurrence {
+	occurrence := Occurrence{
+		EventID:       e.ID,
+		OriginalStart: &originalStart,
+		Start:         originalStart,
+		Name:          e.Name,
+		Description:   e.Description,
+		Location:      e.Location,
+		UserID:        e.UserID,
+		Capacity:      e.Capacity,
+		Sequence:      e.Sequence,
+		UpdatedAt:     e.UpdatedAt,
+	}
+
+	if override == nil {
+		return occurrence
+	}
+
+	changes := override.changes
+	if changes.Name != nil {
+		occurrence.Name = *changes.Name
+	}
+	if changes.Description != nil {
+		occurrence.Description = *changes.Description
+	}
+	if changes.Location != nil {
+		occurrence.Location = *changes.Location
+	}
+	if changes.DateTime != nil {
+		occurrence.Start = changes.DateTime.UTC()
+	}
+	occurrence.Modified = true
+
+	return occurrence
+}
+
+// expand returns the event's occurrences that take place between from and to, inclusive
+//
+// At most MaxOccurrencesPerSeries occurrences originally starting in the
+// window are expanded.
+func (e Event) expand(from, to time.Time, overrides map[string]occurrenceOverride) ([]Occurrence, error) {
+	inWindow := func(t time.Time) bool { return !t.Before(from) && !t.After(to) }
+
+	if !e.IsRecurring() {
+		if !inWindow(e.DateTime) {
+			return nil, nil
+		}
+		return []Occurrence{{
+			EventID:     e.ID,
+			Start:       e.DateTime.UTC(),
+			Name:        e.Name,
+			Description: e.Description,
+			Location:    e.Location,
+			UserID:      e.UserID,
+			Capacity:    e.Capacity,
+			Sequence:    e.Sequence,
+			UpdatedAt:   e.UpdatedAt,
+		}}, nil
+	}
+
+	set, err := e.recurrence()
+	if err != nil {
+		return nil, err
+	}
+
+	var occurrences []Occurrence
+	seen := map[string]bool{}
+
+	next := set.Iterator()
+	for expanded := 0; expanded < MaxOccurrencesPerSeries; {
+		start, ok := next()
+		if !ok || start.After(to) {
+			break
+		}
+		if start.Before(from) {
+			continue
+		}
+		expanded++
+
+		key := occurrenceKey(start)
+		seen[key] = true
+
+		override, found := overrides[key]
+		if found && override.cancelled {
+			continue
+		}
+
+		var occurrence Occurrence
+		if found {
+			occurrence = e.occurrence(start.UTC(), &override)
+		} else {
+			occurrence = e.occurrence(start.UTC(), nil)
+		}
+
+		if inWindow(occurrence.Start) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+
+	// Occurrences rescheduled into the window from outside of it
+	for key, override := range overrides {
+		if seen[key] || override.cancelled || override.changes.DateTime == nil || !inWindow(*override.changes.DateTime) {
+			continue
+		}
+
+		start, err := parseOccurrenceKey(key)
+		if err != nil || start == nil || !hasOccurrence(set, *start) {
+			continue
+		}
+
+		occurrences = append(occurrences, e.occurrence(*start, &override))
+	}
+
+	return occurrences, nil
+}
+
+// pageKey identifies the occurrence for paging: every occurrence of a series
+// has the event's ID, so the original start tells them apart
+func (o Occurrence) pageKey() pageKey {
+	if o.OriginalStart == nil {
+		return pageKey{ID: o.EventID}
+	}
+	return pageKey{ID: o.EventID, Tie: occurrenceKey(*o.OriginalStart)}
+}
+
+// occurrenceSorts are the sort keys accepted by ListOccurrences
+var occurrenceSorts = map[string]SortField[Occurrence]{
+	"start": {Column: "start", Type: ColumnTime, Value: func(o Occurrence) any { return o.Start }},
+}
+
+// ListOccurrences returns one page of the event occurrences taking place within the filter's date range
+//
+// Recurring events are expanded into their individual occurrences, skipping
+// EXDATEs and cancelled occurrences and applying per-occurrence changes;
+// single events appear as one occurrence. The filter must have both From and
+// To, at most MaxOccurrenceWindow apart. The sort key is "start" (the default)
+// or "-start". Each series contributes at most MaxOccurrencesPerSeries
+// occurrences, and a window with more than MaxOccurrencesPerRequest in total
+// fails with ErrTooManyOccurrences.
+func (r *SQLEventRepository) ListOccurrences(ctx context.Context, filter EventFilter, request PageRequest) (_ *Page[Occurrence], err error) {
+	ctx, span := startSpan(ctx, "EventRepository.ListOccurrences")
+	defer func() { endSpan(span, err) }()
+
+	from, to := filter.From.UTC(), filter.To.UTC()
+	if from.IsZero() || to.IsZero() || to.Before(from) || to.Sub(from) > MaxOccurrenceWindow {
+		return nil, ErrInvalidWindow
+	}
+
+	// A series may have occurrences in the window if it overlaps it, or if
+	// one of its occurrences was rescheduled into it
+	var query ListQuery[Event]
+	query.Filter(`((events.rrule = '' AND events.dateTime >= ? AND events.dateTime <= ?)
+	OR (events.rrule != '' AND events.dateTime <= ? AND (events.series_end IS NULL OR events.series_end >= ?))
+	OR (events.rrule != '' AND EXISTS (
+		SELECT 1 FROM event_occurrences o
+		WHERE o.event_id = events.id AND o.cancelled = 0 AND o.dateTime >= ? AND o.dateTime <= ?)))`,
+		from, to, to, from, from, to)
+	filter.apply(&query)
+
+	rows, err := r.DB.QueryContext(ctx, "SELECT "+eventColumns+" FROM events WHERE "+strings.Join(query.Where, " AND "), query.Args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var seriesIds []int64
+
+	for rows.Next() {
+		event, err := scanEvent(rows)
+
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		events = append(events, event)
+		if event.IsRecurring() {
+			seriesIds = append(seriesIds, event.ID)
+		}
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	overrides, err := loadOverrides(ctx, r.DB, seriesIds)
+	if err != nil {
+		return nil, err
+	}
+
+	occurrences := []Occurrence{}
+
+	for _, event := range events {
+		expanded, err := event.expand(from, to, overrides[event.ID])
+		if err != nil {
+			return nil, err
+		}
+		occurrences = append(occurrences, expanded...)
+		if len(occurrences) > MaxOccurrencesPerRequest {
+			return nil, ErrTooManyOccurrences
+		}
+	}
+
+	return pageItems(occurrences, request, occurrenceSorts, "start", Occurrence.pageKey)
+}
+
+// loadOverrides reads the per-occurrence changes of the given events, keyed by event ID and occurrence
+func loadOverrides(ctx context.Context, database *sql.DB, eventIds []int64) (map[int64]map[string]occurrenceOverride, error) {
+	overrides := map[int64]map[string]occurrenceOverride{}
+	if len(eventIds) == 0 {
+		return overrides, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(eventIds)), ", ")
+	args := make([]any, len(eventIds))
+	for i, id := range eventIds {
+		args[i] = id
+	}
+
+	query := `
+	SELECT event_id, occurrence, cancelled, name, description, location, dateTime
+	FROM event_occurrences
+	WHERE event_id IN (` + placeholders + `)`
+
+	rows, err := database.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventId int64
+		var key string
+		override, err := scanOverride(rows, &eventId, &key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if overrides[eventId] == nil {
+			overrides[eventId] = map[string]occurrenceOverride{}
+		}
+		overrides[eventId][key] = *override
+	}
+
+	return overrides, rows.Err()
+}
+
+// scanOverride reads the cancelled, name, description, location and dateTime
+// columns of event_occurrences, preceded by the given extra columns
+func scanOverride(row scanner, extra ...any) (*occurrenceOverride, error) {
+	var override occurrenceOverride
+	var name, description, location sql.NullString
+	var dateTime sql.NullTime
+
+	err := row.Scan(append(extra, &override.cancelled, &name, &description, &location, &dateTime)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if name.Valid {
+		override.changes.Name = &name.String
+	}
+	if description.Valid {
+		override.changes.Description = &description.String
+	}
+	if location.Valid {
+		override.changes.Location = &location.String
+	}
+	if dateTime.Valid {
+		override.changes.DateTime = &dateTime.Time
+	}
+
+	return &override, nil
+}
+
+// GetOccurrence returns the occurrence of a recurring event that originally starts at start
+func (r *SQLEventRepository) GetOccurrence(e Event, start time.Time) (*Occurrence, error) {
+	return e.getOccurrence(r.DB, start)
+}
+
+func (e Event) getOccurrence(q queryRower, start time.Time) (*Occurrence, error) {
+	return e.findOccurrence(start, func(key string) (*occurrenceOverride, error) {
+		row := q.QueryRow(`
+		SELECT cancelled, name, description, location, dateTime
+		FROM event_occurrences
+		WHERE event_id = ? AND occurrence = ?`, e.ID, key)
+
+		override, err := scanOverride(row)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return override, err
+	})
+}
+
+// findOccurrence returns the occurrence of a recurring event that originally
+// starts at start, applying the override returned by lookup for its key
+//
+// lookup returns nil if the occurrence has no override.
+func (e Event) findOccurrence(start time.Time, lookup func(key string) (*occurrenceOverride, error)) (*Occurrence, error) {
+	if !e.IsRecurring() {
+		return nil, ErrNoSuchOccurrence
+	}
+
+	set, err := e.recurrence()
+	if err != nil {
+		return nil, err
+	}
+
+	start = start.UTC()
+	if !hasOccurrence(set, start) {
+		return nil, ErrNoSuchOccurrence
+	}
+
+	override, err := lookup(occurrenceKey(start))
+	if err != nil {
+		return nil, err
+	}
+	if override != nil && override.cancelled {
+		return nil, ErrNoSuchOccurrence
+	}
+
+	occurrence := e.occurrence(start, override)
+	return &occurrence, nil
+}
+
+// UpdateOccurrence changes one occurrence of a recurring event without changing the rest of the series
+//
+// The changes replace any earlier changes to the same occurrence. Registrations
+// stay attached to the occurrence, even if it is rescheduled.
+func (r *SQLEventRepository) UpdateOccurrence(e Event, start time.Time, changes OccurrenceChanges) (*Occurrence, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = lockEvent(tx, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = e.getOccurrence(tx, start)
+	if err != nil {
+		return nil, err
+	}
+
+	var dateTime any
+	if changes.DateTime != nil {
+		dateTime = changes.DateTime.UTC()
+	}
+
+	query := `
+	INSERT INTO event_occurrences(event_id, occurrence, name, description, location, dateTime)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(event_id, occurrence) DO UPDATE SET
+		name = excluded.name, description = excluded.description,
+		location = excluded.location, dateTime = excluded.dateTime`
+
+	_, err = tx.Exec(query, e.ID, occurrenceKey(start), changes.Name, changes.Description, changes.Location, dateTime)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.touch(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	occurrence, err := e.getOccurrence(tx, start)
+	if err != nil {
+		return nil, err
+	}
+
+	return occurrence, tx.Commit()
+}
+
+// CancelOccurrence cancels one occurrence of a recurring event and removes its registrations
+func (r *SQLEventRepository) CancelOccurrence(e Event, start time.Time) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Held so that nobody registers for the occurrence while it is cancelled
+	_, err = lockEvent(tx, e.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.getOccurrence(tx, start)
+	if err != nil {
+		return err
+	}
+
+	key := occurrenceKey(start)
+
+	query := `
+	INSERT INTO event_occurrences(event_id, occurrence, cancelled)
+	VALUES (?, ?, 1)
+	ON CONFLICT(event_id, occurrence) DO UPDATE SET cancelled = 1`
+
+	_, err = tx.Exec(query, e.ID, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM registrations WHERE event_id = ? AND occurrence = ?", e.ID, key)
+	if err != nil {
+		return err
+	}
+
+	err = e.touch(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// touch increments the event's sequence number after one of its occurrences
+// changed, so calendar subscribers pick up the change
+func (e *Event) touch(tx *sql.Tx) error {
+	now := time.Now().UTC()
+	_, err := tx.Exec("UPDATE events SET sequence = sequence + 1, updated_at = ? WHERE id = ?", now, e.ID)
+	if err != nil {
+		return err
+	}
+
+	e.Sequence++
+	e.UpdatedAt = now
+	return nil
+}
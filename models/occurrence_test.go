@@ -0,0 +1,217 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// seriesStart is the first occurrence of the series in these tests
+var seriesStart = time.Date(2030, time.March, 4, 18, 0, 0, 0, time.UTC)
+
+// createSeries saves a recurring event owned by the user
+func createSeries(t *testing.T, repositories Repositories, ownerId int64, rrule string) Event {
+	t.Helper()
+
+	event := Event{
+		Name:        "Book club",
+		Description: "A test series",
+		Location:    "Library",
+		DateTime:    seriesStart,
+		UserID:      ownerId,
+		RRule:       rrule,
+	}
+	err := repositories.Events.Save(context.Background(), &event)
+	if err != nil {
+		t.Fatalf("Save series: %v", err)
+	}
+	return event
+}
+
+// seriesWindow is an occurrence window covering a month of the series
+var seriesWindow = EventFilter{From: seriesStart.Add(-time.Hour), To: seriesStart.Add(31 * 24 * time.Hour)}
+
+// originalStarts returns the occurrences' original starts as occurrence keys
+func originalStarts(occurrences []Occurrence) []string {
+	keys := make([]string, len(occurrences))
+	for i, occurrence := range occurrences {
+		if occurrence.OriginalStart != nil {
+			keys[i] = occurrenceKey(*occurrence.OriginalStart)
+		}
+	}
+	return keys
+}
+
+func TestOccurrencePagesCrossASeries(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		owner := createUser(t, repositories, "owner@example.com")
+		series := createSeries(t, repositories, owner, "FREQ=DAILY;COUNT=5")
+
+		// Moving the second occurrence onto the third's start gives two
+		// occurrences of the series the same start
+		third := seriesStart.Add(48 * time.Hour)
+		_, err := repositories.Events.UpdateOccurrence(series, seriesStart.Add(24*time.Hour), OccurrenceChanges{DateTime: &third})
+		if err != nil {
+			t.Fatalf("UpdateOccurrence: %v", err)
+		}
+
+		var forward []string
+		request := PageRequest{Limit: 1}
+		for {
+			page, err := repositories.Events.ListOccurrences(context.Background(), seriesWindow, request)
+			if err != nil {
+				t.Fatalf("ListOccurrences: %v", err)
+			}
+			forward = append(forward, originalStarts(page.Items)...)
+			if page.Next == "" {
+				break
+			}
+			if len(forward) > 5 {
+				t.Fatalf("paging forward did not end: %v", forward)
+			}
+			request.Cursor = page.Next
+		}
+
+		if len(forward) != 5 {
+			t.Fatalf("paged through %d occurrences, want 5: %v", len(forward), forward)
+		}
+		seen := map[string]bool{}
+		for _, key := range forward {
+			if seen[key] {
+				t.Fatalf("occurrence %s appeared twice: %v", key, forward)
+			}
+			seen[key] = true
+		}
+
+		// Walking back from the last page returns the same occurrences in reverse
+		page, err := repositories.Events.ListOccurrences(context.Background(), seriesWindow, PageRequest{Limit: 1, Cursor: request.Cursor})
+		if err != nil {
+			t.Fatalf("ListOccurrences: %v", err)
+		}
+		backward := originalStarts(page.Items)
+		for page.Prev != "" {
+			page, err = repositories.Events.ListOccurrences(context.Background(), seriesWindow, PageRequest{Limit: 1, Cursor: page.Prev})
+			if err != nil {
+				t.Fatalf("ListOccurrences: %v", err)
+			}
+			backward = append(originalStarts(page.Items), backward...)
+			if len(backward) > 5 {
+				t.Fatalf("paging backward did not end: %v", backward)
+			}
+		}
+		if len(backward) != 5 {
+			t.Errorf("paged back through %d occurrences, want 5: %v", len(backward), backward)
+		}
+		for i := range backward {
+			if i < len(forward) && backward[i] != forward[i] {
+				t.Errorf("backward pages = %v, want %v", backward, forward)
+				break
+			}
+		}
+	})
+}
+
+func TestRecurrenceRepeatsAtMostDaily(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		owner := createUser(t, repositories, "owner@example.com")
+
+		event := Event{Name: "Stand-up", Description: "Hourly", Location: "Office", DateTime: seriesStart, UserID: owner, RRule: "FREQ=HOURLY"}
+		err := repositories.Events.Save(context.Background(), &event)
+		if !errors.Is(err, ErrInvalidRecurrence) {
+			t.Fatalf("Save with an hourly rule: err = %v, want %v", err, ErrInvalidRecurrence)
+		}
+	})
+}
+
+func TestSeriesEnd(t *testing.T) {
+	tests := []struct {
+		rrule string
+		want  time.Time // Zero for no end
+	}{
+		{"", time.Time{}},
+		{"FREQ=DAILY", time.Time{}},
+		{"FREQ=DAILY;COUNT=5", seriesStart.Add(4 * 24 * time.Hour)},
+		{"FREQ=WEEKLY;UNTIL=20300401T000000Z", seriesStart.Add(3 * 7 * 24 * time.Hour)},
+	}
+
+	for _, test := range tests {
+		end, err := Event{DateTime: seriesStart, RRule: test.rrule}.seriesEnd()
+		if err != nil {
+			t.Fatalf("seriesEnd of %q: %v", test.rrule, err)
+		}
+		if (end == nil) != test.want.IsZero() || end != nil && !end.Equal(test.want) {
+			t.Errorf("seriesEnd of %q = %v, want %v", test.rrule, end, test.want)
+		}
+	}
+}
+
+func TestEndedSeriesKeepOccurrencesRescheduledIntoTheWindow(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		owner := createUser(t, repositories, "owner@example.com")
+		series := createSeries(t, repositories, owner, "FREQ=DAILY;COUNT=3")
+
+		later := seriesStart.Add(60 * 24 * time.Hour)
+		window := EventFilter{From: later.Add(-time.Hour), To: later.Add(time.Hour)}
+
+		page, err := repositories.Events.ListOccurrences(context.Background(), window, PageRequest{})
+		if err != nil {
+			t.Fatalf("ListOccurrences: %v", err)
+		}
+		if len(page.Items) != 0 {
+			t.Fatalf("listed %d occurrences after the series ended, want 0", len(page.Items))
+		}
+
+		_, err = repositories.Events.UpdateOccurrence(series, seriesStart, OccurrenceChanges{DateTime: &later})
+		if err != nil {
+			t.Fatalf("UpdateOccurrence: %v", err)
+		}
+
+		page, err = repositories.Events.ListOccurrences(context.Background(), window, PageRequest{})
+		if err != nil {
+			t.Fatalf("ListOccurrences: %v", err)
+		}
+		if len(page.Items) != 1 || !page.Items[0].Start.Equal(later) {
+			t.Errorf("listed %v, want the occurrence rescheduled to %s", page.Items, later)
+		}
+	})
+}
+
+func TestOccurrencesPerSeriesAreCapped(t *testing.T) {
+	// Hourly rules can no longer be saved, but older ones still expand
+	event := Event{ID: 1, DateTime: seriesStart, RRule: "FREQ=HOURLY"}
+	occurrences, err := event.expand(seriesStart, seriesStart.Add(MaxOccurrenceWindow), nil)
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	if len(occurrences) != MaxOccurrencesPerSeries {
+		t.Fatalf("expanded %d occurrences, want %d", len(occurrences), MaxOccurrencesPerSeries)
+	}
+	if !occurrences[0].Start.Equal(seriesStart) {
+		t.Errorf("first occurrence starts at %s, want %s", occurrences[0].Start, seriesStart)
+	}
+}
+
+func TestOccurrencesPerRequestAreCapped(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		owner := createUser(t, repositories, "owner@example.com")
+		window := EventFilter{From: seriesStart, To: seriesStart.Add(MaxOccurrenceWindow)}
+
+		// Each daily series has 367 occurrences in the window
+		series := MaxOccurrencesPerRequest/367 + 1
+		for i := 0; i < series; i++ {
+			createSeries(t, repositories, owner, "FREQ=DAILY")
+		}
+
+		_, err := repositories.Events.ListOccurrences(context.Background(), window, PageRequest{})
+		if !errors.Is(err, ErrTooManyOccurrences) {
+			t.Fatalf("ListOccurrences: err = %v, want %v", err, ErrTooManyOccurrences)
+		}
+
+		window.To = seriesStart.Add(30 * 24 * time.Hour)
+		_, err = repositories.Events.ListOccurrences(context.Background(), window, PageRequest{})
+		if err != nil {
+			t.Errorf("ListOccurrences over a month: %v", err)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Password length limits; the maximum is bcrypt's input limit in bytes, so a
+// password stays usable whichever hashing scheme is configured
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 72
+)
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords is the bundled list of frequently used passwords, lower-cased
+var commonPasswords = parseCommonPasswords(commonPasswordList)
+
+// PasswordPolicyError explains why a password was rejected; its message can be shown to the user
+type PasswordPolicyError struct {
+	Message string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Message
+}
+
+// CheckPasswordPolicy rejects passwords that are too short or too long, that
+// appear in the bundled list of common passwords, or that contain the user's
+// email address or the part of it before the "@"
+//
+// Returns a *PasswordPolicyError describing the first rule that failed.
+func CheckPasswordPolicy(password string, email string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return &PasswordPolicyError{Message: fmt.Sprintf("Password must be at least %d characters long.", MinPasswordLength)}
+	}
+	if len(password) > MaxPasswordLength {
+		return &PasswordPolicyError{Message: fmt.Sprintf("Password must be at most %d bytes long.", MaxPasswordLength)}
+	}
+
+	lower := strings.ToLower(password)
+
+	if _, common := commonPasswords[lower]; common {
+		return &PasswordPolicyError{Message: "Password is too common."}
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, _, _ := strings.Cut(email, "@")
+	if email != "" && strings.Contains(lower, email) || len(local) >= 3 && strings.Contains(lower, local) {
+		return &PasswordPolicyError{Message: "Password must not contain your email address."}
+	}
+
+	return nil
+}
+
+func parseCommonPasswords(list string) map[string]struct{} {
+	passwords := map[string]struct{}{}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			passwords[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return passwords
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	tests := []struct {
+		password string
+		email    string
+		want     string // The rejection message, or empty if the password is allowed
+	}{
+		{testPassword, "ada@example.com", ""},
+		{"Short1!", "ada@example.com", "Password must be at least 8 characters long."},
+		{"ünïcödé", "ada@example.com", "Password must be at least 8 characters long."},
+		{"ünïcödéé", "ada@example.com", ""}, // Length counts characters, not bytes
+		{strings.Repeat("x", MaxPasswordLength), "ada@example.com", ""},
+		{strings.Repeat("x", MaxPasswordLength+1), "ada@example.com", "Password must be at most 72 bytes long."},
+		{strings.Repeat("é", 37), "ada@example.com", "Password must be at most 72 bytes long."},
+		{"password123", "ada@example.com", "Password is too common."},
+		{"PassWord123", "ada@example.com", "Password is too common."},
+		{"xX-ada@example.com-Xx", "ada@example.com", "Password must not contain your email address."},
+		{"Lovelace-ADA-1815", " Ada@Example.com ", "Password must not contain your email address."},
+		{"Lovelace-al-1815", "al@example.com", ""}, // Local parts under 3 characters are not checked
+		{testPassword, "", ""},
+	}
+
+	for _, test := range tests {
+		err := CheckPasswordPolicy(test.password, test.email)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("CheckPasswordPolicy(%q, %q) = %v, want nil", test.password, test.email, err)
+			}
+			continue
+		}
+
+		var policyError *PasswordPolicyError
+		if !errors.As(err, &policyError) || policyError.Message != test.want {
+			t.Errorf("CheckPasswordPolicy(%q, %q) = %v, want %q", test.password, test.email, err, test.want)
+		}
+	}
+}
+
+func TestCommonPasswordsSkipCommentsAndCase(t *testing.T) {
+	passwords := parseCommonPasswords("# A comment\n\n  Password1  \nletmein!\n")
+
+	if len(passwords) != 2 {
+		t.Errorf("parsed %d passwords, want 2: %v", len(passwords), passwords)
+	}
+	for _, password := range []string{"password1", "letmein!"} {
+		if _, ok := passwords[password]; !ok {
+			t.Errorf("%q is missing from %v", password, passwords)
+		}
+	}
+	if len(commonPasswords) == 0 {
+		t.Error("the bundled common password list is empty")
+	}
+}
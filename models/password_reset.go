@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/utils"
+)
+
+// PasswordResetTTL is how long a password reset token can be used after it is issued
+const PasswordResetTTL = time.Hour
+
+// ErrResetTokenInvalid is returned for unknown, expired or already used password reset tokens
+var ErrResetTokenInvalid = errors.New("password reset token invalid")
+
+// Create issues a single-use password reset token for the account with the given email
+// and returns it with the email stored for that account
+//
+// The email is matched as given and then normalised (see emailCandidates), so
+// the reset link must be sent to the returned address rather than to email.
+// Only the SHA-256 hash of the token is stored. Returns ErrUserNotFound if no
+// account has that email; callers must not reveal this to the client.
+func (r *SQLPasswordResetRepository) Create(email string) (string, string, error) {
+	var userId int64
+	address, err := queryUserByEmail(context.Background(), r.DB, "SELECT id FROM users WHERE email = ?", email, &userId)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	query := `
+	INSERT INTO password_resets(user_id, token_hash, expires_at, created_at)
+	VALUES (?, ?, ?, ?)`
+	stmt, err := r.DB.Prepare(query)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	_, err = stmt.Exec(userId, utils.HashToken(token), now.Add(PasswordResetTTL), now)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, address, nil
+}
+
+// Reset sets a new password using a password reset token
+//
+// In the same transaction the token and every other outstanding reset token
+// of the user are used up, all refresh tokens and the calendar feed token are
+// revoked, and the token version is incremented so that access tokens issued
+// before the reset are rejected. A *PasswordPolicyError is returned, and the
+// token left unused, if the new password does not meet the password policy.
+func (r *SQLPasswordResetRepository) Reset(ctx context.Context, token string, password string) error {
+	// The token is checked, and the policy applied with the user's email,
+	// before the new password is hashed, so an invalid token costs no hash.
+	// Hashing happens before the transaction so the slow hash does not hold
+	// it open; the token is checked again inside it.
+	var email string
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+
+	err := r.DB.QueryRow(`
+	SELECT users.email, password_resets.expires_at, password_resets.used_at FROM password_resets
+	JOIN users ON users.id = password_resets.user_id
+	WHERE password_resets.token_hash = ?`, utils.HashToken(token)).Scan(&email, &expiresAt, &usedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrResetTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if usedAt.Valid || time.Now().After(expiresAt) {
+		return ErrResetTokenInvalid
+	}
+
+	err = CheckPasswordPolicy(password, email)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	// Using the token up is the check, so two concurrent resets cannot both pass it
+	var userId int64
+	err = tx.QueryRow("UPDATE password_resets SET used_at = ? WHERE token_hash = ? AND used_at IS NULL AND expires_at > ? RETURNING user_id",
+		now, utils.HashToken(token), now).Scan(&userId)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrResetTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE users SET password = ?, token_version = token_version + 1 WHERE id = ?", hashedPassword, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL", now, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE refresh_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", now, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM calendar_tokens WHERE user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,420 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Registration statuses
+const (
+	RegistrationRegistered = "registered"
+	RegistrationWaitlisted = "waitlisted"
+)
+
+// ErrAlreadyRegistered is returned when a user registers for an event twice
+var ErrAlreadyRegistered = errors.New("already registered")
+
+// ErrNotRegistered is returned when a user has no registration for the event
+var ErrNotRegistered = errors.New("not registered")
+
+// Registration is a user's place at an event
+//
+// Position is 1-based within the status: the seat order for registered users
+// and the place in the queue for waitlisted users. Occurrence is the original
+// start of the occurrence for recurring events, which each have their own
+// seats and waitlist, and nil for single events.
+type Registration struct {
+	EventID    int64
+	Occurrence *time.Time
+	UserID     int64
+	Status     string
+	Position   int
+	CreatedAt  time.Time
+}
+
+// Register registers a user for the event, or adds them to the waitlist if it is full
+//
+// Recurring events need the original start of the occurrence to register for,
+// and single events need a nil occurrence. The seat count is checked and the
+// registration inserted in one transaction that holds the event's row, so two
+// concurrent registrations cannot both take the last seat. The capacity is
+// read under the same lock, so a concurrent change to it is not missed.
+func (r *SQLRegistrationRepository) Register(ctx context.Context, e Event, userId int64, occurrence *time.Time) (_ *Registration, err error) {
+	ctx, span := startSpan(ctx, "RegistrationRepository.Register", attribute.Int64("event.id", e.ID), attribute.Int64("user.id", userId))
+	defer func() { endSpan(span, err) }()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	capacity, err := lockEvent(tx, e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	key := ""
+	if e.IsRecurring() {
+		if occurrence == nil {
+			return nil, ErrOccurrenceRequired
+		}
+		_, err = e.getOccurrence(tx, *occurrence)
+		if err != nil {
+			return nil, err
+		}
+		key = occurrenceKey(*occurrence)
+	} else if occurrence != nil {
+		return nil, ErrNoSuchOccurrence
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM registrations WHERE event_id = ? AND occurrence = ? AND user_id = ?)", e.ID, key, userId).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrAlreadyRegistered
+	}
+
+	status := RegistrationRegistered
+	if capacity.Valid {
+		registered, err := countRegistered(tx, e.ID, key)
+		if err != nil {
+			return nil, err
+		}
+		if registered >= capacity.Int64 {
+			status = RegistrationWaitlisted
+		}
+	}
+
+	query := "INSERT INTO registrations(event_id, occurrence, user_id, status, created_at) VALUES (?, ?, ?, ?, ?)"
+	stmt, err := tx.PrepareContext(ctx, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, e.ID, key, userId, status, time.Now().UTC())
+
+	if err != nil {
+		return nil, err
+	}
+
+	registration, err := getRegistration(tx, e.ID, key, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("registration.status", registration.Status))
+	observer.UserRegistered(registration.Status)
+	return registration, nil
+}
+
+// Cancel removes a user's registration or waitlist entry for the
+// event, or for one occurrence of a recurring event
+//
+// If the user held a seat, the first waitlisted user is promoted into it in
+// the same transaction, so a freed seat is never lost to a concurrent request.
+// The event is locked before the registration is deleted, in the same order
+// as Register, so the two cannot deadlock.
+func (r *SQLRegistrationRepository) Cancel(e Event, userId int64, occurrence *time.Time) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockEvent(tx, e.ID)
+	if err != nil {
+		return err
+	}
+
+	query := "DELETE FROM registrations WHERE event_id = ? AND occurrence = ? AND user_id = ?"
+	stmt, err := tx.Prepare(query)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	result, err := stmt.Exec(e.ID, registrationKey(occurrence), userId)
+
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotRegistered
+	}
+
+	err = promoteWaitlisted(tx, e.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get returns the user's registration for the event, or one
+// occurrence of a recurring event, with its current position
+func (r *SQLRegistrationRepository) Get(e Event, userId int64, occurrence *time.Time) (*Registration, error) {
+	return getRegistration(r.DB, e.ID, registrationKey(occurrence), userId)
+}
+
+// registrationKey is the occurrence column of a registration; empty for a single event
+func registrationKey(occurrence *time.Time) string {
+	if occurrence == nil {
+		return ""
+	}
+	return occurrenceKey(*occurrence)
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func getRegistration(q queryRower, eventId int64, key string, userId int64) (*Registration, error) {
+	query := `
+	SELECT r.event_id, r.occurrence, r.user_id, r.status, r.created_at,
+		(SELECT COUNT(*) FROM registrations o
+		 WHERE o.event_id = r.event_id AND o.occurrence = r.occurrence AND o.status = r.status AND o.id <= r.id)
+	FROM registrations r
+	WHERE r.event_id = ? AND r.occurrence = ? AND r.user_id = ?`
+
+	var registration Registration
+	var occurrence string
+	err := q.QueryRow(query, eventId, key, userId).Scan(&registration.EventID, &occurrence, &registration.UserID,
+		&registration.Status, &registration.CreatedAt, &registration.Position)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotRegistered
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	registration.Occurrence, err = parseOccurrenceKey(occurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	return &registration, nil
+}
+
+func countRegistered(tx *sql.Tx, eventId int64, key string) (int64, error) {
+	var count int64
+	err := tx.QueryRow("SELECT COUNT(*) FROM registrations WHERE event_id = ? AND occurrence = ? AND status = ?",
+		eventId, key, RegistrationRegistered).Scan(&count)
+	return count, err
+}
+
+// lockEvent holds the event's row until the transaction ends and returns its capacity
+//
+// Transactions that count an event's registrations and then change them take
+// this lock first, so they run one at a time per event. The no-op update is
+// how both backends take it: PostgreSQL locks the rows an UPDATE matches, and
+// SQLite transactions hold the whole database anyway.
+func lockEvent(tx *sql.Tx, eventId int64) (sql.NullInt64, error) {
+	var capacity sql.NullInt64
+	err := tx.QueryRow("UPDATE events SET capacity = capacity WHERE id = ? RETURNING capacity", eventId).Scan(&capacity)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return capacity, ErrEventNotFound
+	}
+	return capacity, err
+}
+
+// promoteWaitlisted moves waitlisted users into free seats, first come first
+// served. Each occurrence of a recurring event has its own seats and waitlist.
+//
+// The seats are counted under the event's lock, see lockEvent.
+func promoteWaitlisted(tx *sql.Tx, eventId int64) error {
+	capacity, err := lockEvent(tx, eventId)
+	if err != nil {
+		return err
+	}
+
+	keys, err := waitlistedOccurrences(tx, eventId)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		// Without a capacity every waitlisted user gets a seat
+		if !capacity.Valid {
+			_, err = tx.Exec("UPDATE registrations SET status = ? WHERE event_id = ? AND occurrence = ? AND status = ?",
+				RegistrationRegistered, eventId, key, RegistrationWaitlisted)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		registered, err := countRegistered(tx, eventId, key)
+		if err != nil {
+			return err
+		}
+		seats := capacity.Int64 - registered
+		if seats <= 0 {
+			continue
+		}
+
+		query := `
+		UPDATE registrations SET status = ?
+		WHERE id IN (
+			SELECT id FROM registrations
+			WHERE event_id = ? AND occurrence = ? AND status = ?
+			ORDER BY id
+			LIMIT ?
+		)`
+		_, err = tx.Exec(query, RegistrationRegistered, eventId, key, RegistrationWaitlisted, seats)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitlistedOccurrences returns the occurrences of an event that have a waitlist
+func waitlistedOccurrences(tx *sql.Tx, eventId int64) ([]string, error) {
+	rows, err := tx.Query("SELECT DISTINCT occurrence FROM registrations WHERE event_id = ? AND status = ?", eventId, RegistrationWaitlisted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+
+	for rows.Next() {
+		var key string
+		err := rows.Scan(&key)
+
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Attendee is a registration for an event along with the user's email
+//
+// ID is the registration ID, which orders attendees by sign-up time.
+type Attendee struct {
+	ID         int64
+	Occurrence *time.Time
+	UserID     int64
+	Email      string
+	Status     string
+	CreatedAt  time.Time
+}
+
+// attendeeSorts are the sort keys accepted by ListAttendees
+var attendeeSorts = map[string]SortField[Attendee]{
+	"createdAt": {Column: "registrations.created_at", Type: ColumnTime, Value: func(a Attendee) any { return a.CreatedAt }},
+	"email":     {Column: "users.email", Type: ColumnText, Value: func(a Attendee) any { return a.Email }},
+}
+
+// ListAttendees returns a page of the users registered or waitlisted for the event
+//
+// An empty status lists both registered and waitlisted users. For recurring
+// events a nil occurrence lists the attendees of every occurrence.
+func (r *SQLRegistrationRepository) ListAttendees(e Event, occurrence *time.Time, status string, request PageRequest) (*Page[Attendee], error) {
+	query := ListQuery[Attendee]{
+		From:        "registrations JOIN users ON users.id = registrations.user_id",
+		Columns:     "registrations.id, registrations.occurrence, registrations.user_id, users.email, registrations.status, registrations.created_at",
+		IDColumn:    "registrations.id",
+		ID:          func(a Attendee) int64 { return a.ID },
+		Sorts:       attendeeSorts,
+		DefaultSort: "createdAt",
+		Scan: func(row scanner) (Attendee, error) {
+			var attendee Attendee
+			var occurrence string
+			err := row.Scan(&attendee.ID, &occurrence, &attendee.UserID, &attendee.Email, &attendee.Status, &attendee.CreatedAt)
+			if err != nil {
+				return attendee, err
+			}
+
+			attendee.Occurrence, err = parseOccurrenceKey(occurrence)
+			return attendee, err
+		},
+	}
+
+	query.Filter("registrations.event_id = ?", e.ID)
+	if occurrence != nil {
+		query.Filter("registrations.occurrence = ?", occurrenceKey(*occurrence))
+	}
+	if status != "" {
+		query.Filter("registrations.status = ?", status)
+	}
+
+	return query.Run(context.Background(), r.DB, request)
+}
+
+// UserRegistration is one of a user's registrations along with the event
+type UserRegistration struct {
+	ID         int64
+	Event      Event
+	Occurrence *time.Time
+	Status     string
+	Position   int
+	CreatedAt  time.Time
+}
+
+// userRegistrationSorts are the sort keys accepted by RegistrationRepository.ListForUser
+var userRegistrationSorts = map[string]SortField[UserRegistration]{
+	"createdAt": {Column: "registrations.created_at", Type: ColumnTime, Value: func(r UserRegistration) any { return r.CreatedAt }},
+	"dateTime":  {Column: "events.dateTime", Type: ColumnTime, Value: func(r UserRegistration) any { return r.Event.DateTime }},
+}
+
+// ListForUser returns a page of the events a user is registered or waitlisted for
+func (r *SQLRegistrationRepository) ListForUser(userId int64, request PageRequest) (*Page[UserRegistration], error) {
+	query := ListQuery[UserRegistration]{
+		From: "registrations JOIN events ON events.id = registrations.event_id",
+		Columns: eventColumns + `, registrations.id, registrations.occurrence, registrations.status, registrations.created_at,
+			(SELECT COUNT(*) FROM registrations o
+			 WHERE o.event_id = registrations.event_id AND o.occurrence = registrations.occurrence
+			 AND o.status = registrations.status AND o.id <= registrations.id)`,
+		IDColumn:    "registrations.id",
+		ID:          func(r UserRegistration) int64 { return r.ID },
+		Sorts:       userRegistrationSorts,
+		DefaultSort: "createdAt",
+		Scan: func(row scanner) (UserRegistration, error) {
+			var registration UserRegistration
+			var occurrence string
+			event, err := scanEventWith(row, &registration.ID, &occurrence, &registration.Status, &registration.CreatedAt, &registration.Position)
+			if err != nil {
+				return registration, err
+			}
+
+			registration.Event = event
+			registration.Occurrence, err = parseOccurrenceKey(occurrence)
+			return registration, err
+		},
+	}
+
+	query.Filter("registrations.user_id = ?", userId)
+
+	return query.Run(context.Background(), r.DB, request)
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// EventRepository stores events and the per-occurrence changes of recurring events
+type EventRepository interface {
+	// Save inserts a new event and sets its ID, sequence and update time
+	Save(ctx context.Context, e *Event) error
+	// GetByID returns the event with the ID, or ErrEventNotFound
+	GetByID(ctx context.Context, id int64) (*Event, error)
+	// Update saves the event's fields and promotes waitlisted users into new seats
+	Update(ctx context.Context, event Event) error
+	// Delete removes the event, its per-occurrence changes and its registrations
+	Delete(ctx context.Context, event Event) error
+
+	// List returns one page of events matching the filter
+	List(ctx context.Context, filter EventFilter, request PageRequest) (*Page[Event], error)
+	// ListOccurrences returns one page of the occurrences within the filter's date range
+	ListOccurrences(ctx context.Context, filter EventFilter, request PageRequest) (*Page[Occurrence], error)
+	// Search runs a full-text search, returning up to limit results and the total number of matches
+	Search(query string, limit int) ([]SearchResult, int, error)
+
+	// GetOccurrence returns the occurrence of a recurring event that originally starts at start
+	GetOccurrence(e Event, start time.Time) (*Occurrence, error)
+	// UpdateOccurrence changes one occurrence of a recurring event
+	UpdateOccurrence(e Event, start time.Time, changes OccurrenceChanges) (*Occurrence, error)
+	// CancelOccurrence cancels one occurrence of a recurring event and removes its registrations
+	CancelOccurrence(e Event, start time.Time) error
+	// SeriesExceptions returns the changed occurrences and cancelled times of a recurring event
+	SeriesExceptions(e Event) ([]Occurrence, []time.Time, error)
+}
+
+// UserRepository stores accounts and their roles
+type UserRepository interface {
+	// Save inserts a new, unverified user with the default role
+	Save(ctx context.Context, u *User) error
+	// GetByID returns the user with the ID, without the password hash, or ErrUserNotFound
+	GetByID(id int64) (*User, error)
+	// ValidateCredentials checks the user's email and password and loads the
+	// account's ID, token version and MFA status
+	ValidateCredentials(ctx context.Context, u *User) error
+
+	// LoadRoles loads the user's role names and the union of their permissions
+	LoadRoles(u *User) error
+	// SetRoles replaces every role of a user, or returns ErrUnknownRole
+	SetRoles(userId int64, roles []string) error
+
+	// GetTokenVersion returns the user's current token version
+	GetTokenVersion(userId int64) (int64, error)
+	// IsEmailVerified reports whether the user has verified their email address
+	IsEmailVerified(userId int64) (bool, error)
+	// VerifyEmail marks the user's address as verified if it is still email,
+	// or returns ErrEmailVerificationInvalid
+	VerifyEmail(userId int64, email string) error
+	// ReserveVerificationEmail records that a verification email is about to
+	// be sent, or returns how long to wait when too many were sent already
+	ReserveVerificationEmail(userId int64) (time.Duration, error)
+}
+
+// RegistrationRepository stores users' places at events
+type RegistrationRepository interface {
+	// Register registers a user for the event, or adds them to the waitlist if it is full
+	Register(ctx context.Context, e Event, userId int64, occurrence *time.Time) (*Registration, error)
+	// Cancel removes a user's registration and promotes the first waitlisted user into a freed seat
+	Cancel(e Event, userId int64, occurrence *time.Time) error
+	// Get returns the user's registration for the event, or ErrNotRegistered
+	Get(e Event, userId int64, occurrence *time.Time) (*Registration, error)
+
+	// ListAttendees returns a page of the users registered or waitlisted for the event
+	ListAttendees(e Event, occurrence *time.Time, status string, request PageRequest) (*Page[Attendee], error)
+	// ListForUser returns a page of the events a user is registered or waitlisted for
+	ListForUser(userId int64, request PageRequest) (*Page[UserRegistration], error)
+	// ListRegisteredOccurrences returns every event and occurrence the user holds a seat for
+	ListRegisteredOccurrences(userId int64) ([]Occurrence, error)
+}
+
+// TokenRepository stores refresh token families and calendar feed tokens
+type TokenRepository interface {
+	// IssueRefreshToken starts a new refresh token family for the user and returns the raw token
+	IssueRefreshToken(userId int64) (string, error)
+	// RotateRefreshToken exchanges a refresh token for a new one in the same
+	// family and returns it with the ID of the user it belongs to
+	RotateRefreshToken(token string) (string, int64, error)
+	// RevokeRefreshToken revokes the family the refresh token belongs to
+	RevokeRefreshToken(token string) error
+
+	// IssueCalendarToken creates the user's calendar feed token, revoking the previous one
+	IssueCalendarToken(userId int64) (string, error)
+	// RevokeCalendarToken revokes the user's calendar feed token, if they have one
+	RevokeCalendarToken(userId int64) error
+	// UserIDForCalendarToken returns the ID of the user a calendar feed token belongs to
+	UserIDForCalendarToken(token string) (int64, error)
+}
+
+// MFARepository stores users' TOTP secrets and recovery codes
+type MFARepository interface {
+	// GetStatus returns whether the user has two-factor authentication and how many recovery codes are left
+	GetStatus(userId int64) (*MFAStatus, error)
+	// BeginTOTPEnrolment generates a new TOTP secret for the user and returns it
+	BeginTOTPEnrolment(userId int64) (string, error)
+	// ConfirmTOTPEnrolment enables two-factor authentication and returns fresh recovery codes
+	ConfirmTOTPEnrolment(userId int64, code string) ([]string, error)
+	// VerifySecondFactor checks and uses up a TOTP code or recovery code
+	VerifySecondFactor(userId int64, code string) error
+	// DisableTOTP turns two-factor authentication off; a current code is required
+	DisableTOTP(userId int64, code string) error
+	// RegenerateRecoveryCodes replaces the recovery codes; a current code is required
+	RegenerateRecoveryCodes(userId int64, code string) ([]string, error)
+}
+
+// PasswordResetRepository stores password reset tokens
+type PasswordResetRepository interface {
+	// Create issues a password reset token for the account with the email and returns it
+	// with the account's stored email, which the token must be sent to, or returns ErrUserNotFound
+	Create(email string) (string, string, error)
+	// Reset sets a new password with a reset token and signs the user out everywhere
+	Reset(ctx context.Context, token string, password string) error
+}
+
+// LoginRepository keeps the login audit log and the login throttles
+type LoginRepository interface {
+	// ReserveAttempt counts a login attempt as a failure unless logins for the
+	// email from the IP address are locked
+	ReserveAttempt(email string, ip string) (*LoginReservation, error)
+	// RecordAttempt adds an attempt to the audit log and settles its reservation
+	RecordAttempt(reservation *LoginReservation, attempt LoginAttempt) error
+}
+
+// Repositories groups the repositories the HTTP handlers depend on
+type Repositories struct {
+	Events         EventRepository
+	Users          UserRepository
+	Registrations  RegistrationRepository
+	Tokens         TokenRepository
+	MFA            MFARepository
+	PasswordResets PasswordResetRepository
+	Logins         LoginRepository
+}
+
+// SQLEventRepository is the EventRepository backed by a SQLite or PostgreSQL database
+type SQLEventRepository struct {
+	DB *sql.DB
+	// SearchIndex is the full-text index Search queries; without one it returns ErrSearchUnavailable
+	SearchIndex SearchIndex
+}
+
+// SQLUserRepository is the UserRepository backed by a SQLite or PostgreSQL database
+type SQLUserRepository struct {
+	DB *sql.DB
+}
+
+// SQLRegistrationRepository is the RegistrationRepository backed by a SQLite or PostgreSQL database
+type SQLRegistrationRepository struct {
+	DB *sql.DB
+}
+
+// SQLTokenRepository is the TokenRepository backed by a SQLite or PostgreSQL database
+type SQLTokenRepository struct {
+	DB *sql.DB
+}
+
+// SQLMFARepository is the MFARepository backed by a SQLite or PostgreSQL database
+type SQLMFARepository struct {
+	DB *sql.DB
+}
+
+// SQLPasswordResetRepository is the PasswordResetRepository backed by a SQLite or PostgreSQL database
+type SQLPasswordResetRepository struct {
+	DB *sql.DB
+}
+
+// SQLLoginRepository is the LoginRepository backed by a SQLite or PostgreSQL database
+type SQLLoginRepository struct {
+	DB *sql.DB
+}
+
+// NewSQLRepositories returns repositories backed by the database, which
+// must have been opened by db.Open and migrated
+//
+// The queries are written once for both backends: they use ? placeholders,
+// which db.Open rewrites for PostgreSQL, and avoid SQLite-only syntax.
+// PostgreSQL runs transactions concurrently, so a transaction that reads rows
+// and then writes based on them first locks what it read, either with a no-op
+// UPDATE (see lockEvent and lockUser) or by folding the check into the write,
+// as an UPDATE or upsert whose WHERE clause fails when a concurrent request
+// got there first. SQLite runs them one at a time, see db.DSN.
+//
+// Search is the full-text index the database has, which differs between the
+// backends; an empty SearchIndex turns search off.
+func NewSQLRepositories(database *sql.DB, search SearchIndex) Repositories {
+	return Repositories{
+		Events:         &SQLEventRepository{DB: database, SearchIndex: search},
+		Users:          &SQLUserRepository{DB: database},
+		Registrations:  &SQLRegistrationRepository{DB: database},
+		Tokens:         &SQLTokenRepository{DB: database},
+		MFA:            &SQLMFARepository{DB: database},
+		PasswordResets: &SQLPasswordResetRepository{DB: database},
+		Logins:         &SQLLoginRepository{DB: database},
+	}
+}
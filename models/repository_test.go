@@ -0,0 +1,443 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/db/dbtest"
+	"github.com/PaulFWatts/rest_api_golang/utils"
+)
+
+// testPassword meets the password policy
+const testPassword = "Tr0ub4dor&3x"
+
+func TestMain(m *testing.M) {
+	// The lowest bcrypt cost keeps the many signups in the tests fast
+	cfg := config.Default()
+	cfg.Password.BcryptCost = 4
+	utils.InitPasswordHasher(cfg.Password)
+
+	os.Exit(m.Run())
+}
+
+// forEachBackend runs the test as a subtest against the memory repositories
+// and the SQL repositories of each database in dbtest.Drivers
+func forEachBackend(t *testing.T, test func(t *testing.T, repositories Repositories)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryRepositories())
+	})
+
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			test(t, NewSQLRepositories(dbtest.Open(t, driver), SearchIndex(dbtest.SearchIndex(driver))))
+		})
+	}
+}
+
+// parallel runs f n times at once and returns the errors it returned
+func parallel(n int, f func(i int) error) []error {
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f(i)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// countErrors returns how many of errs are nil and how many match target
+func countErrors(t *testing.T, errs []error, target error) (int, int) {
+	t.Helper()
+
+	succeeded, matched := 0, 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, target):
+			matched++
+		default:
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	return succeeded, matched
+}
+
+// createUser saves an account with testPassword and returns its ID
+func createUser(t *testing.T, repositories Repositories, email string) int64 {
+	t.Helper()
+
+	user := &User{Email: email, Password: testPassword}
+	err := repositories.Users.Save(context.Background(), user)
+	if err != nil {
+		t.Fatalf("Save(%s): %v", email, err)
+	}
+	return user.ID
+}
+
+// createEvent saves an event a week from now owned by the user
+func createEvent(t *testing.T, repositories Repositories, ownerId int64, capacity *int64) Event {
+	t.Helper()
+
+	event := Event{
+		Name:        "Meetup",
+		Description: "A test event",
+		Location:    "Hall",
+		DateTime:    time.Now().Add(7 * 24 * time.Hour).Truncate(time.Second),
+		UserID:      ownerId,
+		Capacity:    capacity,
+	}
+	err := repositories.Events.Save(context.Background(), &event)
+	if err != nil {
+		t.Fatalf("Save event: %v", err)
+	}
+	return event
+}
+
+func TestParallelRegistrationsFillOnlyTheSeats(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		owner := createUser(t, repositories, "owner@example.com")
+		capacity := int64(3)
+		event := createEvent(t, repositories, owner, &capacity)
+
+		users := make([]int64, 12)
+		for i := range users {
+			users[i] = createUser(t, repositories, fmt.Sprintf("user%d@example.com", i))
+		}
+
+		statuses := make([]string, len(users))
+		errs := parallel(len(users), func(i int) error {
+			registration, err := repositories.Registrations.Register(context.Background(), event, users[i], nil)
+			if err == nil {
+				statuses[i] = registration.Status
+			}
+			return err
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+		}
+
+		registered := 0
+		for _, status := range statuses {
+			if status == RegistrationRegistered {
+				registered++
+			}
+		}
+		if registered != 3 {
+			t.Errorf("%d of %d parallel registrations got a seat, want 3", registered, len(users))
+		}
+
+		page, err := repositories.Registrations.ListAttendees(event, nil, RegistrationWaitlisted, PageRequest{Limit: 100})
+		if err != nil {
+			t.Fatalf("ListAttendees: %v", err)
+		}
+		if len(page.Items) != len(users)-3 {
+			t.Errorf("%d waitlisted, want %d", len(page.Items), len(users)-3)
+		}
+	})
+}
+
+func TestRegisterTwice(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		user := createUser(t, repositories, "user@example.com")
+		event := createEvent(t, repositories, user, nil)
+
+		_, err := repositories.Registrations.Register(context.Background(), event, user, nil)
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+		_, err = repositories.Registrations.Register(context.Background(), event, user, nil)
+		if !errors.Is(err, ErrAlreadyRegistered) {
+			t.Errorf("second Register = %v, want ErrAlreadyRegistered", err)
+		}
+
+		_, err = repositories.Registrations.Register(context.Background(), Event{ID: event.ID + 1}, user, nil)
+		if !errors.Is(err, ErrEventNotFound) {
+			t.Errorf("Register for a missing event = %v, want ErrEventNotFound", err)
+		}
+	})
+}
+
+func TestCancelPromotesTheWaitlist(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		owner := createUser(t, repositories, "owner@example.com")
+		capacity := int64(1)
+		event := createEvent(t, repositories, owner, &capacity)
+
+		first := createUser(t, repositories, "first@example.com")
+		second := createUser(t, repositories, "second@example.com")
+		for _, user := range []int64{first, second} {
+			_, err := repositories.Registrations.Register(context.Background(), event, user, nil)
+			if err != nil {
+				t.Fatalf("Register(%d): %v", user, err)
+			}
+		}
+
+		err := repositories.Registrations.Cancel(event, first, nil)
+		if err != nil {
+			t.Fatalf("Cancel: %v", err)
+		}
+		err = repositories.Registrations.Cancel(event, first, nil)
+		if !errors.Is(err, ErrNotRegistered) {
+			t.Errorf("second Cancel = %v, want ErrNotRegistered", err)
+		}
+
+		registration, err := repositories.Registrations.Get(event, second, nil)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if registration.Status != RegistrationRegistered || registration.Position != 1 {
+			t.Errorf("waitlisted user after a cancellation = %+v, want registered at position 1", registration)
+		}
+	})
+}
+
+func TestParallelRefreshTokenRotations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		user := createUser(t, repositories, "user@example.com")
+		token, err := repositories.Tokens.IssueRefreshToken(user)
+		if err != nil {
+			t.Fatalf("IssueRefreshToken: %v", err)
+		}
+
+		rotated := make([]string, 8)
+		errs := parallel(len(rotated), func(i int) error {
+			newToken, userId, err := repositories.Tokens.RotateRefreshToken(token)
+			if err == nil && userId != user {
+				return fmt.Errorf("rotated token belongs to user %d, want %d", userId, user)
+			}
+			rotated[i] = newToken
+			return err
+		})
+
+		// Only one rotation wins. The first loser looks like reuse and revokes
+		// the family, including the token the winner got, so the rest may find
+		// it revoked already.
+		succeeded, reused, revoked := 0, 0, 0
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.Is(err, ErrRefreshTokenReused):
+				reused++
+			case errors.Is(err, ErrRefreshTokenInvalid):
+				revoked++
+			default:
+				t.Errorf("RotateRefreshToken: %v", err)
+			}
+		}
+		if succeeded != 1 || reused == 0 || succeeded+reused+revoked != len(rotated) {
+			t.Fatalf("%d rotations succeeded, %d were reuse and %d found the family revoked; want 1 success and the rest refused",
+				succeeded, reused, revoked)
+		}
+		for _, newToken := range rotated {
+			if newToken == "" {
+				continue
+			}
+			_, _, err = repositories.Tokens.RotateRefreshToken(newToken)
+			if !errors.Is(err, ErrRefreshTokenInvalid) {
+				t.Errorf("rotating the winner's token after reuse = %v, want ErrRefreshTokenInvalid", err)
+			}
+		}
+	})
+}
+
+func TestParallelPasswordResets(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		createUser(t, repositories, "user@example.com")
+		token, _, err := repositories.PasswordResets.Create("user@example.com")
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+
+		errs := parallel(6, func(i int) error {
+			return repositories.PasswordResets.Reset(context.Background(), token, fmt.Sprintf("N3w&%d%s", i, testPassword))
+		})
+
+		succeeded, rejected := countErrors(t, errs, ErrResetTokenInvalid)
+		if succeeded != 1 || rejected != 5 {
+			t.Errorf("%d resets succeeded and %d were rejected, want 1 and 5", succeeded, rejected)
+		}
+	})
+}
+
+func TestParallelVerificationEmailReservations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		user := createUser(t, repositories, "user@example.com")
+
+		waits := make([]time.Duration, 6)
+		errs := parallel(len(waits), func(i int) error {
+			var err error
+			waits[i], err = repositories.Users.ReserveVerificationEmail(user)
+			return err
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("ReserveVerificationEmail: %v", err)
+			}
+		}
+
+		reserved := 0
+		for _, wait := range waits {
+			if wait == 0 {
+				reserved++
+			}
+		}
+		if reserved != 1 {
+			t.Errorf("%d of %d parallel requests could send an email, want 1", reserved, len(waits))
+		}
+
+		_, err := repositories.Users.ReserveVerificationEmail(user + 1)
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("ReserveVerificationEmail for a missing user = %v, want ErrUserNotFound", err)
+		}
+	})
+}
+
+func TestParallelLoginReservations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		reservations := make([]*LoginReservation, 20)
+		errs := parallel(len(reservations), func(i int) error {
+			var err error
+			reservations[i], err = repositories.Logins.ReserveAttempt("user@example.com", "192.0.2.1")
+			return err
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatalf("ReserveAttempt: %v", err)
+			}
+		}
+
+		// 5 free failures plus the one that set the lock
+		reserved := 0
+		for _, reservation := range reservations {
+			if reservation.Wait == 0 {
+				reserved++
+			}
+		}
+		if reserved != 6 {
+			t.Errorf("%d of %d parallel attempts were let through, want 6", reserved, len(reservations))
+		}
+	})
+}
+
+func TestLegacyEmailsCanLogInAndReset(t *testing.T) {
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			database := dbtest.Open(t, driver)
+			repositories := NewSQLRepositories(database, SearchIndex(dbtest.SearchIndex(driver)))
+
+			// Migration 0008 leaves an email as it was if its normalised form
+			// is taken, or if it is not a valid address
+			normalized := createUser(t, repositories, "ada@example.com")
+			colliding := createUser(t, repositories, "colliding@example.com")
+			invalid := createUser(t, repositories, "invalid@example.com")
+			for id, email := range map[int64]string{colliding: "Ada@Example.com", invalid: "ada at example"} {
+				_, err := database.Exec("UPDATE users SET email = ? WHERE id = ?", email, id)
+				if err != nil {
+					t.Fatalf("storing legacy email %q: %v", email, err)
+				}
+			}
+
+			for email, want := range map[string]int64{"Ada@Example.com": colliding, "ada at example": invalid, "ADA@example.com": normalized} {
+				user := User{Email: email, Password: testPassword}
+				err := repositories.Users.ValidateCredentials(context.Background(), &user)
+				if err != nil {
+					t.Errorf("ValidateCredentials(%q): %v", email, err)
+					continue
+				}
+				if user.ID != want {
+					t.Errorf("ValidateCredentials(%q) logged in user %d, want %d", email, user.ID, want)
+				}
+
+			}
+
+			// Reset links go to the stored address, not to the one typed in
+			for email, want := range map[string]string{"Ada@Example.com": "Ada@Example.com", "ada at example": "ada at example", " ADA@example.com": "ada@example.com"} {
+				_, address, err := repositories.PasswordResets.Create(email)
+				if err != nil {
+					t.Errorf("PasswordResets.Create(%q): %v", email, err)
+					continue
+				}
+				if address != want {
+					t.Errorf("PasswordResets.Create(%q) returned address %q, want %q", email, address, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoginUpgradesPasswordHashes(t *testing.T) {
+	t.Cleanup(func() {
+		cfg := config.Default()
+		cfg.Password.BcryptCost = 4
+		utils.InitPasswordHasher(cfg.Password)
+	})
+
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			database := dbtest.Open(t, driver)
+			repositories := NewSQLRepositories(database, SearchIndex(dbtest.SearchIndex(driver)))
+
+			storedHash := func(id int64) string {
+				t.Helper()
+				var hash string
+				err := database.QueryRow("SELECT password FROM users WHERE id = ?", id).Scan(&hash)
+				if err != nil {
+					t.Fatalf("reading password hash: %v", err)
+				}
+				return hash
+			}
+			login := func(password string) error {
+				return repositories.Users.ValidateCredentials(context.Background(), &User{Email: "ada@example.com", Password: password})
+			}
+
+			cfg := config.Default().Password
+			cfg.BcryptCost = 4
+			utils.InitPasswordHasher(cfg)
+			id := createUser(t, repositories, "ada@example.com")
+			bcryptHash := storedHash(id)
+			if !strings.HasPrefix(bcryptHash, "$2a$04$") {
+				t.Fatalf("stored hash %q, want bcrypt with cost 4", bcryptHash)
+			}
+
+			cfg.Hash, cfg.Argon2Time, cfg.Argon2Memory, cfg.Argon2Threads = "argon2id", 1, 1024, 1
+			utils.InitPasswordHasher(cfg)
+
+			if err := login("wrong password"); err == nil {
+				t.Fatal("logged in with the wrong password")
+			}
+			if hash := storedHash(id); hash != bcryptHash {
+				t.Errorf("a failed login replaced the hash with %q", hash)
+			}
+
+			if err := login(testPassword); err != nil {
+				t.Fatalf("logging in with a bcrypt hash: %v", err)
+			}
+			argon2Hash := storedHash(id)
+			if !strings.HasPrefix(argon2Hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+				t.Fatalf("after login the stored hash is %q, want Argon2id with the configured parameters", argon2Hash)
+			}
+
+			if err := login(testPassword); err != nil {
+				t.Fatalf("logging in with the upgraded hash: %v", err)
+			}
+			if hash := storedHash(id); hash != argon2Hash {
+				t.Errorf("a login with a current hash replaced it with %q", hash)
+			}
+		})
+	}
+}
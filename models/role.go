@@ -0,0 +1,163 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/PaulFWatts/rest_api_golang/db"
+)
+
+// Built-in roles seeded by db.InitDB
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Permissions checked by routes and handlers. The ":own" variants only apply
+// to events the user created; the ":any" variants apply to every event.
+const (
+	PermissionEventsCreate    = "events:create"
+	PermissionEventsUpdateOwn = "events:update:own"
+	PermissionEventsUpdateAny = "events:update:any"
+	PermissionEventsDeleteOwn = "events:delete:own"
+	PermissionEventsDeleteAny = "events:delete:any"
+	PermissionEventsRegister  = "events:register"
+	PermissionUsersRoles      = "users:roles:manage"
+)
+
+// ErrUnknownRole is returned when assigning a role that does not exist
+var ErrUnknownRole = errors.New("unknown role")
+
+// LoadRoles loads the user's role names and the union of their permissions
+func (r *SQLUserRepository) LoadRoles(u *User) error {
+	roles, err := queryNames(r.DB, `
+	SELECT roles.name FROM roles
+	JOIN user_roles ON user_roles.role_id = roles.id
+	WHERE user_roles.user_id = ?
+	ORDER BY roles.name`, u.ID)
+
+	if err != nil {
+		return err
+	}
+
+	permissions, err := queryNames(r.DB, `
+	SELECT DISTINCT permissions.name FROM permissions
+	JOIN role_permissions ON role_permissions.permission_id = permissions.id
+	JOIN user_roles ON user_roles.role_id = role_permissions.role_id
+	WHERE user_roles.user_id = ?
+	ORDER BY permissions.name`, u.ID)
+
+	if err != nil {
+		return err
+	}
+
+	u.Roles = roles
+	u.Permissions = permissions
+	return nil
+}
+
+func queryNames(database *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// AssignRole grants a role to a user; granting a role the user already has is a no-op
+func AssignRole(userId int64, role string) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUserRole(tx, userId, role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetRoles replaces every role of a user with the given roles
+func (r *SQLUserRepository) SetRoles(userId int64, roles []string) error {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Held so that two concurrent replacements do not merge
+	err = lockUser(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM user_roles WHERE user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		err = insertUserRole(tx, userId, role)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertUserRole(tx *sql.Tx, userId int64, role string) error {
+	var roleId int64
+	err := tx.QueryRow("SELECT id FROM roles WHERE name = ?", role).Scan(&roleId)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUnknownRole
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO user_roles(user_id, role_id) VALUES (?, ?) ON CONFLICT DO NOTHING", userId, roleId)
+	return err
+}
+
+// InitAdmins grants the admin role to every existing account in emails, which
+// come from the ADMIN_EMAILS setting. This is how the first
+// administrator is created; further admins can then be appointed through the API.
+func InitAdmins(emails []string) {
+	for _, entry := range emails {
+		email, err := NormalizeEmail(entry)
+		if err != nil {
+			panic("Invalid email " + entry + " in ADMIN_EMAILS.")
+		}
+
+		var userId int64
+		err = db.DB.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&userId)
+		if err != nil {
+			continue // The account may not have signed up yet
+		}
+
+		err = AssignRole(userId, RoleAdmin)
+		if err != nil {
+			panic("Could not grant admin role to " + email + ".")
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// SearchIndex is a full-text index over events that SQLEventRepository.Search can query
+type SearchIndex string
+
+const (
+	// SearchFTS5 is SQLite's events_fts table, created at startup by db.InitDB
+	SearchFTS5 SearchIndex = "fts5"
+	// SearchPostgres is PostgreSQL's events.search column, added by migration 0013
+	SearchPostgres SearchIndex = "postgres"
+)
+
+// ErrSearchUnavailable is returned when the repository has no search index
+var ErrSearchUnavailable = errors.New("search unavailable")
+
+// ErrInvalidSearchQuery is returned for queries with no searchable terms or an unterminated phrase
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+// Markers placed around matches by the database; they are control characters so they
+// cannot appear in event text and survive HTML escaping unchanged
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// SearchResult is an event matching a search along with its relevance
+//
+// Rank is lower for more relevant results: the BM25 score on SQLite, and the
+// negated ts_rank on PostgreSQL. Snippet and HighlightedName
+// are HTML-escaped, with matched terms wrapped in <mark> tags.
+type SearchResult struct {
+	Event           Event
+	Rank            float64
+	Snippet         string
+	HighlightedName string
+}
+
+// Search runs a full-text search over event names, descriptions and locations
+//
+// The query is a list of terms that must all match. A term ending in "*" matches
+// any word with that prefix, and text in double quotes must match as a phrase:
+//
+//	go meetup          events containing both words
+//	meet*              events containing a word starting with "meet"
+//	"go meetup" berlin the phrase "go meetup" and the word "berlin"
+//
+// Matches in the name weigh more than matches in the description, which weigh
+// more than matches in the location. Returns the top results and the total number of matches.
+func (r *SQLEventRepository) Search(query string, limit int) ([]SearchResult, int, error) {
+	if r.SearchIndex != SearchFTS5 && r.SearchIndex != SearchPostgres {
+		return nil, 0, ErrSearchUnavailable
+	}
+
+	terms, err := parseSearchTerms(query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+
+	if r.SearchIndex == SearchPostgres {
+		return r.searchPostgres(terms, limit)
+	}
+	return r.searchFTS5(terms, limit)
+}
+
+// searchFTS5 runs a search on SQLite's events_fts index
+func (r *SQLEventRepository) searchFTS5(terms []searchTerm, limit int) ([]SearchResult, int, error) {
+	match := buildMatchQuery(terms)
+
+	sqlQuery := `
+	SELECT ` + eventColumns + `,
+		bm25(events_fts, 10.0, 5.0, 1.0) AS rank,
+		snippet(events_fts, 1, '` + matchStart + `', '` + matchEnd + `', '…', 16),
+		highlight(events_fts, 0, '` + matchStart + `', '` + matchEnd + `')
+	FROM events_fts
+	JOIN events ON events.id = events_fts.rowid
+	WHERE events_fts MATCH ?
+	ORDER BY rank, events.id
+	LIMIT ?`
+
+	rows, err := r.DB.Query(sqlQuery, match, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results, err := scanSearchResults(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = r.DB.QueryRow("SELECT COUNT(*) FROM events_fts WHERE events_fts MATCH ?", match).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// searchPostgres runs a search on PostgreSQL's events.search column
+//
+// The weights give name matches ten times and description matches five
+// times the weight of location matches, as the BM25 weights on SQLite do.
+func (r *SQLEventRepository) searchPostgres(terms []searchTerm, limit int) ([]SearchResult, int, error) {
+	tsquery := buildTSQuery(terms)
+	selection := `StartSel="` + matchStart + `", StopSel="` + matchEnd + `"`
+
+	sqlQuery := `
+	SELECT ` + eventColumns + `,
+		-ts_rank('{0, 0.1, 0.5, 1.0}', events.search, search_query) AS rank,
+		ts_headline('simple', events.description, search_query, ?),
+		ts_headline('simple', events.name, search_query, ?)
+	FROM events, to_tsquery('simple', ?) AS search_query
+	WHERE events.search @@ search_query
+	ORDER BY rank, events.id
+	LIMIT ?`
+
+	rows, err := r.DB.Query(sqlQuery, selection+", MaxWords=16, MinWords=8", selection+", HighlightAll=true", tsquery, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	results, err := scanSearchResults(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	err = r.DB.QueryRow("SELECT COUNT(*) FROM events WHERE events.search @@ to_tsquery('simple', ?)", tsquery).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// scanSearchResults reads and closes rows selected with eventColumns followed
+// by the rank, snippet and highlighted name
+func scanSearchResults(rows *sql.Rows) ([]SearchResult, error) {
+	defer rows.Close()
+
+	results := []SearchResult{}
+
+	for rows.Next() {
+		var result SearchResult
+		event, err := scanEventWith(rows, &result.Rank, &result.Snippet, &result.HighlightedName)
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Event = event
+		result.Snippet = markMatches(result.Snippet)
+		result.HighlightedName = markMatches(result.HighlightedName)
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// searchTerm is a word or quoted phrase of a search query
+type searchTerm struct {
+	text   string
+	prefix bool // Whether the term's last word matches as a prefix
+}
+
+// parseSearchTerms splits a search query into its terms, skipping those
+// without letters or digits
+func parseSearchTerms(query string) ([]searchTerm, error) {
+	var terms []searchTerm
+	rest := strings.TrimSpace(query)
+
+	for rest != "" {
+		var term string
+		prefix := false
+
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, ErrInvalidSearchQuery
+			}
+			term = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			end := strings.IndexFunc(rest, unicode.IsSpace)
+			if end < 0 {
+				end = len(rest)
+			}
+			term = rest[:end]
+			rest = rest[end:]
+
+			if strings.HasSuffix(term, "*") {
+				term = strings.TrimRight(term, "*")
+				prefix = true
+			}
+		}
+
+		rest = strings.TrimSpace(rest)
+
+		if len(searchWords(term)) == 0 {
+			continue
+		}
+
+		terms = append(terms, searchTerm{text: term, prefix: prefix})
+	}
+
+	if len(terms) == 0 {
+		return nil, ErrInvalidSearchQuery
+	}
+
+	return terms, nil
+}
+
+// searchWords splits text into its runs of letters and digits
+func searchWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// buildMatchQuery turns search terms into an FTS5 MATCH expression
+//
+// Every term and phrase is quoted, so FTS5 operators and column filters typed
+// by users are treated as plain text instead of causing syntax errors.
+func buildMatchQuery(terms []searchTerm) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term.text, `"`, `""`) + `"`
+		if term.prefix {
+			quoted[i] += "*"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// buildTSQuery turns search terms into a PostgreSQL tsquery
+//
+// Only the letters and digits of each term are kept, each word quoted as a
+// lexeme, so tsquery operators typed by users are never interpreted. The
+// words of a phrase must follow each other.
+func buildTSQuery(terms []searchTerm) string {
+	phrases := make([]string, len(terms))
+	for i, term := range terms {
+		words := searchWords(strings.ToLower(term.text))
+		for j, word := range words {
+			words[j] = "'" + word + "'"
+		}
+		if term.prefix {
+			words[len(words)-1] += ":*"
+		}
+		phrases[i] = strings.Join(words, " <-> ")
+	}
+	return strings.Join(phrases, " & ")
+}
+
+// markMatches HTML-escapes text and replaces the match markers with <mark> tags
+func markMatches(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, matchStart, "<mark>")
+	return strings.ReplaceAll(text, matchEnd, "</mark>")
+}
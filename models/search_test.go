@@ -0,0 +1,100 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchQueries(t *testing.T) {
+	tests := []struct {
+		query   string
+		match   string
+		tsquery string
+	}{
+		{"go meetup", `"go" "meetup"`, "'go' & 'meetup'"},
+		{"Meet*", `"Meet"*`, "'meet':*"},
+		{`"go meetup" berlin`, `"go meetup" "berlin"`, "'go' <-> 'meetup' & 'berlin'"},
+		{"it's a:b | !c", `"it's" "a:b" "!c"`, "'it' <-> 's' & 'a' <-> 'b' & 'c'"},
+	}
+
+	for _, test := range tests {
+		terms, err := parseSearchTerms(test.query)
+		if err != nil {
+			t.Fatalf("parseSearchTerms(%q): %v", test.query, err)
+		}
+		if match := buildMatchQuery(terms); match != test.match {
+			t.Errorf("buildMatchQuery(%q) = %s, want %s", test.query, match, test.match)
+		}
+		if tsquery := buildTSQuery(terms); tsquery != test.tsquery {
+			t.Errorf("buildTSQuery(%q) = %s, want %s", test.query, tsquery, test.tsquery)
+		}
+	}
+
+	for _, query := range []string{"", "* |", `"unterminated`} {
+		_, err := parseSearchTerms(query)
+		if !errors.Is(err, ErrInvalidSearchQuery) {
+			t.Errorf("parseSearchTerms(%q): err = %v, want %v", query, err, ErrInvalidSearchQuery)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, repositories Repositories) {
+		_, _, err := repositories.Events.Search("meetup", 10)
+		if errors.Is(err, ErrSearchUnavailable) {
+			t.Skip("no search index")
+		}
+
+		owner := createUser(t, repositories, "owner@example.com")
+		for _, event := range []Event{
+			{Name: "Go meetup", Description: "Talks about Go", Location: "Berlin"},
+			{Name: "Rust evening", Description: "A meetup for Rust users", Location: "Berlin"},
+			{Name: "Book club", Description: "Reading together", Location: "Meeting room"},
+		} {
+			event.DateTime = time.Now().Add(24 * time.Hour)
+			event.UserID = owner
+			err := repositories.Events.Save(context.Background(), &event)
+			if err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+		}
+
+		names := func(query string) []string {
+			t.Helper()
+			results, total, err := repositories.Events.Search(query, 10)
+			if err != nil {
+				t.Fatalf("Search(%q): %v", query, err)
+			}
+			if total != len(results) {
+				t.Errorf("Search(%q) total = %d with %d results", query, total, len(results))
+			}
+			var names []string
+			for _, result := range results {
+				names = append(names, result.Event.Name)
+			}
+			return names
+		}
+
+		// Name matches rank first
+		if got := strings.Join(names("meetup"), ", "); got != "Go meetup, Rust evening" {
+			t.Errorf("Search(meetup) = %s, want Go meetup, Rust evening", got)
+		}
+		if got := strings.Join(names("meet*"), ", "); got != "Go meetup, Rust evening, Book club" {
+			t.Errorf("Search(meet*) = %s, want all three", got)
+		}
+		if got := strings.Join(names(`"go meetup" berlin`), ", "); got != "Go meetup" {
+			t.Errorf(`Search("go meetup" berlin) = %s, want Go meetup`, got)
+		}
+
+		results, _, err := repositories.Events.Search("go", 10)
+		if err != nil {
+			t.Fatalf("Search(go): %v", err)
+		}
+		if len(results) != 1 || results[0].HighlightedName != "<mark>Go</mark> meetup" || !strings.Contains(results[0].Snippet, "<mark>Go</mark>") {
+			t.Errorf("Search(go) = %+v, want Go marked in the name and snippet", results)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/utils"
+)
+
+// ErrRefreshTokenInvalid is returned for unknown, expired or revoked refresh tokens
+var ErrRefreshTokenInvalid = errors.New("refresh token invalid")
+
+// ErrRefreshTokenReused is returned when an already-rotated refresh token is presented again.
+// The whole token family is revoked before this error is returned.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// preparer is satisfied by both *sql.DB and *sql.Tx
+type preparer interface {
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// IssueRefreshToken starts a new refresh token family for the user and returns the raw token
+//
+// Only the SHA-256 hash of the token is stored. Every token obtained by rotating
+// this one belongs to the same family, which lets reuse detection revoke all of them.
+func (r *SQLTokenRepository) IssueRefreshToken(userId int64) (string, error) {
+	familyId, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	return insertRefreshToken(r.DB, userId, familyId)
+}
+
+func insertRefreshToken(p preparer, userId int64, familyId string) (string, error) {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	query := `
+	INSERT INTO refresh_tokens(user_id, family_id, token_hash, expires_at, created_at)
+	VALUES (?, ?, ?, ?, ?)`
+	stmt, err := p.Prepare(query)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer stmt.Close()
+
+	now := time.Now().UTC()
+	_, err = stmt.Exec(userId, familyId, utils.HashToken(token), now.Add(utils.RefreshTokenTTL), now)
+
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same family
+//
+// The presented token is marked as rotated and can never be used again. If a
+// rotated token is presented a second time it has most likely been stolen, so
+// every token in its family is revoked and ErrRefreshTokenReused is returned.
+//
+// Returns the new raw refresh token and the ID of the user it belongs to.
+func (r *SQLTokenRepository) RotateRefreshToken(token string) (string, int64, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return "", 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	SELECT id, user_id, family_id, expires_at, rotated_at, revoked_at
+	FROM refresh_tokens WHERE token_hash = ?`
+	row := tx.QueryRow(query, utils.HashToken(token))
+
+	var id, userId int64
+	var familyId string
+	var expiresAt time.Time
+	var rotatedAt, revokedAt sql.NullTime
+	err = row.Scan(&id, &userId, &familyId, &expiresAt, &rotatedAt, &revokedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	if revokedAt.Valid {
+		return "", 0, ErrRefreshTokenInvalid
+	}
+
+	if time.Now().After(expiresAt) && !rotatedAt.Valid {
+		return "", 0, ErrRefreshTokenInvalid
+	}
+
+	// Only one of two concurrent rotations can mark the token, and the other
+	// is treated as reuse, as if it had come second
+	rotated := false
+	if !rotatedAt.Valid {
+		result, err := tx.Exec("UPDATE refresh_tokens SET rotated_at = ? WHERE id = ? AND rotated_at IS NULL AND revoked_at IS NULL",
+			time.Now().UTC(), id)
+		if err != nil {
+			return "", 0, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return "", 0, err
+		}
+		rotated = affected == 1
+	}
+
+	if !rotated {
+		err = revokeFamily(tx, familyId)
+		if err != nil {
+			return "", 0, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return "", 0, err
+		}
+		return "", 0, ErrRefreshTokenReused
+	}
+
+	newToken, err := insertRefreshToken(tx, userId, familyId)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return newToken, userId, tx.Commit()
+}
+
+// RevokeRefreshToken revokes the family the given refresh token belongs to
+//
+// Revoking a family that is already revoked is not an error, so logging out
+// twice with the same token succeeds.
+func (r *SQLTokenRepository) RevokeRefreshToken(token string) error {
+	query := "SELECT family_id FROM refresh_tokens WHERE token_hash = ?"
+	row := r.DB.QueryRow(query, utils.HashToken(token))
+
+	var familyId string
+	err := row.Scan(&familyId)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	return revokeFamily(r.DB, familyId)
+}
+
+func revokeFamily(p preparer, familyId string) error {
+	query := "UPDATE refresh_tokens SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL"
+	stmt, err := p.Prepare(query)
+
+	if err != nil {
+		return err
+	}
+
+	defer stmt.Close()
+
+	_, err = stmt.Exec(time.Now().UTC(), familyId)
+	return err
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records a span for each traced model operation
+var tracer = otel.Tracer("github.com/PaulFWatts/rest_api_golang/models")
+
+// startSpan starts a client span named "models.<operation>" for a database operation,
+// where operation names the repository interface and method, e.g. "EventRepository.List"
+//
+// Use it together with endSpan and a named error result:
+//
+//	ctx, span := startSpan(ctx, "EventRepository.GetByID", attribute.Int64("event.id", id))
+//	defer func() { endSpan(span, err) }()
+func startSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, semconv.DBSystemSqlite, semconv.DBOperationName(operation))
+	return tracer.Start(ctx, "models."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan records the operation's error, if any, and ends the span
+//
+// A missing row or event is an expected outcome rather than a failure, so it
+// does not mark the span as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, ErrEventNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
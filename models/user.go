@@ -1,46 +1,178 @@
 package models
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"time"
 
-	"github.com/PaulFWatts/rest_api_golang/db"
 	"github.com/PaulFWatts/rest_api_golang/utils"
 )
 
 // User represents a user in the system
 type User struct {
-	ID       int64
-	Email    string `binding:"required"`
-	Password string `binding:"required"`
+	ID              int64
+	Email           string     `json:"email" binding:"required"`
+	Password        string     `json:"password" binding:"required"`
+	Roles           []string   `json:"-"`
+	Permissions     []string   `json:"-"`
+	TokenVersion    int64      `json:"-"`
+	EmailVerifiedAt *time.Time `json:"-"` // nil until the user follows the link in their verification email
+	MFAEnabled      bool       `json:"-"` // Whether logging in also requires a TOTP or recovery code
 }
 
-// Save inserts a new user into the database
-func (u *User) Save() error {
-	query := "INSERT INTO users(email, password) VALUES (?, ?)"
-	stmt, err := db.DB.Prepare(query)
+// ErrUserNotFound is returned when no account has the given email address or ID
+var ErrUserNotFound = errors.New("user not found")
 
+// ErrInvalidEmail is returned when an email address cannot be parsed
+var ErrInvalidEmail = errors.New("invalid email address")
+
+// NormalizeEmail validates an email address and returns it trimmed and lower-cased
+//
+// Only a bare address is accepted ("user@example.com", not "User <user@example.com>"),
+// so the stored value can be compared directly and the UNIQUE constraint on
+// users.email catches addresses that differ only in case or surrounding spaces.
+func NormalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return "", ErrInvalidEmail
+	}
+
+	return strings.ToLower(email), nil
+}
+
+// emailCandidates returns the stored emails that an address given at login or
+// for a password reset may belong to, in the order they are looked up
+//
+// Migration 0008 left accounts whose email was not a valid address, or whose
+// normalised email was already taken, with the email as it was. Those are only
+// found by the exact stored value, so it is tried before the normalised form.
+func emailCandidates(email string) []string {
+	candidates := []string{email}
+	normalized, err := NormalizeEmail(email)
+	if err == nil && normalized != email {
+		candidates = append(candidates, normalized)
+	}
+	return candidates
+}
+
+// queryUserByEmail scans the row that query, selecting from users WHERE email = ?,
+// returns for the first of the email's candidates that matches, and returns the
+// stored email that matched
+func queryUserByEmail(ctx context.Context, database *sql.DB, query string, email string, dest ...any) (string, error) {
+	for _, candidate := range emailCandidates(email) {
+		err := database.QueryRowContext(ctx, query, candidate).Scan(dest...)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		return candidate, err
+	}
+	return "", sql.ErrNoRows
+}
+
+// Save inserts a new, unverified user into the database
+//
+// The email address is validated and normalised first; ErrInvalidEmail is
+// returned if it is not a valid address, and a *PasswordPolicyError if the
+// password does not meet the password policy.
+func (r *SQLUserRepository) Save(ctx context.Context, u *User) (err error) {
+	ctx, span := startSpan(ctx, "UserRepository.Save")
+	defer func() { endSpan(span, err) }()
+
+	email, err := NormalizeEmail(u.Email)
 	if err != nil {
 		return err
 	}
+	u.Email = email
 
-	defer stmt.Close()
+	err = CheckPasswordPolicy(u.Password, u.Email)
+	if err != nil {
+		return err
+	}
+
+	// Hash the password before saving, and before the transaction starts so
+	// it is not held open for the slow hash
+	hashedPassword, err := utils.HashPassword(ctx, u.Password)
+	if err != nil {
+		return err
+	}
 
-	// Hash the password before saving
-	hashedPassword, err := utils.HashPassword(u.Password)
+	// The user and their default role are created together, so a failure
+	// never leaves an account without any role
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	result, err := stmt.Exec(u.Email, hashedPassword)
+	var userId int64
+	query := "INSERT INTO users(email, password) VALUES (?, ?) RETURNING id"
+	err = tx.QueryRowContext(ctx, query, u.Email, hashedPassword).Scan(&userId)
 
 	if err != nil {
 		return err
 	}
 
-	userId, err := result.LastInsertId()
+	// Every new account starts with the default role
+	err = insertUserRole(tx, userId, RoleUser)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
 
 	u.ID = userId
-	return err
+
+	observer.UserSignedUp()
+	return nil
+}
+
+// lockUser holds the user's row until the transaction ends, or returns ErrUserNotFound
+//
+// Transactions that read a user's second factors, roles or sent emails and
+// then write based on them take this lock first, so they run one at a time
+// per user. Like lockEvent it takes the lock with a no-op update.
+func lockUser(tx *sql.Tx, userId int64) error {
+	result, err := tx.Exec("UPDATE users SET token_version = token_version WHERE id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// GetByID loads a user's ID, email, token version and verification time, or
+// returns ErrUserNotFound; the password hash is never loaded
+func (r *SQLUserRepository) GetByID(id int64) (*User, error) {
+	query := "SELECT id, email, token_version, email_verified_at FROM users WHERE id = ?"
+	row := r.DB.QueryRow(query, id)
+
+	var user User
+	err := row.Scan(&user.ID, &user.Email, &user.TokenVersion, &user.EmailVerifiedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
 }
 
 // ValidateCredentials verifies a user's email and password against the database
@@ -49,9 +181,10 @@ func (u *User) Save() error {
 // 1. Queries the database for a user with the provided email address
 // 2. Retrieves the stored hashed password for that user
 // 3. Compares the provided plain text password with the stored hash
+// 4. Re-hashes the password if the stored hash uses an outdated scheme or cost
 //
 // Parameters:
-// - Uses the User struct's Email and Password fields for validation
+// - Uses the User struct's Email and Password fields for validation; the email is matched as given, then normalised
 //
 // Returns:
 // - nil if the credentials are valid (user exists and password matches)
@@ -59,23 +192,66 @@ func (u *User) Save() error {
 //   - No user found with the provided email address
 //   - The provided password doesn't match the stored password hash
 //
-// Note: This method updates the User's ID field with the database ID if validation succeeds
-func (u *User) ValidateCredentials() error {
-	query := "SELECT id, password FROM users WHERE email = ?"
-	row := db.DB.QueryRow(query, u.Email)
+// Note: This method sets the User's Email to the stored one and updates its ID, TokenVersion and MFAEnabled fields from the database if validation succeeds
+func (r *SQLUserRepository) ValidateCredentials(ctx context.Context, u *User) error {
+	query := "SELECT id, password, token_version, totp_enabled_at IS NOT NULL FROM users WHERE email = ?"
 
 	var retrievedPassword string
-	err := row.Scan(&u.ID, &retrievedPassword)
+	email, err := queryUserByEmail(ctx, r.DB, query, u.Email, &u.ID, &retrievedPassword, &u.TokenVersion, &u.MFAEnabled)
 
 	if err != nil {
 		return errors.New("credentials invalid")
 	}
+	u.Email = email
 
-	passwordIsValid := utils.CheckPasswordHash(u.Password, retrievedPassword)
+	passwordIsValid := utils.CheckPasswordHash(ctx, u.Password, retrievedPassword)
 
 	if !passwordIsValid {
 		return errors.New("credentials invalid")
 	}
 
+	// Hashes made with another scheme or older parameters are upgraded while the plain password is at hand
+	if utils.PasswordNeedsRehash(retrievedPassword) {
+		err = r.rehashPassword(ctx, u, retrievedPassword)
+		if err != nil {
+			slog.Warn("Could not upgrade password hash", "userId", u.ID, "error", err)
+		}
+	}
+
 	return nil
 }
+
+// rehashPassword replaces the stored hash with one made by the current password hasher
+//
+// The update only applies if the stored hash is still oldHash, so a password
+// changed in the meantime is never overwritten.
+func (r *SQLUserRepository) rehashPassword(ctx context.Context, u *User, oldHash string) error {
+	hashedPassword, err := utils.HashPassword(ctx, u.Password)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.DB.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ? AND password = ?", hashedPassword, u.ID, oldHash)
+	return err
+}
+
+// GetTokenVersion returns the user's current token version
+//
+// Access tokens carrying an older version were issued before the user's
+// tokens were invalidated and must be rejected.
+func (r *SQLUserRepository) GetTokenVersion(userId int64) (int64, error) {
+	var version int64
+	err := r.DB.QueryRow("SELECT token_version FROM users WHERE id = ?", userId).Scan(&version)
+	return version, err
+}
+
+// Claims returns the access token claims for the user; call LoadRoles first
+func (u *User) Claims() utils.TokenClaims {
+	return utils.TokenClaims{
+		UserID:       u.ID,
+		Email:        u.Email,
+		Roles:        u.Roles,
+		Permissions:  u.Permissions,
+		TokenVersion: u.TokenVersion,
+	}
+}
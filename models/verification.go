@@ -0,0 +1,123 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// VerificationEmailInterval is the minimum time between two verification emails to the same user
+const VerificationEmailInterval = time.Minute
+
+// VerificationEmailsPerDay is how many verification emails a user can be sent in 24 hours
+const VerificationEmailsPerDay = 5
+
+// ErrEmailVerificationInvalid is returned for invalid or expired verification tokens,
+// and for tokens issued for an address the account no longer uses
+var ErrEmailVerificationInvalid = errors.New("email verification token invalid")
+
+// ErrEmailAlreadyVerified is returned when a verification email is requested for a verified address
+var ErrEmailAlreadyVerified = errors.New("email already verified")
+
+// IsEmailVerified reports whether the user has verified their email address
+func (r *SQLUserRepository) IsEmailVerified(userId int64) (bool, error) {
+	var verifiedAt sql.NullTime
+	err := r.DB.QueryRow("SELECT email_verified_at FROM users WHERE id = ?", userId).Scan(&verifiedAt)
+	return verifiedAt.Valid, err
+}
+
+// VerifyEmail marks the user's address as verified, if it is still the email
+// the verification token was issued for; otherwise it returns ErrEmailVerificationInvalid
+//
+// Verifying an address twice succeeds without changing the original verification time.
+func (r *SQLUserRepository) VerifyEmail(userId int64, email string) error {
+	query := `
+	UPDATE users SET email_verified_at = COALESCE(email_verified_at, ?)
+	WHERE id = ? AND email = ?`
+	result, err := r.DB.Exec(query, time.Now().UTC(), userId, email)
+
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrEmailVerificationInvalid
+	}
+
+	return nil
+}
+
+// ReserveVerificationEmail records that a verification email is about to be sent to the user
+//
+// At most one email per VerificationEmailInterval and VerificationEmailsPerDay
+// per 24 hours are allowed. When the limit is reached nothing is recorded and
+// the returned duration is how long the caller has to wait; otherwise it is zero.
+// Returns ErrEmailAlreadyVerified if the user's address is already verified.
+func (r *SQLUserRepository) ReserveVerificationEmail(userId int64) (time.Duration, error) {
+	tx, err := r.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Held so that concurrent requests cannot both see room for another email
+	err = lockUser(tx, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	var verifiedAt sql.NullTime
+	err = tx.QueryRow("SELECT email_verified_at FROM users WHERE id = ?", userId).Scan(&verifiedAt)
+	if err != nil {
+		return 0, err
+	}
+	if verifiedAt.Valid {
+		return 0, ErrEmailAlreadyVerified
+	}
+
+	now := time.Now().UTC()
+
+	rows, err := tx.Query("SELECT sent_at FROM verification_emails WHERE user_id = ? AND sent_at > ? ORDER BY sent_at",
+		userId, now.Add(-24*time.Hour))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var sent []time.Time
+	for rows.Next() {
+		var sentAt time.Time
+		err := rows.Scan(&sentAt)
+		if err != nil {
+			return 0, err
+		}
+		sent = append(sent, sentAt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	var wait time.Duration
+	if len(sent) > 0 {
+		wait = max(wait, sent[len(sent)-1].Add(VerificationEmailInterval).Sub(now))
+	}
+	if len(sent) >= VerificationEmailsPerDay {
+		wait = max(wait, sent[len(sent)-VerificationEmailsPerDay].Add(24*time.Hour).Sub(now))
+	}
+	if wait > 0 {
+		return wait, nil
+	}
+
+	_, err = tx.Exec("INSERT INTO verification_emails(user_id, sent_at) VALUES (?, ?)", userId, now)
+	if err != nil {
+		return 0, err
+	}
+
+	return 0, tx.Commit()
+}
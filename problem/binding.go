@@ -0,0 +1,100 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// init makes the validator report fields by the names clients send, taken
+// from their json tags, so that a failure on a field tagged json:"dateTime" is
+// reported as "dateTime" rather than "DateTime"
+func init() {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if ok {
+		validate.RegisterTagNameFunc(jsonName)
+	}
+}
+
+// FromBinding translates an error from ShouldBindJSON into a problem
+//
+// Validation failures become a validation_failed problem with one FieldError
+// per failed rule; a value of the wrong JSON type is reported the same way.
+// A body that is empty or not JSON at all becomes an invalid_body problem.
+func FromBinding(err error) *Problem {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fields := make([]FieldError, len(validationErrors))
+		for i, failure := range validationErrors {
+			fields[i] = FieldError{Field: fieldPath(failure.Namespace()), Code: failure.Tag(), Param: failure.Param()}
+		}
+		return Invalid(fields...)
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		// encoding/json already names the field by its json tag
+		return Invalid(FieldError{Field: typeError.Field, Code: "type", Param: jsonType(typeError.Type)})
+	}
+
+	var timeError *time.ParseError
+	if errors.As(err, &timeError) {
+		return New(http.StatusBadRequest, CodeInvalidBody, "Times must be RFC 3339 timestamps such as 2025-01-01T10:00:00Z.")
+	}
+
+	if errors.Is(err, io.EOF) {
+		return New(http.StatusBadRequest, CodeInvalidBody, "Request body is empty.")
+	}
+
+	return New(http.StatusBadRequest, CodeInvalidBody, "Could not parse request data.")
+}
+
+// jsonName returns the name a struct field has in JSON: the name in its json
+// tag, or otherwise the field name, as encoding/json does
+//
+// Request types tag every field, so the names match the API documentation.
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// fieldPath drops the root struct from a validator namespace such as
+// "Event.capacity", leaving the path within the request body
+func fieldPath(namespace string) string {
+	_, path, found := strings.Cut(namespace, ".")
+	if !found {
+		return namespace
+	}
+	return path
+}
+
+// jsonType names the JSON type a Go type is decoded from
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,74 @@
+package problem
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// testRequest has fields whose JSON names differ from their Go names
+type testRequest struct {
+	DateTime time.Time `json:"dateTime" binding:"required"`
+	RRule    string    `json:"rrule,omitempty" binding:"required"`
+	Capacity *int64    `json:"capacity" binding:"omitempty,min=1"`
+	Venue    struct {
+		PostCode string `json:"postcode" binding:"required"`
+	} `json:"venue"`
+	Internal string `json:"-"`
+	Untagged string `binding:"omitempty,email"`
+}
+
+// bind decodes the body into a testRequest as ShouldBindJSON does
+func bind(t *testing.T, body string) *Problem {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	var decoded testRequest
+	err := binding.JSON.Bind(request, &decoded)
+	if err == nil {
+		t.Fatalf("binding %s succeeded", body)
+	}
+	return FromBinding(err)
+}
+
+func TestFromBindingNamesFieldsByTheirJSONTags(t *testing.T) {
+	tests := []struct {
+		body string
+		want []FieldError
+	}{
+		{`{"capacity": 0, "Untagged": "not an email"}`, []FieldError{
+			{Field: "dateTime", Code: "required"},
+			{Field: "rrule", Code: "required"},
+			{Field: "capacity", Code: "min", Param: "1"},
+			{Field: "venue.postcode", Code: "required"},
+			{Field: "Untagged", Code: "email"},
+		}},
+		{`{"rrule": 5}`, []FieldError{{Field: "rrule", Code: "type", Param: "string"}}},
+		{`{"venue": {"postcode": true}}`, []FieldError{{Field: "venue.postcode", Code: "type", Param: "string"}}},
+		{`{"capacity": "ten"}`, []FieldError{{Field: "capacity", Code: "type", Param: "number"}}},
+	}
+
+	for _, test := range tests {
+		problem := bind(t, test.body)
+		if problem.Status != http.StatusBadRequest || problem.Code != CodeValidationFailed {
+			t.Errorf("binding %s: status %d, code %q, want 400 %q", test.body, problem.Status, problem.Code, CodeValidationFailed)
+		}
+		if !slices.Equal(problem.Errors, test.want) {
+			t.Errorf("binding %s: errors %+v, want %+v", test.body, problem.Errors, test.want)
+		}
+	}
+}
+
+func TestFromBindingRejectsUnparsableBodies(t *testing.T) {
+	for _, body := range []string{"", "not json", `{"dateTime": "tomorrow", "rrule": "FREQ=DAILY"}`} {
+		problem := bind(t, body)
+		if problem.Status != http.StatusBadRequest || problem.Code != CodeInvalidBody || len(problem.Errors) != 0 {
+			t.Errorf("binding %q: %+v, want a 400 %q problem without field errors", body, problem, CodeInvalidBody)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package problem writes API errors as RFC 7807 problem details.
+//
+// Every error response has the media type application/problem+json and a
+// body such as:
+//
+//	{
+//	  "type": "urn:rest-api-golang:problem:validation_failed",
+//	  "title": "Bad Request",
+//	  "status": 400,
+//	  "detail": "dateTime: required",
+//	  "instance": "/events",
+//	  "code": "validation_failed",
+//	  "requestId": "4f1c...",
+//	  "errors": [{"field": "dateTime", "code": "required"}]
+//	}
+//
+// Clients should branch on code, which is stable, and show detail, which
+// may be reworded. errors lists the individual fields that failed validation.
+package problem
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of problem detail responses
+const ContentType = "application/problem+json"
+
+// typePrefix turns a code into the problem type URI
+const typePrefix = "urn:rest-api-golang:problem:"
+
+// Codes identify the kind of problem; they never change once published
+const (
+	CodeInvalidBody      = "invalid_body"       // The request body is not JSON of the expected shape
+	CodeValidationFailed = "validation_failed"  // One or more fields are invalid; see errors
+	CodeInvalidParameter = "invalid_parameter"  // A path or query parameter is invalid
+	CodeUnauthorized     = "unauthorized"       // The token is missing or invalid
+	CodeForbidden        = "forbidden"          // The user lacks a permission
+	CodeNotOwner         = "not_owner"          // Only the event's owner may do this
+	CodeNotFound         = "not_found"          // The resource does not exist
+	CodeRateLimited      = "rate_limited"       // Too many requests; see Retry-After
+	CodeInternal         = "internal_error"     // Something went wrong on the server
+	CodeNotImplemented   = "not_implemented"    // The feature is not available on this server
+	CodeEmailNotVerified = "email_not_verified" // The user must verify their email address first
+
+	CodeInvalidCredentials   = "invalid_credentials"    // Wrong email address or password
+	CodeLoginThrottled       = "login_throttled"        // Too many failed logins; see Retry-After
+	CodeInvalidToken         = "invalid_token"          // A refresh, MFA, reset or verification token is invalid or expired
+	CodeInvalidMFACode       = "invalid_mfa_code"       // Wrong TOTP or recovery code
+	CodeMFAAlreadyEnabled    = "mfa_already_enabled"    // Two-factor authentication is already on
+	CodeMFANotEnabled        = "mfa_not_enabled"        // Two-factor authentication is off
+	CodeMFAEnrolmentRequired = "mfa_enrolment_required" // Enrolment must be started before it is confirmed
+	CodeEmailAlreadyVerified = "email_already_verified" // The email address is already verified
+	CodeAlreadyRegistered    = "already_registered"     // The user is already registered for the event
+	CodeNotRegistered        = "not_registered"         // The user is not registered for the event
+	CodeOccurrenceRequired   = "occurrence_required"    // Recurring events need an occurrence to register for
+)
+
+// Problem is an API error, written to the client as problem details
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes one invalid field of the request body
+type FieldError struct {
+	// Field is the JSON name of the field, with dots and indexes for nested values
+	Field string `json:"field"`
+	// Code is the rule that failed, such as "required" or "min"
+	Code string `json:"code"`
+	// Param is the rule's parameter, such as "1" for min=1
+	Param string `json:"param,omitempty"`
+	// Detail explains the failure when the code alone does not
+	Detail string `json:"detail,omitempty"`
+}
+
+// String formats the field error the way it appears in the problem's detail, e.g. "capacity: min=1"
+func (f FieldError) String() string {
+	text := f.Field + ": " + f.Code
+	if f.Param != "" {
+		text += "=" + f.Param
+	}
+	if f.Detail != "" {
+		text += " (" + f.Detail + ")"
+	}
+	return text
+}
+
+// New returns a problem with the status, code and human-readable detail
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   typePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Invalid returns a validation_failed problem for the fields, whose detail lists them all
+func Invalid(fields ...FieldError) *Problem {
+	details := make([]string, len(fields))
+	for i, field := range fields {
+		details[i] = field.String()
+	}
+
+	p := New(http.StatusBadRequest, CodeValidationFailed, strings.Join(details, "; "))
+	p.Errors = fields
+	return p
+}
+
+// Error returns the code and detail, so that problems can be passed around as errors
+func (p *Problem) Error() string {
+	return p.Code + ": " + p.Detail
+}
+
+// Write sends the problem as the response and stops the handler chain
+//
+// The instance is the request path without its query string, which may carry
+// tokens, and the request ID ties the response to the server's logs.
+func Write(context *gin.Context, p *Problem) {
+	p.Instance = context.Request.URL.Path
+	if requestId, ok := context.Get("requestId"); ok {
+		p.RequestID = requestId.(string)
+	}
+
+	// Set before rendering, so that the JSON renderer keeps it
+	context.Header("Content-Type", ContentType)
+	context.AbortWithStatusJSON(p.Status, p)
+}
+
+// Respond sends a problem with the status, code and detail and stops the handler chain
+func Respond(context *gin.Context, status int, code, detail string) {
+	Write(context, New(status, code, detail))
+}
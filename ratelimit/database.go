@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+)
+
+// DatabaseStore keeps buckets in the rate_limits table, so every process using
+// the same database shares them and they survive restarts
+type DatabaseStore struct {
+	DB *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// Take takes a token from the bucket for the key
+//
+// The first statement creates the bucket full if it is new and returns it
+// otherwise. As an upsert it locks the bucket's row until the transaction
+// commits, so concurrent requests for the same key take their tokens one
+// after another and cannot both take the last one, while requests for other
+// keys do not wait. Rows for buckets that have refilled are deleted from time
+// to time.
+func (s *DatabaseStore) Take(ctx context.Context, key string, rate config.Rate) (Result, error) {
+	// Stored in UTC so that full_at compares correctly as text
+	now := time.Now().UTC()
+
+	// Outside the transaction, so the sweep's row locks are not held with the bucket's
+	if s.sweepDue(now) {
+		_, err := s.DB.ExecContext(ctx, "DELETE FROM rate_limits WHERE full_at <= ?", now)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO rate_limits(key, tokens, updated_at, full_at)
+	VALUES (?, ?, ?, ?)
+	ON CONFLICT(key) DO UPDATE SET tokens = rate_limits.tokens
+	RETURNING tokens, updated_at`
+	var b bucket
+	err = tx.QueryRowContext(ctx, query, key, float64(rate.Limit), now, now).Scan(&b.tokens, &b.updated)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := b.take(rate, now)
+
+	query = "UPDATE rate_limits SET tokens = ?, updated_at = ?, full_at = ? WHERE key = ?"
+	_, err = tx.ExecContext(ctx, query, b.tokens, b.updated, now.Add(result.Reset), key)
+	if err != nil {
+		return Result{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result, nil
+}
+
+// sweepDue reports whether refilled buckets should be deleted now, at most
+// once per sweepInterval
+func (s *DatabaseStore) sweepDue(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return false
+	}
+	s.lastSweep = now
+	return true
+}
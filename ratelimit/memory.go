@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+)
+
+// sweepInterval is how often stores forget buckets that have refilled
+const sweepInterval = time.Minute
+
+// MemoryStore keeps buckets in memory, so each instance of the API limits
+// clients separately and the buckets are lost on restart
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+// memoryBucket is a bucket and the time it will be full again
+type memoryBucket struct {
+	bucket
+	fullAt time.Time
+}
+
+// NewMemoryStore returns an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*memoryBucket{}}
+}
+
+// Take takes a token from the bucket for the key
+//
+// A full bucket behaves exactly like a missing one, so buckets that have
+// refilled are dropped from time to time to keep memory bounded by the number
+// of recently active clients.
+func (s *MemoryStore) Take(_ context.Context, key string, rate config.Rate) (Result, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for key, b := range s.buckets {
+			if !now.Before(b.fullAt) {
+				delete(s.buckets, key)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		s.buckets[key] = b
+	}
+
+	result := b.take(rate, now)
+	b.fullAt = now.Add(result.Reset)
+
+	return result, nil
+}
@@ -0,0 +1,124 @@
+// Package ratelimit limits how often a client may call the API.
+//
+// Each client gets a token bucket per policy: the bucket holds up to Limit
+// tokens, refills at Limit tokens per Period and every request takes one, so
+// a client can burst up to Limit requests and then continue at the average
+// rate. Buckets live in the stores chosen at startup by Init: Take keeps them
+// in memory, since it runs on every request, while TakeShared, for the rarer
+// account requests, uses the configured store: memory for a single instance,
+// or the database so that every process sharing it shares the limits. Tests
+// call Use with stores of their own.
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+)
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed bool
+	// Limit is the bucket's capacity
+	Limit int
+	// Remaining is the number of whole tokens left after this request
+	Remaining int
+	// RetryAfter is how long a denied client must wait for the next token
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps the token buckets
+type Store interface {
+	// Take takes a token from the bucket for the key, refilled at the rate
+	Take(ctx context.Context, key string, rate config.Rate) (Result, error)
+}
+
+// local is the store used by Take and shared the one used by TakeShared,
+// chosen by Init or Use
+var local, shared Store
+
+// Init chooses the bucket stores from the rate limit configuration
+//
+// Take always keeps buckets in memory: with the database store every request
+// would write to the database, which on SQLite takes the single write lock.
+// The configured store is used by TakeShared only.
+func Init(cfg config.RateLimit, database *sql.DB) {
+	local = NewMemoryStore()
+
+	switch cfg.Store {
+	case "memory":
+		shared = local
+	case "database":
+		shared = &DatabaseStore{DB: database}
+	default:
+		panic("Unknown rate limit store " + cfg.Store + ".")
+	}
+}
+
+// Use replaces the stores used by Take and TakeShared
+func Use(localStore Store, sharedStore Store) {
+	local, shared = localStore, sharedStore
+}
+
+// Take takes a token for the key from the in-memory store chosen by Init or Use
+func Take(ctx context.Context, key string, rate config.Rate) (Result, error) {
+	return take(ctx, local, key, rate)
+}
+
+// TakeShared takes a token for the key from the configured store chosen by Init or Use
+func TakeShared(ctx context.Context, key string, rate config.Rate) (Result, error) {
+	return take(ctx, shared, key, rate)
+}
+
+func take(ctx context.Context, store Store, key string, rate config.Rate) (Result, error) {
+	if store == nil {
+		return Result{}, errors.New("rate limit store not initialised")
+	}
+	return store.Take(ctx, key, rate)
+}
+
+// bucket is the state of one token bucket
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket for the time since it was last updated, takes a
+// token if there is one, and describes the outcome
+//
+// A bucket seen for the first time is passed as the zero bucket and starts full.
+func (b *bucket) take(rate config.Rate, now time.Time) Result {
+	limit := float64(rate.Limit)
+	perToken := rate.Period / time.Duration(rate.Limit)
+
+	if b.updated.IsZero() {
+		b.tokens = limit
+	} else if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(limit, b.tokens+elapsed.Seconds()/perToken.Seconds())
+	}
+	b.updated = now
+
+	result := Result{Limit: rate.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = b.fullAfter(rate)
+
+	return result
+}
+
+// fullAfter returns how long the bucket takes to refill completely
+func (b *bucket) fullAfter(rate config.Rate) time.Duration {
+	perToken := rate.Period / time.Duration(rate.Limit)
+	return time.Duration((float64(rate.Limit) - b.tokens) * float64(perToken))
+}
@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/db/dbtest"
+)
+
+// forEachStore runs the test as a subtest against the memory store and a
+// database store on each database in dbtest.Drivers
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			test(t, &DatabaseStore{DB: dbtest.Open(t, driver)})
+		})
+	}
+}
+
+func TestTakeEmptiesTheBucket(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		rate := config.Rate{Limit: 3, Period: time.Hour}
+
+		for i := range 3 {
+			result, err := store.Take(context.Background(), "test:a", rate)
+			if err != nil {
+				t.Fatalf("Take: %v", err)
+			}
+			if !result.Allowed || result.Remaining != 2-i {
+				t.Fatalf("take %d = %+v, want allowed with %d remaining", i+1, result, 2-i)
+			}
+		}
+
+		result, err := store.Take(context.Background(), "test:a", rate)
+		if err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+		if result.Allowed || result.RetryAfter <= 0 {
+			t.Errorf("take from an empty bucket = %+v, want denied with a retry time", result)
+		}
+
+		// Other keys have buckets of their own
+		result, err = store.Take(context.Background(), "test:b", rate)
+		if err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+		if !result.Allowed {
+			t.Errorf("take for another key = %+v, want allowed", result)
+		}
+	})
+}
+
+func TestParallelTakesCannotOverdraw(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		rate := config.Rate{Limit: 10, Period: time.Hour}
+
+		var wg sync.WaitGroup
+		allowed := make(chan bool, 40)
+		for range 40 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				result, err := store.Take(context.Background(), "test:parallel", rate)
+				if err != nil {
+					t.Errorf("Take: %v", err)
+					return
+				}
+				allowed <- result.Allowed
+			}()
+		}
+		wg.Wait()
+		close(allowed)
+
+		taken := 0
+		for ok := range allowed {
+			if ok {
+				taken++
+			}
+		}
+		if taken != rate.Limit {
+			t.Errorf("%d of 40 parallel takes were allowed, want %d", taken, rate.Limit)
+		}
+	})
+}
+
+func TestInitKeepsTakeInMemory(t *testing.T) {
+	t.Cleanup(func() { Use(nil, nil) })
+
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			Init(config.RateLimit{Store: "database"}, dbtest.Open(t, driver))
+
+			if _, ok := local.(*MemoryStore); !ok {
+				t.Errorf("Take uses %T, want *MemoryStore", local)
+			}
+			if _, ok := shared.(*DatabaseStore); !ok {
+				t.Errorf("TakeShared uses %T, want *DatabaseStore", shared)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/PaulFWatts/rest_api_golang/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// calendarContentType is the media type of iCalendar data (RFC 5545 section 8.1)
+const calendarContentType = "text/calendar; charset=utf-8"
+
+// calendarRefreshInterval is how often calendar apps are asked to poll a subscribed feed
+const calendarRefreshInterval = time.Hour
+
+// getEventCalendar handles GET /events/:id.ics - exports an event as iCalendar data
+//
+// Gin cannot register /events/:id.ics next to /events/:id, so getEvent hands
+// requests whose ID ends in ".ics" to this handler. A recurring event is
+// exported as one VEVENT with its RRULE and EXDATEs (including cancelled
+// occurrences), plus one VEVENT per changed occurrence with a RECURRENCE-ID.
+//
+// HTTP Method: GET
+// Endpoint: /events/:id.ics
+// Authentication: Not required
+// URL Parameters: id (integer) - The unique event identifier
+//
+// Response Codes:
+//   - 200 OK: iCalendar data (text/calendar)
+//   - 400 Bad Request: Invalid or non-numeric event ID
+//   - 404 Not Found: No event has the ID
+//   - 500 Internal Server Error: Database query failed
+//
+// Response Body:
+//
+//	Success: BEGIN:VCALENDAR ... END:VCALENDAR
+//	Error: {"code": "not_found", "detail": "Event not found.", ...} (application/problem+json)
+func (s *Server) getEventCalendar(context *gin.Context, rawId string) {
+	eventId, err := strconv.ParseInt(rawId, 10, 64)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse event id.")
+		return
+	}
+
+	event, err := s.Events.GetByID(context.Request.Context(), eventId)
+
+	if errors.Is(err, models.ErrEventNotFound) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Event not found.")
+		return
+	}
+	if err != nil {
+		internalError(context, "Could not fetch event.", err)
+		return
+	}
+
+	modified, cancelled, err := s.Events.SeriesExceptions(*event)
+
+	if err != nil {
+		internalError(context, "Could not fetch event.", err)
+		return
+	}
+
+	series := utils.CalendarEvent{
+		UID:         calendarUID(event.ID, nil),
+		Sequence:    event.Sequence,
+		Stamp:       event.UpdatedAt,
+		Start:       event.DateTime,
+		Summary:     event.Name,
+		Description: event.Description,
+		Location:    event.Location,
+		RRule:       event.RRule,
+		ExDates:     append(append([]time.Time{}, event.ExDates...), cancelled...),
+	}
+
+	calendar := utils.Calendar{Events: []utils.CalendarEvent{series}}
+
+	for _, occurrence := range modified {
+		entry := calendarEvent(occurrence, calendarUID(event.ID, nil))
+		entry.RecurrenceID = occurrence.OriginalStart
+		calendar.Events = append(calendar.Events, entry)
+	}
+
+	context.Header("Content-Disposition", fmt.Sprintf(`inline; filename="event-%d.ics"`, event.ID))
+	context.Data(http.StatusOK, calendarContentType, calendar.Encode())
+}
+
+// getMyCalendar handles GET /me/calendar.ics - the user's subscribable calendar feed
+//
+// This handler lists every event the user holds a seat for, and every
+// occurrence of a recurring event they registered for, as iCalendar data.
+// Calendar apps cannot send an Authorization header, so the feed is
+// authenticated by the secret token from createCalendarToken instead. UIDs
+// are stable and SEQUENCE grows with every change to an event, so edits reach
+// subscribers on their next refresh.
+//
+// HTTP Method: GET
+// Endpoint: /me/calendar.ics?token=...
+// Authentication: Calendar feed token in the query string
+//
+// Response Codes:
+//   - 200 OK: iCalendar data (text/calendar)
+//   - 401 Unauthorized: Missing, unknown or revoked token
+//   - 500 Internal Server Error: Database query failed
+//
+// Security Notes:
+//   - Only the SHA-256 hash of the token is stored
+//   - Anyone with the URL can read the feed; revoke the token to cut off access
+func (s *Server) getMyCalendar(context *gin.Context) {
+	token := context.Query("token")
+	if token == "" {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeUnauthorized, "Not authorized.")
+		return
+	}
+
+	userId, err := s.Tokens.UserIDForCalendarToken(token)
+
+	if errors.Is(err, models.ErrCalendarTokenInvalid) {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeUnauthorized, "Not authorized.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not fetch calendar.", err)
+		return
+	}
+
+	occurrences, err := s.Registrations.ListRegisteredOccurrences(userId)
+
+	if err != nil {
+		internalError(context, "Could not fetch calendar.", err)
+		return
+	}
+
+	calendar := utils.Calendar{Name: "My events", RefreshInterval: calendarRefreshInterval}
+
+	for _, occurrence := range occurrences {
+		calendar.Events = append(calendar.Events, calendarEvent(occurrence, calendarUID(occurrence.EventID, occurrence.OriginalStart)))
+	}
+
+	context.Header("Cache-Control", "private, no-cache")
+	context.Data(http.StatusOK, calendarContentType, calendar.Encode())
+}
+
+// createCalendarToken handles POST /me/calendar-token - creates the user's calendar feed URL
+//
+// Each user has one feed token; creating a new one revokes the previous URL.
+//
+// HTTP Method: POST
+// Endpoint: /me/calendar-token
+// Authentication: Required (JWT middleware validates Authorization header)
+//
+// Response Codes:
+//   - 201 Created: Token created
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Calendar feed created!", "token": "...", "url": "https://host/me/calendar.ics?token=..."}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) createCalendarToken(context *gin.Context) {
+	userId := context.GetInt64("userId")
+
+	token, err := s.Tokens.IssueCalendarToken(userId)
+
+	if err != nil {
+		internalError(context, "Could not create calendar feed.", err)
+		return
+	}
+
+	feedURL, err := withToken(s.publicURL("/me/calendar.ics"), token)
+
+	if err != nil {
+		internalError(context, "Could not create calendar feed.", err)
+		return
+	}
+
+	context.JSON(http.StatusCreated, gin.H{"message": "Calendar feed created!", "token": token, "url": feedURL})
+}
+
+// revokeCalendarToken handles DELETE /me/calendar-token - revokes the user's calendar feed URL
+//
+// HTTP Method: DELETE
+// Endpoint: /me/calendar-token
+// Authentication: Required (JWT middleware validates Authorization header)
+//
+// Response Codes:
+//   - 200 OK: Token revoked (also returned if the user had none)
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Calendar feed revoked!"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) revokeCalendarToken(context *gin.Context) {
+	userId := context.GetInt64("userId")
+
+	err := s.Tokens.RevokeCalendarToken(userId)
+
+	if err != nil {
+		internalError(context, "Could not revoke calendar feed.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Calendar feed revoked!"})
+}
+
+// calendarEvent converts an occurrence to a VEVENT with the given UID
+func calendarEvent(occurrence models.Occurrence, uid string) utils.CalendarEvent {
+	return utils.CalendarEvent{
+		UID:         uid,
+		Sequence:    occurrence.Sequence,
+		Stamp:       occurrence.UpdatedAt,
+		Start:       occurrence.Start,
+		Summary:     occurrence.Name,
+		Description: occurrence.Description,
+		Location:    occurrence.Location,
+	}
+}
+
+// calendarUID returns the stable iCalendar UID of an event, or of one
+// occurrence of a recurring event exported on its own
+func calendarUID(eventId int64, occurrence *time.Time) string {
+	if occurrence == nil {
+		return fmt.Sprintf("event-%d@rest-api-golang", eventId)
+	}
+	return fmt.Sprintf("event-%d-%s@rest-api-golang", eventId, occurrence.UTC().Format("20060102T150405Z"))
+}
+
+// publicURL returns the URL of a path on this server at the configured
+// public URL. The request's Host header is never used, since a client could
+// set it to make emailed links send tokens to another site.
+func (s *Server) publicURL(path string) string {
+	return strings.TrimSuffix(s.App.PublicURL, "/") + path
+}
+
+// withToken returns the link with the token set as its "token" query parameter,
+// keeping any query the link already has
+func withToken(link string, token string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
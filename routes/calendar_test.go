@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCalendarFeed(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.signUp(t, "ada@example.com")
+		event := ts.createTestEvent(t, token, eventJSON("Book club"))
+		expectStatus(t, ts.do(http.MethodPost, "/events/"+strconv.FormatInt(event.ID, 10)+"/register", token, ""), http.StatusCreated)
+
+		// The feed URL is built from the public URL, not from the Host header the client sent
+		request := httptest.NewRequest(http.MethodPost, "/me/calendar-token", nil)
+		request.Host = "attacker.example"
+		request.Header.Set("Authorization", token)
+		recorder := httptest.NewRecorder()
+		ts.router.ServeHTTP(recorder, request)
+		expectStatus(t, recorder, http.StatusCreated)
+
+		var feed struct{ Token, URL string }
+		decode(t, recorder, &feed)
+		if !strings.HasPrefix(feed.URL, "https://api.example.com/me/calendar.ics?token=") {
+			t.Errorf("feed URL = %q, want it under the public URL", feed.URL)
+		}
+
+		path := "/me/calendar.ics?token=" + url.QueryEscape(feed.Token)
+		recorder = ts.do(http.MethodGet, path, "", "")
+		expectStatus(t, recorder, http.StatusOK)
+		if !strings.Contains(recorder.Body.String(), "SUMMARY:Book club") {
+			t.Errorf("feed does not list the registered event:\n%s", recorder.Body)
+		}
+
+		expectStatus(t, ts.do(http.MethodGet, "/me/calendar.ics", "", ""), http.StatusUnauthorized)
+
+		expectStatus(t, ts.do(http.MethodDelete, "/me/calendar-token", token, ""), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodGet, path, "", ""), http.StatusUnauthorized)
+	})
+}
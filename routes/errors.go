@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/PaulFWatts/rest_api_golang/logging"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// internalError responds with a generic 500 problem and logs the error behind
+// it with the request ID, route and user ID, and on the request's trace span
+//
+// Clients never see the underlying error, since it can reveal internals such
+// as SQL; operators find it in the logs by the X-Request-ID of the response.
+func internalError(context *gin.Context, message string, err error) {
+	attrs := []any{"route", context.FullPath(), "error", err}
+	if userId, ok := context.Get("userId"); ok {
+		attrs = append(attrs, "userId", userId)
+	}
+
+	logging.FromContext(context.Request.Context()).Error(message, attrs...)
+	trace.SpanFromContext(context.Request.Context()).RecordError(err)
+	problem.Respond(context, http.StatusInternalServerError, problem.CodeInternal, message)
+}
+
+// notFound responds to requests for paths that match no route
+func notFound(context *gin.Context) {
+	problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "No such route.")
+}
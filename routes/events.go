@@ -1,38 +1,160 @@
 package routes
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/PaulFWatts/rest_api_golang/middlewares"
 	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 )
 
-// getEvents handles GET /events - retrieves all events from the database
+// eventRequest is the body of POST /events and PUT /events/:id
+type eventRequest struct {
+	Name        string      `json:"name" binding:"required"`
+	Description string      `json:"description" binding:"required"`
+	Location    string      `json:"location" binding:"required"`
+	DateTime    time.Time   `json:"dateTime" binding:"required"`
+	Capacity    *int64      `json:"capacity" binding:"omitempty,min=1"`
+	RRule       string      `json:"rrule"`
+	ExDates     []time.Time `json:"exDates"`
+}
+
+// event returns the event the request describes, without an ID or owner
+func (r eventRequest) event() models.Event {
+	return models.Event{
+		Name:        r.Name,
+		Description: r.Description,
+		Location:    r.Location,
+		DateTime:    r.DateTime,
+		Capacity:    r.Capacity,
+		RRule:       r.RRule,
+		ExDates:     r.ExDates,
+	}
+}
+
+// getEvents handles GET /events - retrieves a page of events from the database
 //
-// This handler fetches all events using the models.GetAllEvents() function
-// and returns them as a JSON array. If database errors occur, it returns
-// a 500 Internal Server Error with a generic error message.
+// This handler parses the filter and pagination query parameters, fetches the
+// matching page using the event repository and returns it in the list envelope.
+// Pages are addressed with opaque cursors, so following the next/prev links
+// never skips or repeats events when others are created or deleted meanwhile.
 //
 // HTTP Method: GET
 // Endpoint: /events
 // Authentication: Not required
 //
+// Query Parameters (all optional):
+//   - from, to (RFC 3339): Only events starting within this range
+//   - location (string): Case-insensitive substring of the location
+//   - owner (integer): Only events created by this user ID
+//   - text (string): Case-insensitive substring of the name or description
+//   - expand (boolean): "true" lists occurrences instead of events, see below
+//   - sort (string): "id" (default), "name" or "dateTime"; prefix with "-" for descending
+//   - limit (integer): Page size, default 20, maximum 100
+//   - cursor (string): Opaque cursor taken from a next/prev link
+//
+// Recurring events are listed once, filtered by the start of the series. With
+// expand=true every event is instead expanded into the occurrences that take
+// place between from and to, which are then required and at most 366 days
+// apart. Occurrences are sorted by "start" (the default) or "-start".
+//
 // Response Codes:
-//   - 200 OK: Successfully retrieved events (returns JSON array)
+//   - 200 OK: Successfully retrieved events
+//   - 400 Bad Request: Invalid filter, sort, limit, cursor or occurrence window, or too many occurrences in the window
 //   - 500 Internal Server Error: Database query failed
 //
 // Response Body:
 //
-//	Success: Array of Event objects
-//	Error: {"message": "Could not fetch events. Try again later."}
-func getEvents(context *gin.Context) {
-	events, err := models.GetAllEvents()
+//	Success: {"data": [...], "total": 42, "limit": 20, "next": "/events?cursor=...", "prev": null}
+//	Error: {"code": "invalid_parameter", "detail": "Invalid sort or cursor.", ...} (application/problem+json)
+func (s *Server) getEvents(context *gin.Context) {
+	filter, err := parseEventFilter(context)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse query parameters.")
+		return
+	}
+
+	pageRequest, err := parsePageRequest(context)
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not fetch events. Try again later."})
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse query parameters.")
 		return
 	}
-	context.JSON(http.StatusOK, events)
+
+	if context.Query("expand") == "true" {
+		s.getOccurrences(context, filter, pageRequest)
+		return
+	}
+
+	page, err := s.Events.List(context.Request.Context(), filter, pageRequest)
+	if isPageError(err) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid sort or cursor.")
+		return
+	}
+	if err != nil {
+		internalError(context, "Could not fetch events. Try again later.", err)
+		return
+	}
+	context.JSON(http.StatusOK, newPageResponse(context, page))
+}
+
+// getOccurrences writes the page of occurrences for getEvents with expand=true
+func (s *Server) getOccurrences(context *gin.Context, filter models.EventFilter, pageRequest models.PageRequest) {
+	page, err := s.Events.ListOccurrences(context.Request.Context(), filter, pageRequest)
+	if errors.Is(err, models.ErrInvalidWindow) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Expanding occurrences needs from and to, at most 366 days apart.")
+		return
+	}
+	if errors.Is(err, models.ErrTooManyOccurrences) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "More than 5000 occurrences take place between from and to. Narrow the window.")
+		return
+	}
+	if isPageError(err) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid sort or cursor.")
+		return
+	}
+	if err != nil {
+		internalError(context, "Could not fetch events. Try again later.", err)
+		return
+	}
+	context.JSON(http.StatusOK, newPageResponse(context, page))
+}
+
+// parseEventFilter reads the event filter query parameters accepted by getEvents
+func parseEventFilter(context *gin.Context) (models.EventFilter, error) {
+	var filter models.EventFilter
+	var err error
+
+	if raw := context.Query("from"); raw != "" {
+		filter.From, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	if raw := context.Query("to"); raw != "" {
+		filter.To, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	if raw := context.Query("owner"); raw != "" {
+		filter.OwnerID, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	filter.Location = context.Query("location")
+	filter.Text = context.Query("text")
+	return filter, nil
 }
 
 // getEvent handles GET /events/:id - retrieves a specific event by ID
@@ -49,23 +171,35 @@ func getEvents(context *gin.Context) {
 // Response Codes:
 //   - 200 OK: Successfully retrieved the event
 //   - 400 Bad Request: Invalid or non-numeric event ID
-//   - 500 Internal Server Error: Database query failed or event not found
+//   - 404 Not Found: No event has the ID
+//   - 500 Internal Server Error: Database query failed
 //
 // Response Body:
 //
 //	Success: Single Event object
-//	Error: {"message": "Could not parse event id."} or {"message": "Could not fetch event."}
-func getEvent(context *gin.Context) {
+//	Error: {"code": "not_found", "detail": "Event not found.", ...} (application/problem+json)
+//
+// Requests for /events/:id.ics are answered by getEventCalendar.
+func (s *Server) getEvent(context *gin.Context) {
+	if rawId, ok := strings.CutSuffix(context.Param("id"), ".ics"); ok {
+		s.getEventCalendar(context, rawId)
+		return
+	}
+
 	eventId, err := strconv.ParseInt(context.Param("id"), 10, 64)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse event id."})
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse event id.")
 		return
 	}
 
-	event, err := models.GetEventByID(eventId)
+	event, err := s.Events.GetByID(context.Request.Context(), eventId)
 
+	if errors.Is(err, models.ErrEventNotFound) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Event not found.")
+		return
+	}
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not fetch event."})
+		internalError(context, "Could not fetch event.", err)
 		return
 	}
 
@@ -81,6 +215,7 @@ func getEvent(context *gin.Context) {
 // HTTP Method: POST
 // Endpoint: /events
 // Authentication: Required (JWT middleware validates Authorization header)
+// Permissions: "events:create"
 //
 // Request Headers:
 //
@@ -92,38 +227,49 @@ func getEvent(context *gin.Context) {
 //   - location (string): Event location
 //   - dateTime (string): Event date/time in ISO format
 //
+// Optional fields:
+//   - capacity (integer): Maximum number of registered attendees
+//   - rrule (string): RFC 5545 recurrence rule, e.g. "FREQ=WEEKLY;COUNT=10"; dateTime is the first occurrence
+//   - exDates (array of strings): Occurrences excluded from the recurrence rule
+//
 // Response Codes:
 //   - 201 Created: Event successfully created
-//   - 400 Bad Request: Invalid JSON request data
+//   - 400 Bad Request: Invalid JSON request data or recurrence rule
 //   - 401 Unauthorized: Missing, invalid, or expired JWT token (handled by middleware)
 //   - 500 Internal Server Error: Database save operation failed
 //
 // Response Body:
 //
 //	Success: {"message": "Event created!", "event": {...}}
-//	Error: {"message": "error description"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
 //
 // Security Notes:
 //   - Authentication handled by JWT middleware before reaching this handler
 //   - User ID automatically extracted from validated JWT token via context
 //   - Uses JSON binding with struct validation tags
 //   - Proper event ownership through authenticated user ID
-func createEvent(context *gin.Context) {
-	var event models.Event
-	err := context.ShouldBindJSON(&event)
+func (s *Server) createEvent(context *gin.Context) {
+	var request eventRequest
+	err := context.ShouldBindJSON(&request)
 
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse request data."})
+		problem.Write(context, problem.FromBinding(err))
 		return
 	}
 
+	event := request.event()
 	userID := context.GetInt64("userId")
 	event.UserID = userID
 
-	err = event.Save()
+	err = s.Events.Save(context.Request.Context(), &event)
+
+	if errors.Is(err, models.ErrInvalidRecurrence) {
+		problem.Write(context, problem.Invalid(problem.FieldError{Field: "rrule", Code: "invalid"}))
+		return
+	}
 
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not create event. Try again later."})
+		internalError(context, "Could not create event. Try again later.", err)
 		return
 	}
 
@@ -134,11 +280,13 @@ func createEvent(context *gin.Context) {
 //
 // This handler validates the event ID from URL parameters, checks event ownership,
 // verifies the event exists in the database, parses the JSON update data, and saves
-// the changes. Only the event owner can modify their events, ensuring proper authorization.
+// the changes. Only the event owner can modify their events, unless the user holds
+// the "events:update:any" permission (for example administrators moderating events).
 //
 // HTTP Method: PUT
 // Endpoint: /events/:id
 // Authentication: Required (JWT middleware validates Authorization header)
+// Permissions: "events:update:own" or "events:update:any"
 // URL Parameters: id (integer) - The unique event identifier to update
 //
 // Request Body: JSON object with fields to update:
@@ -146,81 +294,136 @@ func createEvent(context *gin.Context) {
 //   - description (string): Updated event description
 //   - location (string): Updated event location
 //   - dateTime (string): Updated event date/time in ISO format
+//   - capacity (integer): As for createEvent; omitting it keeps the current
+//     capacity and null removes the limit
+//   - rrule, exDates: As for createEvent; omitting them keeps the current
+//     values. A recurring event cannot be made a single event.
 //
 // Response Codes:
 //   - 200 OK: Event successfully updated
-//   - 400 Bad Request: Invalid event ID or JSON request data
-//   - 401 Unauthorized: User not authorized to update this event (not owner)
-//   - 500 Internal Server Error: Event not found or database operation failed
+//   - 400 Bad Request: Invalid event ID, JSON request data or recurrence rule, or the rule was removed
+//   - 401 Unauthorized: User not authorized to update this event (not owner, no "events:update:any")
+//   - 403 Forbidden: User holds neither update permission (handled by middleware)
+//   - 404 Not Found: No event has the ID
+//   - 500 Internal Server Error: Database operation failed
 //
 // Response Body:
 //
 //	Success: {"message": "Event updated successfully!"}
-//	Error: {"message": "error description"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
 //
 // Security Notes:
 //   - Validates event exists and checks ownership before updates
 //   - URL parameter ID overrides request body ID
 //   - Authentication handled by JWT middleware
 //   - Authorization enforced through ownership validation
-func updateEvent(context *gin.Context) {
+func (s *Server) updateEvent(context *gin.Context) {
 	eventId, err := strconv.ParseInt(context.Param("id"), 10, 64)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse event id."})
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse event id.")
 		return
 	}
 
 	userID := context.GetInt64("userId")
-	event, err := models.GetEventByID(eventId)
+	event, err := s.Events.GetByID(context.Request.Context(), eventId)
 
+	if errors.Is(err, models.ErrEventNotFound) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Event not found.")
+		return
+	}
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not fetch the event."})
+		internalError(context, "Could not fetch the event.", err)
 		return
 	}
 
-	if event.UserID != userID {
-		context.JSON(http.StatusUnauthorized, gin.H{"message": "Not authorized to update this event."})
+	if event.UserID != userID && !middlewares.HasPermission(context, models.PermissionEventsUpdateAny) {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeNotOwner, "Not authorized to update this event.")
 		return
 	}
 
-	var updatedEvent models.Event
-	err = context.ShouldBindJSON(&updatedEvent)
+	var request eventRequest
+	err = context.ShouldBindBodyWith(&request, binding.JSON)
 
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse request data."})
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+	updatedEvent := request.event()
+
+	// A missing capacity and a null one both decode to nil, but only null
+	// removes the limit; dropping it would promote the whole waitlist
+	var fields map[string]json.RawMessage
+	err = context.ShouldBindBodyWith(&fields, binding.JSON)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+	if !hasField(fields, "capacity") {
+		updatedEvent.Capacity = event.Capacity
+	}
+	if !hasField(fields, "rrule") {
+		updatedEvent.RRule = event.RRule
+	}
+	if !hasField(fields, "exDates") {
+		updatedEvent.ExDates = event.ExDates
+	}
+
+	// Its per-occurrence changes and registrations would have nothing to belong to
+	if event.IsRecurring() && !updatedEvent.IsRecurring() {
+		problem.Write(context, problem.Invalid(problem.FieldError{Field: "rrule", Code: "required", Detail: "A recurring event cannot be made a single event; delete it instead."}))
 		return
 	}
 
 	updatedEvent.ID = eventId
-	err = updatedEvent.Update()
+	err = s.Events.Update(context.Request.Context(), updatedEvent)
+	if errors.Is(err, models.ErrInvalidRecurrence) {
+		problem.Write(context, problem.Invalid(problem.FieldError{Field: "rrule", Code: "invalid"}))
+		return
+	}
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not update event."})
+		internalError(context, "Could not update event.", err)
 		return
 	}
 	context.JSON(http.StatusOK, gin.H{"message": "Event updated successfully!"})
 }
 
+// hasField reports whether a decoded JSON object has the field, matching the
+// name case-insensitively as encoding/json does
+func hasField(fields map[string]json.RawMessage, name string) bool {
+	for field := range fields {
+		if strings.EqualFold(field, name) {
+			return true
+		}
+	}
+	return false
+}
+
 // deleteEvent handles DELETE /events/:id - removes an event with ownership validation
 //
 // This handler validates the event ID from URL parameters, checks event ownership,
 // and then permanently deletes the event. Only the event owner can delete their
-// events, providing proper authorization control. This operation cannot be undone.
+// events, unless the user holds the "events:delete:any" permission. This operation
+// cannot be undone.
 //
 // HTTP Method: DELETE
 // Endpoint: /events/:id
 // Authentication: Required (JWT middleware validates Authorization header)
+// Permissions: "events:delete:own" or "events:delete:any"
 // URL Parameters: id (integer) - The unique event identifier to delete
 //
 // Response Codes:
 //   - 200 OK: Event successfully deleted
 //   - 400 Bad Request: Invalid or non-numeric event ID
-//   - 401 Unauthorized: User not authorized to delete this event (not owner)
-//   - 500 Internal Server Error: Event not found or database deletion failed
+//   - 401 Unauthorized: User not authorized to delete this event (not owner, no "events:delete:any")
+//   - 403 Forbidden: User holds neither delete permission (handled by middleware)
+//   - 404 Not Found: No event has the ID
+//   - 500 Internal Server Error: Database deletion failed
 //
 // Response Body:
 //
 //	Success: {"message": "Event deleted successfully!"}
-//	Error: {"message": "error description"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
 //
 // Security Notes:
 //   - Validates event exists and checks ownership before deletion
@@ -231,30 +434,34 @@ func updateEvent(context *gin.Context) {
 // Database Impact:
 //   - Removes record permanently from events table
 //   - Foreign key constraints handle related registrations
-func deleteEvent(context *gin.Context) {
+func (s *Server) deleteEvent(context *gin.Context) {
 	eventId, err := strconv.ParseInt(context.Param("id"), 10, 64)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse event id."})
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse event id.")
 		return
 	}
 
 	userID := context.GetInt64("userId")
-	event, err := models.GetEventByID(eventId)
+	event, err := s.Events.GetByID(context.Request.Context(), eventId)
 
+	if errors.Is(err, models.ErrEventNotFound) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Event not found.")
+		return
+	}
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not fetch the event."})
+		internalError(context, "Could not fetch the event.", err)
 		return
 	}
 
-	if event.UserID != userID {
-		context.JSON(http.StatusUnauthorized, gin.H{"message": "Not authorized to delete this event."})
+	if event.UserID != userID && !middlewares.HasPermission(context, models.PermissionEventsDeleteAny) {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeNotOwner, "Not authorized to delete this event.")
 		return
 	}
 
-	err = event.Delete()
+	err = s.Events.Delete(context.Request.Context(), *event)
 
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not delete the event."})
+		internalError(context, "Could not delete the event.", err)
 		return
 	}
 
@@ -0,0 +1,231 @@
+package routes
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/PaulFWatts/rest_api_golang/models"
+)
+
+// createTestEvent creates an event through the API and returns it
+func (ts *testServer) createTestEvent(t *testing.T, token string, body string) models.Event {
+	t.Helper()
+
+	recorder := ts.do(http.MethodPost, "/events", token, body)
+	expectStatus(t, recorder, http.StatusCreated)
+
+	var response struct{ Event models.Event }
+	decode(t, recorder, &response)
+	return response.Event
+}
+
+func TestCreateAndGetEvent(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.signUp(t, "owner@example.com")
+
+		event := ts.createTestEvent(t, token, eventJSON("Meetup", `"capacity": 2`))
+
+		recorder := ts.do(http.MethodGet, "/events/"+strconv.FormatInt(event.ID, 10), "", "")
+		expectStatus(t, recorder, http.StatusOK)
+
+		var stored models.Event
+		decode(t, recorder, &stored)
+		if stored.Name != "Meetup" || stored.Capacity == nil || *stored.Capacity != 2 {
+			t.Errorf("stored event = %+v, want Meetup with capacity 2", stored)
+		}
+	})
+}
+
+func TestCreateEventValidation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.signUp(t, "owner@example.com")
+
+		tests := []struct {
+			body string
+			want []string // Field and code of each error
+		}{
+			{`{"name": "No date", "capacity": 0}`, []string{"description required", "location required", "dateTime required", "capacity min"}},
+			{eventJSON("Bad rule", `"rrule": "FREQ=SOMETIMES"`), []string{"rrule invalid"}},
+			{eventJSON("Bad type", `"rrule": 7`), []string{"rrule type"}},
+		}
+
+		for _, test := range tests {
+			recorder := ts.do(http.MethodPost, "/events", token, test.body)
+			expectStatus(t, recorder, http.StatusBadRequest)
+
+			var details struct {
+				Errors []struct{ Field, Code string }
+			}
+			decode(t, recorder, &details)
+			var got []string
+			for _, fieldError := range details.Errors {
+				got = append(got, fieldError.Field+" "+fieldError.Code)
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("creating %s: errors %v, want %v", test.body, got, test.want)
+			}
+		}
+	})
+}
+
+func TestUpdateEventCapacity(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, ownerToken := ts.signUp(t, "owner@example.com")
+		event := ts.createTestEvent(t, ownerToken, eventJSON("Workshop", `"capacity": 1`))
+		path := "/events/" + strconv.FormatInt(event.ID, 10)
+
+		// One seat taken, one user waiting
+		for _, email := range []string{"first@example.com", "second@example.com"} {
+			_, token := ts.signUp(t, email)
+			expectStatus(t, ts.do(http.MethodPost, path+"/register", token, ""), http.StatusCreated)
+		}
+
+		capacity := func() *int64 {
+			t.Helper()
+			stored, err := ts.Events.GetByID(t.Context(), event.ID)
+			if err != nil {
+				t.Fatalf("GetByID: %v", err)
+			}
+			return stored.Capacity
+		}
+		waitlisted := func() int {
+			t.Helper()
+			page, err := ts.Registrations.ListAttendees(event, nil, models.RegistrationWaitlisted, models.PageRequest{Limit: 10})
+			if err != nil {
+				t.Fatalf("ListAttendees: %v", err)
+			}
+			return len(page.Items)
+		}
+
+		// Omitting the capacity keeps it, and nobody is promoted
+		expectStatus(t, ts.do(http.MethodPut, path, ownerToken, eventJSON("Renamed workshop")), http.StatusOK)
+		if c := capacity(); c == nil || *c != 1 {
+			t.Fatalf("capacity after update without it = %v, want 1", c)
+		}
+		if n := waitlisted(); n != 1 {
+			t.Fatalf("waitlisted after update without capacity = %d, want 1", n)
+		}
+
+		// An explicit null removes the limit and promotes the waitlist
+		expectStatus(t, ts.do(http.MethodPut, path, ownerToken, eventJSON("Open workshop", `"capacity": null`)), http.StatusOK)
+		if c := capacity(); c != nil {
+			t.Fatalf("capacity after null = %d, want unlimited", *c)
+		}
+		if n := waitlisted(); n != 0 {
+			t.Fatalf("waitlisted after removing the limit = %d, want 0", n)
+		}
+	})
+}
+
+func TestUpdateEventRequiresOwnership(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, ownerToken := ts.signUp(t, "owner@example.com")
+		_, otherToken := ts.signUp(t, "other@example.com")
+		_, adminToken := ts.signUp(t, "admin@example.com", models.RoleAdmin)
+
+		event := ts.createTestEvent(t, ownerToken, eventJSON("Private"))
+		path := "/events/" + strconv.FormatInt(event.ID, 10)
+
+		expectStatus(t, ts.do(http.MethodPut, path, otherToken, eventJSON("Taken over")), http.StatusUnauthorized)
+		expectStatus(t, ts.do(http.MethodPut, path, adminToken, eventJSON("Moderated")), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodDelete, path, otherToken, ""), http.StatusUnauthorized)
+		expectStatus(t, ts.do(http.MethodDelete, path, ownerToken, ""), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodGet, path, "", ""), http.StatusNotFound)
+	})
+}
+
+// seriesJSON is a weekly series of four occurrences from 2030-03-04, without the third
+func seriesJSON(name string, extra ...string) string {
+	fields := []string{
+		`"name": "` + name + `"`,
+		`"description": "A test series"`,
+		`"location": "Hall"`,
+		`"dateTime": "2030-03-04T18:00:00Z"`,
+	}
+	return "{" + strings.Join(append(fields, extra...), ", ") + "}"
+}
+
+// listOccurrences returns the occurrences in March 2030
+func (ts *testServer) listOccurrences(t *testing.T) []models.Occurrence {
+	t.Helper()
+
+	recorder := ts.do(http.MethodGet, "/events?expand=true&from=2030-03-01T00:00:00Z&to=2030-04-01T00:00:00Z", "", "")
+	expectStatus(t, recorder, http.StatusOK)
+
+	var page struct{ Data []models.Occurrence }
+	decode(t, recorder, &page)
+	return page.Data
+}
+
+func TestRecurringEventOccurrences(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.signUp(t, "owner@example.com")
+		event := ts.createTestEvent(t, token, seriesJSON("Book club", `"rrule": "FREQ=WEEKLY;COUNT=4"`, `"exDates": ["2030-03-18T18:00:00Z"]`))
+		path := "/events/" + strconv.FormatInt(event.ID, 10) + "/occurrences/"
+
+		occurrences := ts.listOccurrences(t)
+		if len(occurrences) != 3 {
+			t.Fatalf("listed %d occurrences, want 3 without the excluded one: %+v", len(occurrences), occurrences)
+		}
+
+		expectStatus(t, ts.do(http.MethodPut, path+"2030-03-11T18:00:00Z", token, `{"location": "Park"}`), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodDelete, path+"2030-03-25T18:00:00Z", token, ""), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodDelete, path+"2030-03-25T18:00:00Z", token, ""), http.StatusNotFound)
+		expectStatus(t, ts.do(http.MethodPut, path+"2030-03-18T18:00:00Z", token, `{"location": "Park"}`), http.StatusNotFound)
+
+		occurrences = ts.listOccurrences(t)
+		if len(occurrences) != 2 {
+			t.Fatalf("listed %d occurrences, want 2 after cancelling one: %+v", len(occurrences), occurrences)
+		}
+		if occurrences[0].Location != "Hall" || occurrences[0].Modified {
+			t.Errorf("first occurrence = %+v, want it unchanged", occurrences[0])
+		}
+		if occurrences[1].Location != "Park" || !occurrences[1].Modified {
+			t.Errorf("second occurrence = %+v, want it moved to the park", occurrences[1])
+		}
+	})
+}
+
+func TestUpdateEventKeepsRecurrence(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.signUp(t, "owner@example.com")
+		event := ts.createTestEvent(t, token, seriesJSON("Book club", `"rrule": "FREQ=WEEKLY;COUNT=4"`, `"exDates": ["2030-03-18T18:00:00Z"]`))
+		path := "/events/" + strconv.FormatInt(event.ID, 10)
+
+		stored := func() *models.Event {
+			t.Helper()
+			stored, err := ts.Events.GetByID(t.Context(), event.ID)
+			if err != nil {
+				t.Fatalf("GetByID: %v", err)
+			}
+			return stored
+		}
+
+		// Omitting the recurrence fields keeps the series as it is
+		expectStatus(t, ts.do(http.MethodPut, path, token, seriesJSON("Renamed book club")), http.StatusOK)
+		if e := stored(); e.RRule != "FREQ=WEEKLY;COUNT=4" || len(e.ExDates) != 1 {
+			t.Fatalf("after an update without recurrence fields: rrule %q, exDates %v", e.RRule, e.ExDates)
+		}
+		if n := len(ts.listOccurrences(t)); n != 3 {
+			t.Fatalf("listed %d occurrences after renaming, want 3", n)
+		}
+
+		// Removing the rule would leave its occurrences behind
+		expectStatus(t, ts.do(http.MethodPut, path, token, seriesJSON("Single book club", `"rrule": ""`)), http.StatusBadRequest)
+		if e := stored(); e.RRule == "" {
+			t.Fatal("the rule was removed")
+		}
+
+		// The fields still change when they are given
+		expectStatus(t, ts.do(http.MethodPut, path, token, seriesJSON("Book club", `"rrule": "FREQ=WEEKLY;COUNT=2"`, `"exDates": []`)), http.StatusOK)
+		if e := stored(); e.RRule != "FREQ=WEEKLY;COUNT=2" || len(e.ExDates) != 0 {
+			t.Errorf("after changing the recurrence fields: rrule %q, exDates %v", e.RRule, e.ExDates)
+		}
+		if n := len(ts.listOccurrences(t)); n != 2 {
+			t.Errorf("listed %d occurrences after shortening the series, want 2", n)
+		}
+	})
+}
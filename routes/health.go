@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/PaulFWatts/rest_api_golang/buildinfo"
+	"github.com/PaulFWatts/rest_api_golang/health"
+	"github.com/gin-gonic/gin"
+)
+
+// getLiveness handles GET /healthz - reports that the process is serving requests
+//
+// Liveness deliberately checks nothing else: a slow database should make the
+// instance not ready, not get it restarted.
+//
+// HTTP Method: GET
+// Endpoint: /healthz
+// Authentication: None
+//
+// Response Codes:
+//   - 200 OK: The process is alive
+//
+// Response Body:
+//
+//	{"status": "ok"}
+func getLiveness(context *gin.Context) {
+	context.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// getReadiness handles GET /readyz - reports whether the API can take requests
+//
+// The database must answer a ping, every migration must be applied and the
+// filesystem holding the database must have database.min_free_disk_mb free.
+// While the server drains for shutdown, the API is never ready.
+//
+// HTTP Method: GET
+// Endpoint: /readyz
+// Authentication: None
+//
+// Response Codes:
+//   - 200 OK: Every check passed
+//   - 503 Service Unavailable: A check failed or the server is shutting down
+//
+// Response Body:
+//
+//	{
+//	  "ready": true,
+//	  "checks": {
+//	    "database": {"status": "ok"},
+//	    "migrations": {"status": "ok", "pendingMigrations": 0},
+//	    "disk": {"status": "ok", "path": ".", "freeBytes": 52031946752, "minFreeBytes": 104857600}
+//	  }
+//	}
+func getReadiness(context *gin.Context) {
+	report := health.Readiness(context.Request.Context())
+
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	context.Header("Cache-Control", "no-store")
+	context.JSON(status, report)
+}
+
+// getVersion handles GET /version - describes the running build
+//
+// HTTP Method: GET
+// Endpoint: /version
+// Authentication: None
+//
+// Response Codes:
+//   - 200 OK: Build information returned
+//
+// Response Body:
+//
+//	{"version": "v1.4.0", "commit": "3f2c9e1...", "buildTime": "2025-01-31T09:12:44Z", "goVersion": "go1.24.4"}
+//
+// Builds without injected values report version "dev" and the commit,
+// commitTime and modified flag recorded by the Go toolchain.
+func getVersion(context *gin.Context) {
+	context.JSON(http.StatusOK, buildinfo.Get())
+}
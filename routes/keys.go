@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/PaulFWatts/rest_api_golang/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// getJWKS handles GET /.well-known/jwks.json - publishes the public token verification keys
+//
+// Other services use this key set to verify access tokens issued by this API
+// without sharing a secret. Each key is identified by the same kid that appears
+// in the header of the tokens it signed. HMAC keys are never published.
+//
+// HTTP Method: GET
+// Endpoint: /.well-known/jwks.json
+// Authentication: Not required
+//
+// Response Codes:
+//   - 200 OK: Key set returned (may be empty when only an HMAC key is configured)
+//
+// Response Body:
+//
+//	Success: {"keys": [{"kty": "RSA", "kid": "...", "use": "sig", "alg": "RS256", "n": "...", "e": "AQAB"}]}
+func getJWKS(context *gin.Context) {
+	context.Header("Cache-Control", "public, max-age=300")
+	context.JSON(http.StatusOK, utils.PublicJWKS())
+}
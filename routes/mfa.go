@@ -0,0 +1,323 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/PaulFWatts/rest_api_golang/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// mfaCodeRequest is the request body of endpoints that require a TOTP or recovery code
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// loginMFARequest is the request body accepted by /login/mfa
+type loginMFARequest struct {
+	MFAToken string `json:"mfaToken" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// loginMFA handles POST /login/mfa - completes a login with a TOTP or recovery code
+//
+// This is the second login step for accounts with two-factor authentication.
+// The MFA token returned by /login is exchanged, together with a code from the
+// user's authenticator app or one of their recovery codes, for an access token
+// and a refresh token.
+//
+// HTTP Method: POST
+// Endpoint: /login/mfa
+// Authentication: MFA token in request body
+//
+// Request Body: JSON object with required fields:
+//   - mfaToken (string): The token returned by /login
+//   - code (string): A 6-digit TOTP code or an unused recovery code
+//
+// Response Codes:
+//   - 200 OK: Login successful
+//   - 400 Bad Request: Invalid JSON request data
+//   - 401 Unauthorized: MFA token invalid or expired (5 minutes), or code wrong or already used
+//   - 429 Too Many Requests: Too many failed attempts; the Retry-After header gives the seconds to wait
+//   - 500 Internal Server Error: Database or token generation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Login successful!", "token": "...", "refreshToken": "..."}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) loginMFA(context *gin.Context) {
+	var request loginMFARequest
+	err := context.ShouldBindJSON(&request)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	userId, tokenVersion, err := utils.VerifyMFAToken(request.MFAToken)
+
+	if err != nil {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeInvalidToken, "Invalid or expired MFA token.")
+		return
+	}
+
+	user, err := s.Users.GetByID(userId)
+
+	// A password reset since the first step invalidates the MFA token too
+	if err != nil || user.TokenVersion != tokenVersion {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeInvalidToken, "Invalid or expired MFA token.")
+		return
+	}
+
+	// Wrong codes count towards the same lockout as wrong passwords
+	reservation, ok := s.reserveLogin(context, user.Email)
+	if !ok {
+		return
+	}
+
+	err = s.MFA.VerifySecondFactor(userId, request.Code)
+
+	if errors.Is(err, models.ErrMFACodeInvalid) || errors.Is(err, models.ErrMFANotEnabled) {
+		if s.recordLoginAttempt(context, reservation, models.LoginAttempt{Email: user.Email, UserID: &user.ID, Reason: models.LoginInvalidMFACode}) {
+			problem.Respond(context, http.StatusUnauthorized, problem.CodeInvalidMFACode, "Invalid code.")
+		}
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not verify code.", err)
+		return
+	}
+
+	if s.recordLoginAttempt(context, reservation, models.LoginAttempt{Email: user.Email, UserID: &user.ID, Success: true, Reason: models.LoginSucceeded}) {
+		s.issueTokens(context, user, "Login successful!")
+	}
+}
+
+// getMFAStatus handles GET /me/mfa - shows the user's two-factor authentication settings
+//
+// HTTP Method: GET
+// Endpoint: /me/mfa
+// Authentication: Required (JWT middleware validates Authorization header)
+//
+// Response Codes:
+//   - 200 OK: Status returned
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"enabled": true, "enabledAt": "2025-01-01T10:00:00Z", "recoveryCodesRemaining": 9}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) getMFAStatus(context *gin.Context) {
+	status, err := s.MFA.GetStatus(context.GetInt64("userId"))
+
+	if err != nil {
+		internalError(context, "Could not fetch two-factor status.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, status)
+}
+
+// beginTOTPEnrolment handles POST /me/mfa/totp - starts two-factor enrolment
+//
+// Returns a new TOTP secret and its otpauth:// provisioning URI, which the
+// client shows as a QR code for the user's authenticator app. Two-factor
+// authentication is enabled once a code is confirmed with POST /me/mfa/totp/confirm.
+// The issuer shown in authenticator apps is the MFA_ISSUER setting.
+//
+// HTTP Method: POST
+// Endpoint: /me/mfa/totp
+// Authentication: Required (JWT middleware validates Authorization header)
+//
+// Response Codes:
+//   - 201 Created: Secret generated
+//   - 409 Conflict: Two-factor authentication is already enabled
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "...", "secret": "JBSWY3DP...", "uri": "otpauth://totp/..."}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) beginTOTPEnrolment(context *gin.Context) {
+	userId := context.GetInt64("userId")
+
+	user, err := s.Users.GetByID(userId)
+
+	if err != nil {
+		internalError(context, "Could not start two-factor enrolment.", err)
+		return
+	}
+
+	secret, err := s.MFA.BeginTOTPEnrolment(userId)
+
+	if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+		problem.Respond(context, http.StatusConflict, problem.CodeMFAAlreadyEnabled, "Two-factor authentication is already enabled.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not start two-factor enrolment.", err)
+		return
+	}
+
+	context.JSON(http.StatusCreated, gin.H{
+		"message": "Add the secret to your authenticator app, then confirm a code.",
+		"secret":  secret,
+		"uri":     utils.TOTPProvisioningURI(s.App.MFAIssuer, user.Email, secret),
+	})
+}
+
+// confirmTOTPEnrolment handles POST /me/mfa/totp/confirm - enables two-factor authentication
+//
+// HTTP Method: POST
+// Endpoint: /me/mfa/totp/confirm
+// Authentication: Required (JWT middleware validates Authorization header)
+//
+// Request Body: JSON object with required fields:
+//   - code (string): A 6-digit code from the authenticator app
+//
+// Response Codes:
+//   - 200 OK: Two-factor authentication enabled; recovery codes returned
+//   - 400 Bad Request: Invalid JSON request data, code wrong, or enrolment not started
+//   - 409 Conflict: Two-factor authentication is already enabled
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Two-factor authentication enabled!", "recoveryCodes": ["abcde-fghij", ...]}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+//
+// Security Notes:
+//   - Recovery codes are stored hashed and shown only once; each works a single time
+func (s *Server) confirmTOTPEnrolment(context *gin.Context) {
+	var request mfaCodeRequest
+	err := context.ShouldBindJSON(&request)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	codes, err := s.MFA.ConfirmTOTPEnrolment(context.GetInt64("userId"), request.Code)
+
+	if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+		problem.Respond(context, http.StatusConflict, problem.CodeMFAAlreadyEnabled, "Two-factor authentication is already enabled.")
+		return
+	}
+
+	if errors.Is(err, models.ErrMFAEnrolmentNotStarted) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeMFAEnrolmentRequired, "Start two-factor enrolment first.")
+		return
+	}
+
+	if errors.Is(err, models.ErrMFACodeInvalid) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidMFACode, "Invalid code.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not enable two-factor authentication.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication enabled!", "recoveryCodes": codes})
+}
+
+// disableTOTP handles DELETE /me/mfa/totp - turns two-factor authentication off
+//
+// HTTP Method: DELETE
+// Endpoint: /me/mfa/totp
+// Authentication: Required (JWT middleware validates Authorization header)
+//
+// Request Body: JSON object with required fields:
+//   - code (string): A 6-digit TOTP code or an unused recovery code
+//
+// Response Codes:
+//   - 200 OK: Two-factor authentication disabled and recovery codes deleted
+//   - 400 Bad Request: Invalid JSON request data, or two-factor authentication is not enabled
+//   - 401 Unauthorized: Code wrong or already used
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Two-factor authentication disabled!"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) disableTOTP(context *gin.Context) {
+	var request mfaCodeRequest
+	err := context.ShouldBindJSON(&request)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	err = s.MFA.DisableTOTP(context.GetInt64("userId"), request.Code)
+
+	if errors.Is(err, models.ErrMFANotEnabled) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeMFANotEnabled, "Two-factor authentication is not enabled.")
+		return
+	}
+
+	if errors.Is(err, models.ErrMFACodeInvalid) {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeInvalidMFACode, "Invalid code.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not disable two-factor authentication.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled!"})
+}
+
+// regenerateRecoveryCodes handles POST /me/mfa/recovery-codes - replaces all recovery codes
+//
+// HTTP Method: POST
+// Endpoint: /me/mfa/recovery-codes
+// Authentication: Required (JWT middleware validates Authorization header)
+//
+// Request Body: JSON object with required fields:
+//   - code (string): A 6-digit TOTP code or an unused recovery code
+//
+// Response Codes:
+//   - 200 OK: New recovery codes returned; the old ones no longer work
+//   - 400 Bad Request: Invalid JSON request data, or two-factor authentication is not enabled
+//   - 401 Unauthorized: Code wrong or already used
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Recovery codes replaced!", "recoveryCodes": ["abcde-fghij", ...]}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) regenerateRecoveryCodes(context *gin.Context) {
+	var request mfaCodeRequest
+	err := context.ShouldBindJSON(&request)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	codes, err := s.MFA.RegenerateRecoveryCodes(context.GetInt64("userId"), request.Code)
+
+	if errors.Is(err, models.ErrMFANotEnabled) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeMFANotEnabled, "Two-factor authentication is not enabled.")
+		return
+	}
+
+	if errors.Is(err, models.ErrMFACodeInvalid) {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeInvalidMFACode, "Invalid code.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not replace recovery codes.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Recovery codes replaced!", "recoveryCodes": codes})
+}
@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// authenticatorCode returns the code an authenticator app shows for the secret at the time (RFC 6238)
+func authenticatorCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decoding TOTP secret %q: %v", secret, err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(at.Unix()/30))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1_000_000)
+}
+
+// enableMFA enrols the user in two-factor authentication and returns the TOTP secret and recovery codes
+func (ts *testServer) enableMFA(t *testing.T, token string) (string, []string) {
+	t.Helper()
+
+	recorder := ts.do(http.MethodPost, "/me/mfa/totp", token, "")
+	expectStatus(t, recorder, http.StatusCreated)
+	var enrolment struct{ Secret string }
+	decode(t, recorder, &enrolment)
+
+	code := authenticatorCode(t, enrolment.Secret, time.Now())
+	recorder = ts.do(http.MethodPost, "/me/mfa/totp/confirm", token, `{"code": "`+code+`"}`)
+	expectStatus(t, recorder, http.StatusOK)
+	var confirmation struct{ RecoveryCodes []string }
+	decode(t, recorder, &confirmation)
+
+	return enrolment.Secret, confirmation.RecoveryCodes
+}
+
+func TestMFAEnrolment(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.signUp(t, "ada@example.com")
+
+		expectStatus(t, ts.do(http.MethodPost, "/me/mfa/totp/confirm", token, `{"code": "123456"}`), http.StatusBadRequest)
+
+		_, codes := ts.enableMFA(t, token)
+		if len(codes) == 0 {
+			t.Fatal("no recovery codes returned")
+		}
+
+		expectStatus(t, ts.do(http.MethodPost, "/me/mfa/totp", token, ""), http.StatusConflict)
+
+		recorder := ts.do(http.MethodGet, "/me/mfa", token, "")
+		expectStatus(t, recorder, http.StatusOK)
+		var status struct {
+			Enabled                bool
+			RecoveryCodesRemaining int
+		}
+		decode(t, recorder, &status)
+		if !status.Enabled || status.RecoveryCodesRemaining != len(codes) {
+			t.Errorf("status = %+v, want enabled with %d recovery codes", status, len(codes))
+		}
+
+		expectStatus(t, ts.do(http.MethodDelete, "/me/mfa/totp", token, `{"code": "000000"}`), http.StatusUnauthorized)
+		expectStatus(t, ts.do(http.MethodDelete, "/me/mfa/totp", token, `{"code": "`+codes[0]+`"}`), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodDelete, "/me/mfa/totp", token, `{"code": "`+codes[1]+`"}`), http.StatusBadRequest)
+	})
+}
+
+func TestLoginWithMFA(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.signUp(t, "ada@example.com")
+		secret, codes := ts.enableMFA(t, token)
+
+		login := func() string {
+			t.Helper()
+
+			recorder := ts.do(http.MethodPost, "/login", "", `{"email": "ada@example.com", "password": "`+testPassword+`"}`)
+			expectStatus(t, recorder, http.StatusOK)
+			var response struct {
+				MFARequired bool
+				MFAToken    string
+				Token       string
+			}
+			decode(t, recorder, &response)
+			if !response.MFARequired || response.MFAToken == "" || response.Token != "" {
+				t.Fatalf("login response = %s, want only an MFA token", recorder.Body)
+			}
+			return response.MFAToken
+		}
+
+		mfaToken := login()
+		expectStatus(t, ts.do(http.MethodPost, "/login/mfa", "", `{"mfaToken": "`+mfaToken+`", "code": "000000"}`), http.StatusUnauthorized)
+
+		// The enrolment used up the current step's code, so take the next one, which is within the allowed drift
+		code := authenticatorCode(t, secret, time.Now().Add(30*time.Second))
+		recorder := ts.do(http.MethodPost, "/login/mfa", "", `{"mfaToken": "`+mfaToken+`", "code": "`+code+`"}`)
+		expectStatus(t, recorder, http.StatusOK)
+		var tokens tokenPair
+		decode(t, recorder, &tokens)
+		if tokens.Token == "" || tokens.RefreshToken == "" {
+			t.Fatalf("MFA login response without tokens: %s", recorder.Body)
+		}
+
+		// A TOTP code works once
+		mfaToken = login()
+		expectStatus(t, ts.do(http.MethodPost, "/login/mfa", "", `{"mfaToken": "`+mfaToken+`", "code": "`+code+`"}`), http.StatusUnauthorized)
+
+		// So does a recovery code
+		expectStatus(t, ts.do(http.MethodPost, "/login/mfa", "", `{"mfaToken": "`+mfaToken+`", "code": "`+codes[0]+`"}`), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodPost, "/login/mfa", "", `{"mfaToken": "`+mfaToken+`", "code": "`+codes[0]+`"}`), http.StatusUnauthorized)
+	})
+}
+
+func TestRegenerateRecoveryCodes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.signUp(t, "ada@example.com")
+		_, codes := ts.enableMFA(t, token)
+
+		recorder := ts.do(http.MethodPost, "/me/mfa/recovery-codes", token, `{"code": "`+codes[0]+`"}`)
+		expectStatus(t, recorder, http.StatusOK)
+		var response struct{ RecoveryCodes []string }
+		decode(t, recorder, &response)
+
+		// The old codes stopped working
+		expectStatus(t, ts.do(http.MethodPost, "/me/mfa/recovery-codes", token, `{"code": "`+codes[1]+`"}`), http.StatusUnauthorized)
+		expectStatus(t, ts.do(http.MethodPost, "/me/mfa/recovery-codes", token, `{"code": "`+response.RecoveryCodes[0]+`"}`), http.StatusOK)
+	})
+}
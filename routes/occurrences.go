@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/middlewares"
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// parseOccurrenceQuery reads the optional occurrence query parameter, the
+// original start of one occurrence of a recurring event
+func parseOccurrenceQuery(context *gin.Context) (*time.Time, error) {
+	raw := context.Query("occurrence")
+	if raw == "" {
+		return nil, nil
+	}
+
+	occurrence, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &occurrence, nil
+}
+
+// updateOccurrence handles PUT /events/:id/occurrences/:start - changes one occurrence of a recurring event
+//
+// This handler overrides the name, description, location or start of a single
+// occurrence without changing the rest of the series. Fields left out of the
+// request keep the series' value, and each request replaces earlier changes to
+// the same occurrence. Registrations stay with the occurrence when it is rescheduled.
+//
+// HTTP Method: PUT
+// Endpoint: /events/:id/occurrences/:start
+// Authentication: Required (JWT middleware validates Authorization header)
+// Permissions: "events:update:own" or "events:update:any"
+// URL Parameters:
+//   - id (integer): The unique event identifier
+//   - start (RFC 3339): Original start of the occurrence, e.g. 2025-01-08T18:00:00Z
+//
+// Request Body: JSON object with optional fields:
+//   - name, description, location (string): Values for this occurrence only
+//   - dateTime (string): New start for this occurrence only
+//
+// Response Codes:
+//   - 200 OK: Occurrence successfully updated
+//   - 400 Bad Request: Invalid event ID, start or JSON request data
+//   - 401 Unauthorized: User not authorized to update this event (not owner, no "events:update:any")
+//   - 403 Forbidden: User holds neither update permission (handled by middleware)
+//   - 404 Not Found: No event has the ID, the event does not recur at start, or the occurrence was cancelled
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Occurrence updated!", "occurrence": {...}}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) updateOccurrence(context *gin.Context) {
+	event, start, ok := s.occurrenceEvent(context, models.PermissionEventsUpdateAny)
+	if !ok {
+		return
+	}
+
+	var changes models.OccurrenceChanges
+	err := context.ShouldBindJSON(&changes)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	occurrence, err := s.Events.UpdateOccurrence(*event, start, changes)
+
+	if errors.Is(err, models.ErrNoSuchOccurrence) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Occurrence not found.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not update occurrence.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Occurrence updated!", "occurrence": occurrence})
+}
+
+// cancelOccurrence handles DELETE /events/:id/occurrences/:start - cancels one occurrence of a recurring event
+//
+// This handler removes a single occurrence from the series along with its
+// registrations and waitlist. The rest of the series is unchanged.
+//
+// HTTP Method: DELETE
+// Endpoint: /events/:id/occurrences/:start
+// Authentication: Required (JWT middleware validates Authorization header)
+// Permissions: "events:update:own" or "events:update:any"
+// URL Parameters:
+//   - id (integer): The unique event identifier
+//   - start (RFC 3339): Original start of the occurrence, e.g. 2025-01-08T18:00:00Z
+//
+// Response Codes:
+//   - 200 OK: Occurrence successfully cancelled
+//   - 400 Bad Request: Invalid event ID or start
+//   - 401 Unauthorized: User not authorized to update this event (not owner, no "events:update:any")
+//   - 403 Forbidden: User holds neither update permission (handled by middleware)
+//   - 404 Not Found: No event has the ID, the event does not recur at start, or the occurrence was already cancelled
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Occurrence cancelled!"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) cancelOccurrence(context *gin.Context) {
+	event, start, ok := s.occurrenceEvent(context, models.PermissionEventsUpdateAny)
+	if !ok {
+		return
+	}
+
+	err := s.Events.CancelOccurrence(*event, start)
+
+	if errors.Is(err, models.ErrNoSuchOccurrence) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Occurrence not found.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not cancel occurrence.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Occurrence cancelled!"})
+}
+
+// occurrenceEvent parses the event ID and occurrence start from the URL and
+// checks that the user owns the event or holds anyPermission. It writes the
+// error response and returns false if any check fails.
+func (s *Server) occurrenceEvent(context *gin.Context, anyPermission string) (*models.Event, time.Time, bool) {
+	eventId, err := strconv.ParseInt(context.Param("id"), 10, 64)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse event id.")
+		return nil, time.Time{}, false
+	}
+
+	start, err := time.Parse(time.RFC3339, context.Param("start"))
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse occurrence start.")
+		return nil, time.Time{}, false
+	}
+
+	event, err := s.Events.GetByID(context.Request.Context(), eventId)
+
+	if errors.Is(err, models.ErrEventNotFound) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Event not found.")
+		return nil, time.Time{}, false
+	}
+	if err != nil {
+		internalError(context, "Could not fetch the event.", err)
+		return nil, time.Time{}, false
+	}
+
+	if event.UserID != context.GetInt64("userId") && !middlewares.HasPermission(context, anyPermission) {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeNotOwner, "Not authorized to update this event.")
+		return nil, time.Time{}, false
+	}
+
+	return event, start, true
+}
@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/gin-gonic/gin"
+)
+
+// pageResponse is the envelope returned by every paginated list endpoint
+//
+// Next and Prev are links to the neighbouring pages that keep every other
+// query parameter of the current request, or null at either end of the list.
+type pageResponse struct {
+	Data  any     `json:"data"`
+	Total int     `json:"total"`
+	Limit int     `json:"limit"`
+	Next  *string `json:"next"`
+	Prev  *string `json:"prev"`
+}
+
+// parsePageRequest reads the limit, cursor and sort query parameters
+func parsePageRequest(context *gin.Context) (models.PageRequest, error) {
+	request := models.PageRequest{
+		Cursor: context.Query("cursor"),
+		Sort:   context.Query("sort"),
+	}
+
+	if raw := context.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return request, errors.New("invalid limit")
+		}
+		request.Limit = limit
+	}
+
+	return request, nil
+}
+
+// newPageResponse wraps a page in the list envelope and builds its links
+func newPageResponse[T any](context *gin.Context, page *models.Page[T]) pageResponse {
+	return pageResponse{
+		Data:  page.Items,
+		Total: page.Total,
+		Limit: page.Limit,
+		Next:  pageLink(context, page.Next),
+		Prev:  pageLink(context, page.Prev),
+	}
+}
+
+func pageLink(context *gin.Context, cursor string) *string {
+	if cursor == "" {
+		return nil
+	}
+
+	query := context.Request.URL.Query()
+	query.Set("cursor", cursor)
+	link := context.Request.URL.Path + "?" + query.Encode()
+	return &link
+}
+
+// isPageError reports whether err was caused by invalid pagination parameters
+func isPageError(err error) bool {
+	return errors.Is(err, models.ErrInvalidCursor) || errors.Is(err, models.ErrInvalidSort)
+}
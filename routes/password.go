@@ -0,0 +1,155 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PaulFWatts/rest_api_golang/mailer"
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// forgotPasswordRequest is the request body accepted by /password/forgot
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// resetPasswordRequest is the request body accepted by /password/reset
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// forgotPassword handles POST /password/forgot - emails a password reset token
+//
+// This handler issues a single-use reset token valid for one hour and emails
+// it to the address stored for the account, which may differ from the one in
+// the request. If PASSWORD_RESET_URL is set (for example the reset page of a
+// web app), the email contains that URL with the token set as its "token"
+// query parameter.
+//
+// HTTP Method: POST
+// Endpoint: /password/forgot
+// Authentication: Not required
+//
+// Request Body: JSON object with required fields:
+//   - email (string): The account's email address
+//
+// Response Codes:
+//   - 202 Accepted: Request accepted (also returned when no account has that email)
+//   - 400 Bad Request: Invalid JSON request data
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "If an account exists for that email, a reset link has been sent."}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+//
+// Security Notes:
+//   - The response never reveals whether an account exists
+//   - The email is sent in the background so response times do not reveal it either
+//   - Only the SHA-256 hash of the token is stored
+func (s *Server) forgotPassword(context *gin.Context) {
+	var request forgotPasswordRequest
+	err := context.ShouldBindJSON(&request)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	token, address, err := s.PasswordResets.Create(request.Email)
+
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		internalError(context, "Could not reset password. Try again later.", err)
+		return
+	}
+
+	if err == nil {
+		message, err := s.passwordResetMessage(address, token)
+
+		if err != nil {
+			internalError(context, "Could not reset password. Try again later.", err)
+			return
+		}
+
+		mailer.SendInBackground(message, "password reset email")
+	}
+
+	context.JSON(http.StatusAccepted, gin.H{"message": "If an account exists for that email, a reset link has been sent."})
+}
+
+// resetPassword handles POST /password/reset - sets a new password with a reset token
+//
+// A successful reset signs the user out everywhere: every refresh token and
+// the calendar feed token are revoked, and access tokens issued before the
+// reset are rejected by the authentication middleware.
+//
+// HTTP Method: POST
+// Endpoint: /password/reset
+// Authentication: Password reset token in request body
+//
+// Request Body: JSON object with required fields:
+//   - token (string): The token from the password reset email
+//   - password (string): The new password
+//
+// Response Codes:
+//   - 200 OK: Password changed
+//   - 400 Bad Request: Invalid JSON request data, token unknown, expired or already used,
+//     or the new password does not meet the password policy
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Password reset! Log in with your new password."}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) resetPassword(context *gin.Context) {
+	var request resetPasswordRequest
+	err := context.ShouldBindJSON(&request)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	err = s.PasswordResets.Reset(context.Request.Context(), request.Token, request.Password)
+
+	if errors.Is(err, models.ErrResetTokenInvalid) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired reset token.")
+		return
+	}
+
+	var policyErr *models.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		problem.Write(context, problem.Invalid(problem.FieldError{Field: "password", Code: "policy", Detail: policyErr.Message}))
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not reset password. Try again later.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Password reset! Log in with your new password."})
+}
+
+// passwordResetMessage builds the email that delivers a password reset token
+// to the account's stored address
+func (s *Server) passwordResetMessage(address string, token string) (mailer.Message, error) {
+	instructions := fmt.Sprintf("Send this token to POST /password/reset with your new password:\n\n%s", token)
+	if base := s.App.PasswordResetURL; base != "" {
+		link, err := withToken(base, token)
+		if err != nil {
+			return mailer.Message{}, err
+		}
+		instructions = fmt.Sprintf("Choose a new password here:\n\n%s", link)
+	}
+
+	body := fmt.Sprintf("Someone asked to reset the password for your account.\n\n%s\n\n"+
+		"This expires in %d minutes and works once. If this wasn't you, ignore this email.\n",
+		instructions, int(models.PasswordResetTTL.Minutes()))
+
+	return mailer.Message{To: address, Subject: "Reset your password", Body: body}, nil
+}
@@ -0,0 +1,88 @@
+package routes
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPasswordReset(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		memory := useMemoryMailer(t)
+		_, accessToken := ts.signUp(t, "ada@example.com")
+		tokens := ts.logIn(t, "ada@example.com", testPassword)
+
+		// Unknown addresses get the same answer, and no email
+		expectStatus(t, ts.do(http.MethodPost, "/password/forgot", "", `{"email": "nobody@example.com"}`), http.StatusAccepted)
+		expectStatus(t, ts.do(http.MethodPost, "/password/forgot", "", `{"email": "ada@example.com"}`), http.StatusAccepted)
+
+		messages := sentMessages(t, memory)
+		if len(messages) != 1 || messages[0].To != "ada@example.com" {
+			t.Fatalf("sent %+v, want one email to ada@example.com", messages)
+		}
+		resetToken := strings.TrimSpace(strings.Split(messages[0].Body, "\n\n")[2])
+
+		// The policy is checked, and a rejected password does not use up the token
+		expectStatus(t, ts.do(http.MethodPost, "/password/reset", "", `{"token": "`+resetToken+`", "password": "short"}`), http.StatusBadRequest)
+
+		newPassword := "N3w&" + testPassword
+		expectStatus(t, ts.do(http.MethodPost, "/password/reset", "", `{"token": "`+resetToken+`", "password": "`+newPassword+`"}`), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodPost, "/password/reset", "", `{"token": "`+resetToken+`", "password": "`+newPassword+`"}`), http.StatusBadRequest)
+
+		// The reset signed the user out everywhere
+		expectStatus(t, ts.do(http.MethodGet, "/me/mfa", accessToken, ""), http.StatusUnauthorized)
+		expectStatus(t, ts.do(http.MethodPost, "/token/refresh", "", `{"refreshToken": "`+tokens.RefreshToken+`"}`), http.StatusUnauthorized)
+
+		expectStatus(t, ts.do(http.MethodPost, "/login", "", `{"email": "ada@example.com", "password": "`+testPassword+`"}`), http.StatusUnauthorized)
+		ts.logIn(t, "ada@example.com", newPassword)
+	})
+}
+
+func TestEmailedLinksKeepTheConfiguredQuery(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		memory := useMemoryMailer(t)
+		ts.App.VerifyEmailURL = "https://app.example.com/verify?lang=en"
+		ts.App.PasswordResetURL = "https://app.example.com/reset?lang=en&token=stale"
+
+		expectStatus(t, ts.do(http.MethodPost, "/signup", "", `{"email": "ada@example.com", "password": "`+testPassword+`"}`), http.StatusCreated)
+		sentMessages(t, memory)
+		// The reset email goes to the stored address, whatever the case of the requested one
+		expectStatus(t, ts.do(http.MethodPost, "/password/forgot", "", `{"email": "ADA@Example.com"}`), http.StatusAccepted)
+
+		messages := sentMessages(t, memory)
+		if len(messages) != 2 {
+			t.Fatalf("sent %d emails, want a verification and a reset email", len(messages))
+		}
+		for i, want := range []string{"https://app.example.com/verify", "https://app.example.com/reset"} {
+			if messages[i].To != "ada@example.com" {
+				t.Errorf("%q went to %q, want ada@example.com", messages[i].Subject, messages[i].To)
+			}
+
+			link := emailedLink(t, messages[i].Body)
+			if link.Scheme+"://"+link.Host+link.Path != want {
+				t.Errorf("%q links to %s, want %s", messages[i].Subject, link, want)
+			}
+			if query := link.Query(); query.Get("lang") != "en" || len(query["token"]) != 1 || query.Get("token") == "stale" {
+				t.Errorf("%q link query = %v, want lang=en and a single new token", messages[i].Subject, query)
+			}
+		}
+	})
+}
+
+// emailedLink returns the first URL in an email body
+func emailedLink(t *testing.T, body string) *url.URL {
+	t.Helper()
+
+	for _, field := range strings.Fields(body) {
+		if strings.HasPrefix(field, "https://") {
+			link, err := url.Parse(field)
+			if err != nil {
+				t.Fatalf("parsing %q: %v", field, err)
+			}
+			return link
+		}
+	}
+	t.Fatalf("no link in %q", body)
+	return nil
+}
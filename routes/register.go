@@ -1,10 +1,12 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
 	"github.com/gin-gonic/gin"
 )
 
@@ -12,92 +14,330 @@ import (
 //
 // This handler allows authenticated users to register for events they don't own.
 // It validates the event exists, then creates a registration record in the database.
+// If the event has a capacity and every seat is taken, the user is added to the
+// end of the waitlist instead and promoted automatically when a seat frees up.
+//
+// Each occurrence of a recurring event has its own seats and waitlist, so
+// registering for a recurring event requires the occurrence query parameter.
 //
 // HTTP Method: POST
 // Endpoint: /events/:id/register
 // Authentication: Required (JWT middleware validates Authorization header)
+// Permissions: "events:register"
 // URL Parameters: id (integer) - The unique event identifier
+// Query Parameters: occurrence (RFC 3339) - Original start of the occurrence, for recurring events only
 //
 // Response Codes:
-//   - 201 Created: Successfully registered for event
-//   - 400 Bad Request: Invalid event ID format
-//   - 500 Internal Server Error: Event not found or database operation failed
+//   - 201 Created: Successfully registered for event or added to the waitlist
+//   - 400 Bad Request: Invalid event ID or occurrence, or occurrence missing for a recurring event
+//   - 404 Not Found: No event has the ID, the occurrence is not part of the event or was cancelled
+//   - 409 Conflict: User is already registered or waitlisted
+//   - 500 Internal Server Error: Database operation failed
 //
 // Response Body:
 //
-//	Success: {"message": "Registered!"}
-//	Error: {"message": "error description"}
+//	Success: {"message": "Registered!", "registration": {"Status": "registered", "Position": 3, ...}}
+//	Success: {"message": "Added to the waitlist!", "registration": {"Status": "waitlisted", "Position": 1, ...}}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
 //
 // Security Notes:
 //   - User ID extracted from JWT token via middleware
 //   - Validates event exists before allowing registration
 //   - Creates many-to-many relationship in registrations table
-func registerForEvent(context *gin.Context) {
+func (s *Server) registerForEvent(context *gin.Context) {
 	userId := context.GetInt64("userId")
 	eventId, err := strconv.ParseInt(context.Param("id"), 10, 64)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse event id."})
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse event id.")
 		return
 	}
 
-	event, err := models.GetEventByID(eventId)
+	occurrence, err := parseOccurrenceQuery(context)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse occurrence.")
+		return
+	}
 
+	event, err := s.Events.GetByID(context.Request.Context(), eventId)
+
+	if errors.Is(err, models.ErrEventNotFound) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Event not found.")
+		return
+	}
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not fetch event."})
+		internalError(context, "Could not fetch event.", err)
 		return
 	}
 
-	err = event.Register(userId)
+	registration, err := s.Registrations.Register(context.Request.Context(), *event, userId, occurrence)
+
+	if errors.Is(err, models.ErrOccurrenceRequired) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeOccurrenceRequired, "Choose an occurrence to register for this recurring event.")
+		return
+	}
+
+	if errors.Is(err, models.ErrNoSuchOccurrence) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Occurrence not found.")
+		return
+	}
+
+	if errors.Is(err, models.ErrAlreadyRegistered) {
+		problem.Respond(context, http.StatusConflict, problem.CodeAlreadyRegistered, "Already registered for this event.")
+		return
+	}
 
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not register user for event."})
+		internalError(context, "Could not register user for event.", err)
 		return
 	}
 
-	context.JSON(http.StatusCreated, gin.H{"message": "Registered!"})
+	message := "Registered!"
+	if registration.Status == models.RegistrationWaitlisted {
+		message = "Added to the waitlist!"
+	}
+
+	context.JSON(http.StatusCreated, gin.H{"message": message, "registration": registration})
+}
+
+// getRegistrationStatus handles GET /events/:id/register - shows the user's registration status
+//
+// This handler returns whether the authenticated user holds a seat or is on the
+// waitlist, and their position: the seat order for registered users, or the
+// place in the queue for waitlisted users.
+//
+// HTTP Method: GET
+// Endpoint: /events/:id/register
+// Authentication: Required (JWT middleware validates Authorization header)
+// URL Parameters: id (integer) - The unique event identifier
+// Query Parameters: occurrence (RFC 3339) - Original start of the occurrence, for recurring events only
+//
+// Response Codes:
+//   - 200 OK: Registration found
+//   - 400 Bad Request: Invalid event ID or occurrence
+//   - 404 Not Found: User is not registered or waitlisted for this event
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"registration": {"EventID": 1, "UserID": 2, "Status": "waitlisted", "Position": 4, "CreatedAt": "..."}}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) getRegistrationStatus(context *gin.Context) {
+	userId := context.GetInt64("userId")
+	eventId, err := strconv.ParseInt(context.Param("id"), 10, 64)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse event id.")
+		return
+	}
+
+	occurrence, err := parseOccurrenceQuery(context)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse occurrence.")
+		return
+	}
+
+	event := models.Event{ID: eventId}
+	registration, err := s.Registrations.Get(event, userId, occurrence)
+
+	if errors.Is(err, models.ErrNotRegistered) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotRegistered, "Not registered for this event.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not fetch registration.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"registration": registration})
 }
 
 // cancelRegistration handles DELETE /events/:id/register - cancels user's event registration
 //
-// This handler allows authenticated users to cancel their registration for events.
-// It removes the registration record from the database without requiring event ownership.
+// This handler allows authenticated users to cancel their registration for events,
+// or leave the waitlist. It removes the registration record from the database
+// without requiring event ownership. A freed seat goes to the first waitlisted user.
 //
 // HTTP Method: DELETE
 // Endpoint: /events/:id/register
 // Authentication: Required (JWT middleware validates Authorization header)
 // URL Parameters: id (integer) - The unique event identifier
+// Query Parameters: occurrence (RFC 3339) - Original start of the occurrence, for recurring events only
 //
 // Response Codes:
 //   - 200 OK: Successfully cancelled registration
-//   - 400 Bad Request: Invalid event ID format
+//   - 400 Bad Request: Invalid event ID or occurrence
+//   - 404 Not Found: User is not registered or waitlisted for this event
 //   - 500 Internal Server Error: Database operation failed
 //
 // Response Body:
 //
 //	Success: {"message": "Cancelled!"}
-//	Error: {"message": "error description"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
 //
 // Security Notes:
 //   - User ID extracted from JWT token via middleware
 //   - Only removes registration for the authenticated user
 //   - Does not require event ownership validation
-func cancelRegistration(context *gin.Context) {
+func (s *Server) cancelRegistration(context *gin.Context) {
 	userId := context.GetInt64("userId")
 	eventId, err := strconv.ParseInt(context.Param("id"), 10, 64)
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse event id."})
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse event id.")
+		return
+	}
+
+	occurrence, err := parseOccurrenceQuery(context)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse occurrence.")
 		return
 	}
 
 	var event models.Event
 	event.ID = eventId
 
-	err = event.CancelRegistration(userId)
+	err = s.Registrations.Cancel(event, userId, occurrence)
+
+	if errors.Is(err, models.ErrNotRegistered) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotRegistered, "Not registered for this event.")
+		return
+	}
 
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not cancel registration."})
+		internalError(context, "Could not cancel registration.", err)
 		return
 	}
 
 	context.JSON(http.StatusOK, gin.H{"message": "Cancelled!"})
 }
+
+// getEventRegistrations handles GET /events/:id/registrations - lists an event's attendees
+//
+// This handler returns a page of the users registered or waitlisted for an
+// event, with their email address and when they registered. Attendee emails
+// are personal data, so only the event owner may list them.
+//
+// HTTP Method: GET
+// Endpoint: /events/:id/registrations
+// Authentication: Required (JWT middleware validates Authorization header)
+// URL Parameters: id (integer) - The unique event identifier
+//
+// Query Parameters (all optional):
+//   - occurrence (RFC 3339): Only attendees of this occurrence of a recurring event
+//   - status (string): "registered" or "waitlisted"; both when omitted
+//   - sort (string): "createdAt" (default) or "email"; prefix with "-" for descending
+//   - limit (integer): Page size, default 20, maximum 100
+//   - cursor (string): Opaque cursor taken from a next/prev link
+//
+// Response Codes:
+//   - 200 OK: Successfully retrieved attendees
+//   - 400 Bad Request: Invalid event ID, occurrence, status, sort, limit or cursor
+//   - 401 Unauthorized: User is not the event owner
+//   - 404 Not Found: No event has the ID
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"data": [{"ID": 7, "Occurrence": null, "UserID": 2, "Email": "...", "Status": "registered", "CreatedAt": "..."}], "total": 12, "limit": 20, "next": "...", "prev": null}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+//
+// Security Notes:
+//   - Ownership is checked against the user ID from the JWT token
+func (s *Server) getEventRegistrations(context *gin.Context) {
+	userId := context.GetInt64("userId")
+	eventId, err := strconv.ParseInt(context.Param("id"), 10, 64)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse event id.")
+		return
+	}
+
+	status := context.Query("status")
+	if status != "" && status != models.RegistrationRegistered && status != models.RegistrationWaitlisted {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse query parameters.")
+		return
+	}
+
+	occurrence, err := parseOccurrenceQuery(context)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse query parameters.")
+		return
+	}
+
+	pageRequest, err := parsePageRequest(context)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse query parameters.")
+		return
+	}
+
+	event, err := s.Events.GetByID(context.Request.Context(), eventId)
+
+	if errors.Is(err, models.ErrEventNotFound) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Event not found.")
+		return
+	}
+	if err != nil {
+		internalError(context, "Could not fetch event.", err)
+		return
+	}
+
+	if event.UserID != userId {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeNotOwner, "Not authorized to view registrations for this event.")
+		return
+	}
+
+	page, err := s.Registrations.ListAttendees(*event, occurrence, status, pageRequest)
+	if isPageError(err) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid sort or cursor.")
+		return
+	}
+	if err != nil {
+		internalError(context, "Could not fetch registrations. Try again later.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, newPageResponse(context, page))
+}
+
+// getMyRegistrations handles GET /me/registrations - lists the user's registrations
+//
+// This handler returns a page of the events the authenticated user is
+// registered or waitlisted for, with their status and position at each event.
+//
+// HTTP Method: GET
+// Endpoint: /me/registrations
+// Authentication: Required (JWT middleware validates Authorization header)
+//
+// Query Parameters (all optional):
+//   - sort (string): "createdAt" (default) or "dateTime"; prefix with "-" for descending
+//   - limit (integer): Page size, default 20, maximum 100
+//   - cursor (string): Opaque cursor taken from a next/prev link
+//
+// Response Codes:
+//   - 200 OK: Successfully retrieved registrations
+//   - 400 Bad Request: Invalid sort, limit or cursor
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"data": [{"ID": 7, "Event": {...}, "Occurrence": null, "Status": "waitlisted", "Position": 2, "CreatedAt": "..."}], "total": 3, "limit": 20, "next": null, "prev": null}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) getMyRegistrations(context *gin.Context) {
+	userId := context.GetInt64("userId")
+
+	pageRequest, err := parsePageRequest(context)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse query parameters.")
+		return
+	}
+
+	page, err := s.Registrations.ListForUser(userId, pageRequest)
+	if isPageError(err) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid sort or cursor.")
+		return
+	}
+	if err != nil {
+		internalError(context, "Could not fetch registrations. Try again later.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, newPageResponse(context, page))
+}
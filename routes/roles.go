@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// setRolesRequest is the request body accepted by PUT /users/:id/roles
+type setRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
+
+// setUserRoles handles PUT /users/:id/roles - replaces the roles of a user
+//
+// This handler lets administrators appoint other administrators or remove
+// roles. The user's new permissions are included in their next access token,
+// which they receive on their next login or token refresh.
+//
+// HTTP Method: PUT
+// Endpoint: /users/:id/roles
+// Authentication: Required (JWT middleware validates Authorization header)
+// Permissions: "users:roles:manage"
+// URL Parameters: id (integer) - The unique user identifier
+//
+// Request Body: JSON object with required fields:
+//   - roles (array of strings): The complete list of role names, e.g. ["user", "admin"]
+//
+// Response Codes:
+//   - 200 OK: Roles replaced
+//   - 400 Bad Request: Invalid user ID, JSON request data or unknown role
+//   - 403 Forbidden: Missing "users:roles:manage" permission (handled by middleware)
+//   - 404 Not Found: User does not exist
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Roles updated!", "roles": [...], "permissions": [...]}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) setUserRoles(context *gin.Context) {
+	userId, err := strconv.ParseInt(context.Param("id"), 10, 64)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse user id.")
+		return
+	}
+
+	var request setRolesRequest
+	err = context.ShouldBindJSON(&request)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	user, err := s.Users.GetByID(userId)
+
+	if errors.Is(err, models.ErrUserNotFound) {
+		problem.Respond(context, http.StatusNotFound, problem.CodeNotFound, "Could not find user.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not fetch user.", err)
+		return
+	}
+
+	err = s.Users.SetRoles(user.ID, request.Roles)
+
+	if errors.Is(err, models.ErrUnknownRole) {
+		problem.Write(context, problem.Invalid(problem.FieldError{Field: "roles", Code: "unknown_role"}))
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not update roles.", err)
+		return
+	}
+
+	err = s.Users.LoadRoles(user)
+
+	if err != nil {
+		internalError(context, "Could not load roles.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Roles updated!", "roles": user.Roles, "permissions": user.Permissions})
+}
@@ -1,22 +1,89 @@
 package routes
 
 import (
+	"github.com/PaulFWatts/rest_api_golang/config"
 	"github.com/PaulFWatts/rest_api_golang/middlewares"
+	"github.com/PaulFWatts/rest_api_golang/models"
 	"github.com/gin-gonic/gin"
 )
 
-	func RegisterRoutes(server *gin.Engine) {
-	server.GET("/events", getEvents) // GET, POST, PUT, PATCH, DELETE
-	server.GET("/events/:id", getEvent) // This can be used to get a specific event by ID
+// Server holds what the handlers depend on; the handlers are its methods
+//
+// The repositories are usually the SQL ones, but tests can build a server
+// on models.NewMemoryRepositories and call it with httptest, without a
+// database file:
+//
+//	api := &routes.Server{Repositories: models.NewMemoryRepositories()}
+//	router := gin.New()
+//	api.RegisterRoutes(router)
+//
+//	recorder := httptest.NewRecorder()
+//	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/events", nil))
+type Server struct {
+	// App holds the settings that shape what handlers tell users, such as links in emails
+	App config.App
+	// Limits are the rate limits of the route groups; the zero value turns them off
+	Limits config.RateLimit
+
+	models.Repositories
+}
+
+// RegisterRoutes registers every route of the API on the engine
+//
+// Routes are grouped by rate limit: public reads and account routes are
+// limited per client IP, authenticated routes per user. Only the account
+// routes use the configured bucket store, which may be the database; the
+// others count in memory. The health and version
+// endpoints are not limited, so probes always succeed. Metrics are not served
+// here but on their own listener, see server.Run.
+func (s *Server) RegisterRoutes(server *gin.Engine) {
+	// Middleware on the engine only applies to routes registered after it.
+	// Recovery comes last so that the others still see requests that panic.
+	server.Use(middlewares.Tracing, middlewares.RequestID, middlewares.RequestLogger, middlewares.Metrics, middlewares.Recovery)
+	server.NoRoute(notFound)
+
+	server.GET("/healthz", getLiveness) // This can be used by orchestrators to check the process is alive
+	server.GET("/readyz", getReadiness) // This can be used by orchestrators and load balancers to check the API can take requests
+	server.GET("/version", getVersion)  // This can be used to see which build is running
+
+	public := server.Group("/")
+	public.Use(middlewares.RateLimit("public", s.Limits.Public))
+	public.GET("/events", s.getEvents)              // GET, POST, PUT, PATCH, DELETE
+	public.GET("/events/search", s.searchEvents)    // This can be used to search events by text
+	public.GET("/events/:id", s.getEvent)           // This can be used to get a specific event by ID, or /events/:id.ics for iCalendar
+	public.GET("/me/calendar.ics", s.getMyCalendar) // This can be used by calendar apps to subscribe to the user's events
+	public.GET("/.well-known/jwks.json", getJWKS)   // This can be used to fetch the public token verification keys
 
 	authenticated := server.Group("/")
-	authenticated.Use(middlewares.Authenticate)
-	authenticated.POST("/events",  createEvent)
-	authenticated.PUT("/events/:id", updateEvent) // This can be used to update a specific event by ID
-	authenticated.DELETE("/events/:id", deleteEvent) // This can be used to delete a specific
-	authenticated.POST("/events/:id/register", registerForEvent) // This can be used to register for a specific event by ID
-	authenticated.DELETE("/events/:id/register",cancelRegistration) // This can be used to unregister from a specific event by ID
-
-	server.POST("/signup", signup) // This can be used to handle user signup
-	server.POST("/login", login) // This can be used to handle user login
-}
\ No newline at end of file
+	authenticated.Use(middlewares.Authenticate(s.Users), middlewares.RateLimit("authenticated", s.Limits.Authenticated))
+	authenticated.POST("/events", middlewares.RequireVerifiedEmail(s.Users), middlewares.RequirePermission(models.PermissionEventsCreate), s.createEvent)
+	authenticated.PUT("/events/:id", middlewares.RequirePermission(models.PermissionEventsUpdateOwn, models.PermissionEventsUpdateAny), s.updateEvent)                            // This can be used to update a specific event by ID
+	authenticated.DELETE("/events/:id", middlewares.RequirePermission(models.PermissionEventsDeleteOwn, models.PermissionEventsDeleteAny), s.deleteEvent)                         // This can be used to delete a specific
+	authenticated.PUT("/events/:id/occurrences/:start", middlewares.RequirePermission(models.PermissionEventsUpdateOwn, models.PermissionEventsUpdateAny), s.updateOccurrence)    // This can be used to change one occurrence of a recurring event
+	authenticated.DELETE("/events/:id/occurrences/:start", middlewares.RequirePermission(models.PermissionEventsUpdateOwn, models.PermissionEventsUpdateAny), s.cancelOccurrence) // This can be used to cancel one occurrence of a recurring event
+	authenticated.POST("/events/:id/register", middlewares.RequireVerifiedEmail(s.Users), middlewares.RequirePermission(models.PermissionEventsRegister), s.registerForEvent)     // This can be used to register for a specific event by ID
+	authenticated.GET("/events/:id/register", s.getRegistrationStatus)                                                                                                            // This can be used to see registration status and waitlist position
+	authenticated.DELETE("/events/:id/register", s.cancelRegistration)                                                                                                            // This can be used to unregister from a specific event by ID
+	authenticated.GET("/events/:id/registrations", s.getEventRegistrations)                                                                                                       // This can be used by the event owner to list attendees
+	authenticated.GET("/me/registrations", s.getMyRegistrations)                                                                                                                  // This can be used to list the events the user registered for
+	authenticated.POST("/me/calendar-token", s.createCalendarToken)                                                                                                               // This can be used to create a secret calendar feed URL
+	authenticated.DELETE("/me/calendar-token", s.revokeCalendarToken)                                                                                                             // This can be used to revoke the calendar feed URL
+	authenticated.POST("/me/verification-email", s.resendVerificationEmail)                                                                                                       // This can be used to send another verification email
+	authenticated.GET("/me/mfa", s.getMFAStatus)                                                                                                                                  // This can be used to see the user's two-factor authentication settings
+	authenticated.POST("/me/mfa/totp", s.beginTOTPEnrolment)                                                                                                                      // This can be used to start two-factor enrolment
+	authenticated.POST("/me/mfa/totp/confirm", s.confirmTOTPEnrolment)                                                                                                            // This can be used to enable two-factor authentication
+	authenticated.DELETE("/me/mfa/totp", s.disableTOTP)                                                                                                                           // This can be used to disable two-factor authentication
+	authenticated.POST("/me/mfa/recovery-codes", s.regenerateRecoveryCodes)                                                                                                       // This can be used to replace the recovery codes
+	authenticated.PUT("/users/:id/roles", middlewares.RequirePermission(models.PermissionUsersRoles), s.setUserRoles)                                                             // This can be used to replace a user's roles
+
+	accounts := server.Group("/")
+	accounts.Use(middlewares.SharedRateLimit("accounts", s.Limits.Accounts))
+	accounts.POST("/signup", s.signup)                  // This can be used to handle user signup
+	accounts.POST("/login", s.login)                    // This can be used to handle user login
+	accounts.POST("/login/mfa", s.loginMFA)             // This can be used to complete a login with a two-factor code
+	accounts.POST("/token/refresh", s.refreshToken)     // This can be used to rotate a refresh token
+	accounts.POST("/logout", s.logout)                  // This can be used to revoke a refresh token
+	accounts.POST("/password/forgot", s.forgotPassword) // This can be used to request a password reset email
+	accounts.POST("/password/reset", s.resetPassword)   // This can be used to set a new password with a reset token
+	accounts.GET("/verify-email", s.verifyEmail)        // This can be used to verify an email address with the link from the verification email
+}
@@ -0,0 +1,194 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/PaulFWatts/rest_api_golang/db/dbtest"
+	"github.com/PaulFWatts/rest_api_golang/mailer"
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/tracing"
+	"github.com/PaulFWatts/rest_api_golang/utils"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// testPassword meets the password policy
+const testPassword = "Tr0ub4dor&3x"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "test secret"
+	utils.InitKeys(cfg.Auth)
+
+	// The lowest bcrypt cost keeps the many signups in the tests fast
+	cfg.Password.BcryptCost = 4
+	utils.InitPasswordHasher(cfg.Password)
+
+	// Installed once, as the packages' tracers keep the first provider they are given
+	tracing.Use(spans)
+
+	os.Exit(m.Run())
+}
+
+// spans receives every span the tests record; tests that check them reset it first
+var spans = tracetest.NewInMemoryExporter()
+
+// testServer is a Server on test repositories with its router
+type testServer struct {
+	*Server
+	router *gin.Engine
+}
+
+func newTestServer(repositories models.Repositories) *testServer {
+	api := &Server{
+		App:          config.App{PublicURL: "https://api.example.com", MFAIssuer: "Events API"},
+		Repositories: repositories,
+	}
+	router := gin.New()
+	api.RegisterRoutes(router)
+
+	return &testServer{Server: api, router: router}
+}
+
+// forEachBackend runs the test as a subtest against a server on the memory
+// repositories and on the SQL repositories of each database in dbtest.Drivers
+func forEachBackend(t *testing.T, test func(t *testing.T, ts *testServer)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, newTestServer(models.NewMemoryRepositories()))
+	})
+
+	for _, driver := range dbtest.Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			test(t, newTestServer(models.NewSQLRepositories(dbtest.Open(t, driver), models.SearchIndex(dbtest.SearchIndex(driver)))))
+		})
+	}
+}
+
+// useMemoryMailer makes emails go to a memory mailer for the rest of the test and returns it
+func useMemoryMailer(t *testing.T) *mailer.MemoryMailer {
+	t.Helper()
+
+	memory := &mailer.MemoryMailer{}
+	mailer.Use(memory)
+	t.Cleanup(func() {
+		mailer.Wait(5 * time.Second)
+		mailer.Use(nil)
+	})
+	return memory
+}
+
+// sentMessages waits for the emails sent in the background and returns every message sent so far
+func sentMessages(t *testing.T, memory *mailer.MemoryMailer) []mailer.Message {
+	t.Helper()
+
+	if !mailer.Wait(5 * time.Second) {
+		t.Fatal("emails still sending after 5 seconds")
+	}
+	return memory.Messages()
+}
+
+// signUp creates a verified account with the roles and returns its ID and an access token
+func (ts *testServer) signUp(t *testing.T, email string, roles ...string) (int64, string) {
+	t.Helper()
+
+	user := &models.User{Email: email, Password: testPassword}
+	err := ts.Users.Save(context.Background(), user)
+	if err != nil {
+		t.Fatalf("Save(%s): %v", email, err)
+	}
+
+	err = ts.Users.VerifyEmail(user.ID, user.Email)
+	if err != nil {
+		t.Fatalf("VerifyEmail(%d): %v", user.ID, err)
+	}
+
+	if len(roles) > 0 {
+		err = ts.Users.SetRoles(user.ID, roles)
+		if err != nil {
+			t.Fatalf("SetRoles(%d): %v", user.ID, err)
+		}
+	}
+
+	err = ts.Users.LoadRoles(user)
+	if err != nil {
+		t.Fatalf("LoadRoles(%d): %v", user.ID, err)
+	}
+
+	token, err := utils.GenerateToken(user.Claims())
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	return user.ID, token
+}
+
+// do sends a request with an optional access token and JSON body
+func (ts *testServer) do(method string, path string, token string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("Authorization", token)
+	}
+
+	recorder := httptest.NewRecorder()
+	ts.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// expectStatus fails the test unless the response has the status
+func expectStatus(t *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if recorder.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", recorder.Code, status, recorder.Body)
+	}
+}
+
+// decode unmarshals the JSON response body into v
+func decode(t *testing.T, recorder *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	err := json.Unmarshal(recorder.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", recorder.Body, err)
+	}
+}
+
+// eventJSON is a valid event body starting a week from now, with extra
+// fields such as `"capacity": 2` added to it
+func eventJSON(name string, extra ...string) string {
+	fields := []string{
+		`"name": "` + name + `"`,
+		`"description": "A test event"`,
+		`"location": "Hall"`,
+		`"dateTime": "` + time.Now().Add(7*24*time.Hour).UTC().Format(time.RFC3339) + `"`,
+	}
+	return "{" + strings.Join(append(fields, extra...), ", ") + "}"
+}
+
+func TestHealthRoutesNeedNoAuthentication(t *testing.T) {
+	ts := newTestServer(models.NewMemoryRepositories())
+
+	expectStatus(t, ts.do(http.MethodGet, "/healthz", "", ""), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/version", "", ""), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPost, "/events", "", eventJSON("Unauthenticated")), http.StatusUnauthorized)
+}
+
+func TestMetricsAreNotServedByTheAPI(t *testing.T) {
+	ts := newTestServer(models.NewMemoryRepositories())
+
+	// They have a listener of their own, see server.Run
+	expectStatus(t, ts.do(http.MethodGet, "/metrics", "", ""), http.StatusNotFound)
+}
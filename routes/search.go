@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// searchEvents handles GET /events/search - full-text search over events
+//
+// This handler searches event names, descriptions and locations using the
+// SQLite FTS5 index or PostgreSQL's full-text search and returns the best matches first, each with a snippet
+// of the description and the name with the matched terms highlighted.
+//
+// HTTP Method: GET
+// Endpoint: /events/search
+// Authentication: Not required
+//
+// Query Parameters:
+//   - q (string, required): Search terms; "term*" matches a prefix, "quoted text" matches a phrase
+//   - limit (integer): Maximum number of results, default 20, maximum 100
+//
+// Response Codes:
+//   - 200 OK: Search completed (data may be empty)
+//   - 400 Bad Request: Missing or invalid query
+//   - 500 Internal Server Error: Database query failed
+//   - 501 Not Implemented: The repositories have no search index
+//
+// Response Body:
+//
+//	Success: {"data": [{"Event": {...}, "Rank": -1.2, "Snippet": "...<mark>go</mark>...", "HighlightedName": "..."}], "total": 3}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+//
+// Security Notes:
+//   - Snippets and highlighted names are HTML-escaped; only the <mark> tags are markup
+func (s *Server) searchEvents(context *gin.Context) {
+	pageRequest, err := parsePageRequest(context)
+	if err != nil {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Could not parse query parameters.")
+		return
+	}
+
+	results, total, err := s.Events.Search(context.Query("q"), pageRequest.Limit)
+
+	if errors.Is(err, models.ErrInvalidSearchQuery) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidParameter, "Invalid search query.")
+		return
+	}
+
+	if errors.Is(err, models.ErrSearchUnavailable) {
+		problem.Respond(context, http.StatusNotImplemented, problem.CodeNotImplemented, "Search is not available on this server.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not search events. Try again later.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"data": results, "total": total})
+}
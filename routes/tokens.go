@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/PaulFWatts/rest_api_golang/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// refreshTokenRequest is the request body accepted by /token/refresh and /logout
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// refreshToken handles POST /token/refresh - exchanges a refresh token for a new token pair
+//
+// This handler rotates the presented refresh token: the old token is invalidated
+// and a new access token plus a new refresh token from the same family are returned.
+// Presenting a refresh token that was already rotated revokes the entire family,
+// forcing whoever holds any token from it to log in again.
+//
+// HTTP Method: POST
+// Endpoint: /token/refresh
+// Authentication: Refresh token in request body
+//
+// Request Body: JSON object with required fields:
+//   - refreshToken (string): The refresh token returned by /login or a previous refresh
+//
+// Response Codes:
+//   - 200 OK: Tokens rotated successfully
+//   - 400 Bad Request: Invalid JSON request data
+//   - 401 Unauthorized: Refresh token unknown, expired, revoked or reused
+//   - 500 Internal Server Error: Database or token generation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Token refreshed!", "token": "...", "refreshToken": "..."}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) refreshToken(context *gin.Context) {
+	var request refreshTokenRequest
+	err := context.ShouldBindJSON(&request)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	newRefreshToken, userId, err := s.Tokens.RotateRefreshToken(request.RefreshToken)
+
+	if errors.Is(err, models.ErrRefreshTokenInvalid) || errors.Is(err, models.ErrRefreshTokenReused) {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeInvalidToken, "Invalid refresh token.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not refresh token.", err)
+		return
+	}
+
+	user, err := s.Users.GetByID(userId)
+
+	if err != nil {
+		internalError(context, "Could not refresh token.", err)
+		return
+	}
+
+	// Roles are reloaded so that permission changes reach the new access token
+	err = s.Users.LoadRoles(user)
+
+	if err != nil {
+		internalError(context, "Could not refresh token.", err)
+		return
+	}
+
+	token, err := utils.GenerateToken(user.Claims())
+
+	if err != nil {
+		internalError(context, "Could not generate token.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Token refreshed!", "token": token, "refreshToken": newRefreshToken})
+}
+
+// logout handles POST /logout - revokes a refresh token and every token rotated from it
+//
+// HTTP Method: POST
+// Endpoint: /logout
+// Authentication: Refresh token in request body
+//
+// Response Codes:
+//   - 200 OK: Refresh token family revoked (also returned if it was already revoked)
+//   - 400 Bad Request: Invalid JSON request data
+//   - 401 Unauthorized: Unknown refresh token
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Logged out!"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+//
+// Security Notes:
+//   - Access tokens already issued stay valid until they expire (15 minutes)
+func (s *Server) logout(context *gin.Context) {
+	var request refreshTokenRequest
+	err := context.ShouldBindJSON(&request)
+
+	if err != nil {
+		problem.Write(context, problem.FromBinding(err))
+		return
+	}
+
+	err = s.Tokens.RevokeRefreshToken(request.RefreshToken)
+
+	if errors.Is(err, models.ErrRefreshTokenInvalid) {
+		problem.Respond(context, http.StatusUnauthorized, problem.CodeInvalidToken, "Invalid refresh token.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not log out.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Logged out!"})
+}
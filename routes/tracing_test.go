@@ -0,0 +1,120 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PaulFWatts/rest_api_golang/db/dbtest"
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordedSpan returns the one span recorded with the name
+func recordedSpan(t *testing.T, name string) tracetest.SpanStub {
+	t.Helper()
+
+	var found []tracetest.SpanStub
+	for _, span := range spans.GetSpans() {
+		if span.Name == name {
+			found = append(found, span)
+		}
+	}
+	if len(found) != 1 {
+		var names []string
+		for _, span := range spans.GetSpans() {
+			names = append(names, span.Name)
+		}
+		t.Fatalf("recorded %d spans named %q, want 1; recorded %s", len(found), name, strings.Join(names, ", "))
+	}
+	return found[0]
+}
+
+// expectChild fails the test unless child is a direct child of parent
+func expectChild(t *testing.T, parent tracetest.SpanStub, child tracetest.SpanStub) {
+	t.Helper()
+
+	if child.SpanContext.TraceID() != parent.SpanContext.TraceID() || child.Parent.SpanID() != parent.SpanContext.SpanID() {
+		t.Errorf("%s is not a child of %s", child.Name, parent.Name)
+	}
+}
+
+// attributeValue returns the value of the span's attribute with the key
+func attributeValue(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// newTracedServer returns a server on SQLite, whose repositories record model spans
+func newTracedServer(t *testing.T) *testServer {
+	t.Helper()
+
+	spans.Reset()
+	return newTestServer(models.NewSQLRepositories(dbtest.Open(t, "sqlite"), ""))
+}
+
+func TestTracingSignupAndLogin(t *testing.T) {
+	ts := newTracedServer(t)
+	useMemoryMailer(t)
+
+	expectStatus(t, ts.do(http.MethodPost, "/signup", "", `{"email": "ada@example.com", "password": "`+testPassword+`"}`), http.StatusCreated)
+
+	server := recordedSpan(t, "POST /signup")
+	if server.SpanKind != trace.SpanKindServer {
+		t.Errorf("%s has kind %s, want server", server.Name, server.SpanKind)
+	}
+	if status := attributeValue(server, "http.response.status_code").AsInt64(); status != http.StatusCreated {
+		t.Errorf("%s recorded status %d, want %d", server.Name, status, http.StatusCreated)
+	}
+
+	save := recordedSpan(t, "models.UserRepository.Save")
+	expectChild(t, server, save)
+	if save.SpanKind != trace.SpanKindClient {
+		t.Errorf("%s has kind %s, want client", save.Name, save.SpanKind)
+	}
+	expectChild(t, save, recordedSpan(t, "password.hash"))
+
+	spans.Reset()
+	ts.logIn(t, "ada@example.com", testPassword)
+	expectChild(t, recordedSpan(t, "POST /login"), recordedSpan(t, "password.verify"))
+}
+
+func TestTracingEventList(t *testing.T) {
+	ts := newTracedServer(t)
+
+	expectStatus(t, ts.do(http.MethodGet, "/events", "", ""), http.StatusOK)
+	expectChild(t, recordedSpan(t, "GET /events"), recordedSpan(t, "models.EventRepository.List"))
+
+	spans.Reset()
+	expectStatus(t, ts.do(http.MethodGet, "/events?expand=true&from=2030-01-01T00:00:00Z&to=2030-02-01T00:00:00Z", "", ""), http.StatusOK)
+	expectChild(t, recordedSpan(t, "GET /events"), recordedSpan(t, "models.EventRepository.ListOccurrences"))
+}
+
+func TestTracingContinuesTheCallersTrace(t *testing.T) {
+	ts := newTracedServer(t)
+
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentId = "00f067aa0ba902b7"
+
+	request := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	request.Header.Set("traceparent", "00-"+traceId+"-"+parentId+"-01")
+	recorder := httptest.NewRecorder()
+	ts.router.ServeHTTP(recorder, request)
+	expectStatus(t, recorder, http.StatusOK)
+
+	server := recordedSpan(t, "GET /healthz")
+	if server.SpanContext.TraceID().String() != traceId || server.Parent.SpanID().String() != parentId {
+		t.Errorf("server span is in trace %s under %s, want trace %s under %s",
+			server.SpanContext.TraceID(), server.Parent.SpanID(), traceId, parentId)
+	}
+	if !server.Parent.IsRemote() {
+		t.Error("server span's parent is not marked remote")
+	}
+}
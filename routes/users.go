@@ -1,56 +1,194 @@
 package routes
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/PaulFWatts/rest_api_golang/logging"
 	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
 	"github.com/PaulFWatts/rest_api_golang/utils"
 	"github.com/gin-gonic/gin"
 )
 
-// signup validates the input, creates a new user, and saves it to the database
-func signup(context *gin.Context) {
-	
+// signup validates the input, creates a new unverified user, saves it to the
+// database and sends the user a verification email
+func (s *Server) signup(context *gin.Context) {
+
 	var user models.User
 
 	err := context.ShouldBindJSON(&user)
 
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse request data.", "error": err.Error()})
+		problem.Write(context, problem.FromBinding(err))
 		return
 	}
 
-	err = user.Save()
+	err = s.Users.Save(context.Request.Context(), &user)
+	if errors.Is(err, models.ErrInvalidEmail) {
+		problem.Write(context, problem.Invalid(problem.FieldError{Field: "email", Code: "invalid"}))
+		return
+	}
+	var policyErr *models.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		problem.Write(context, problem.Invalid(problem.FieldError{Field: "password", Code: "policy", Detail: policyErr.Message}))
+		return
+	}
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not save user.", "error": err.Error()})
+		internalError(context, "Could not save user.", err)
 		return
 	}
+
+	// The account exists at this point, so a failed email only means the user has to ask for another
+	_, err = s.Users.ReserveVerificationEmail(user.ID)
+	if err == nil {
+		err = s.sendVerificationEmail(&user)
+	}
+	if err != nil {
+		logging.FromContext(context.Request.Context()).Error("Could not send verification email.", "userId", user.ID, "error", err)
+	}
+
 	context.JSON(http.StatusCreated, gin.H{"message": "User created successfully.", "user_id": user.ID})
 }
 
-func login(context *gin.Context) {
+// login validates the user's credentials and returns an access token and a refresh token
+//
+// If the account has two-factor authentication enabled, no tokens are issued
+// yet; the response carries a short-lived MFA token instead, which POST
+// /login/mfa exchanges for the tokens together with a TOTP or recovery code:
+//
+//	{"message": "Two-factor authentication required.", "mfaRequired": true, "mfaToken": "..."}
+//
+// Repeated failures lock the account (after 5) and the client IP address
+// (after 20) with exponential backoff, answered with 429 Too Many Requests and
+// a Retry-After header. Every attempt is recorded in login_attempts.
+func (s *Server) login(context *gin.Context) {
 	var user models.User
 
 	err := context.ShouldBindJSON(&user)
 
 	if err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"message": "Could not parse request data."})
+		problem.Write(context, problem.FromBinding(err))
 		return
 	}
 
-	err = user.ValidateCredentials()
+	// The attempt is counted before the password is checked, so that locked-out
+	// guesses cost no bcrypt work and parallel guesses cannot overtake the lock
+	reservation, ok := s.reserveLogin(context, user.Email)
+	if !ok {
+		return
+	}
+
+	email := user.Email
+	err = s.Users.ValidateCredentials(context.Request.Context(), &user)
 
 	if err != nil {
-		context.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid email or password.", "error": err.Error()})
+		if s.recordLoginAttempt(context, reservation, models.LoginAttempt{Email: email, UserID: knownUserID(user.ID), Reason: models.LoginInvalidCredentials}) {
+			problem.Respond(context, http.StatusUnauthorized, problem.CodeInvalidCredentials, "Invalid email or password.")
+		}
 		return
 	}
 
-	token, err := utils.GenerateToken(user.Email, user.ID)
+	if user.MFAEnabled {
+		if !s.recordLoginAttempt(context, reservation, models.LoginAttempt{Email: user.Email, UserID: &user.ID, Reason: models.LoginMFARequired}) {
+			return
+		}
+
+		mfaToken, err := utils.GenerateMFAToken(user.ID, user.TokenVersion)
+
+		if err != nil {
+			internalError(context, "Could not generate token.", err)
+			return
+		}
+
+		context.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication required.", "mfaRequired": true, "mfaToken": mfaToken})
+		return
+	}
+
+	if s.recordLoginAttempt(context, reservation, models.LoginAttempt{Email: user.Email, UserID: &user.ID, Success: true, Reason: models.LoginSucceeded}) {
+		s.issueTokens(context, &user, "Login successful!")
+	}
+}
+
+// reserveLogin counts a login attempt for the email from the client's IP
+// address against the throttles and reports whether the handler can go on
+//
+// It responds with 429 Too Many Requests if logins are locked, which is the
+// same whether or not an account has the email, and with 500 if the
+// reservation failed. The reservation has to be settled with recordLoginAttempt;
+// if the handler fails before that, the attempt stays counted as a failure.
+func (s *Server) reserveLogin(context *gin.Context, email string) (*models.LoginReservation, bool) {
+	reservation, err := s.Logins.ReserveAttempt(email, context.ClientIP())
 
 	if err != nil {
-		context.JSON(http.StatusInternalServerError, gin.H{"message": "Could not generate token.", "error": err.Error()})
+		internalError(context, "Could not log in. Try again later.", err)
+		return nil, false
+	}
+
+	if reservation.Wait <= 0 {
+		return reservation, true
+	}
+
+	if s.recordLoginAttempt(context, nil, models.LoginAttempt{Email: email, Reason: models.LoginThrottled}) {
+		setRetryAfter(context, reservation.Wait)
+		problem.Respond(context, http.StatusTooManyRequests, problem.CodeLoginThrottled, "Too many login attempts. Try again later.")
+	}
+	return nil, false
+}
+
+// recordLoginAttempt records a login attempt from the client's IP address,
+// settling its reservation, and reports whether the handler can go on; it
+// responds with 500 if recording failed
+func (s *Server) recordLoginAttempt(context *gin.Context, reservation *models.LoginReservation, attempt models.LoginAttempt) bool {
+	attempt.IP = context.ClientIP()
+	err := s.Logins.RecordAttempt(reservation, attempt)
+
+	if err != nil {
+		internalError(context, "Could not log in. Try again later.", err)
+		return false
+	}
+
+	return true
+}
+
+// setRetryAfter sets the Retry-After header of a 429 response to the wait in whole seconds
+func setRetryAfter(context *gin.Context, wait time.Duration) {
+	context.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// knownUserID returns a pointer to the user ID, or nil if no account was found
+func knownUserID(id int64) *int64 {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+// issueTokens responds with a new access token and refresh token for a user whose credentials were checked
+func (s *Server) issueTokens(context *gin.Context, user *models.User, message string) {
+	err := s.Users.LoadRoles(user)
+
+	if err != nil {
+		internalError(context, "Could not load user roles.", err)
+		return
+	}
+
+	token, err := utils.GenerateToken(user.Claims())
+
+	if err != nil {
+		internalError(context, "Could not generate token.", err)
 		return
 	}
 
-	context.JSON(http.StatusOK, gin.H{"message": "Login successful!", "token": token})
-}
\ No newline at end of file
+	refreshToken, err := s.Tokens.IssueRefreshToken(user.ID)
+
+	if err != nil {
+		internalError(context, "Could not generate token.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": message, "token": token, "refreshToken": refreshToken})
+}
@@ -0,0 +1,215 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/models"
+)
+
+// tokenPair is the body of a successful login or refresh
+type tokenPair struct {
+	Token        string
+	RefreshToken string
+}
+
+// tokenInLink matches the token query parameter of a link in an email
+var tokenInLink = regexp.MustCompile(`token=(\S+)`)
+
+// linkToken returns the token of the link in an email body
+func linkToken(t *testing.T, body string) string {
+	t.Helper()
+
+	match := tokenInLink.FindStringSubmatch(body)
+	if match == nil {
+		t.Fatalf("no link with a token in %q", body)
+	}
+
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("unescaping %q: %v", match[1], err)
+	}
+	return token
+}
+
+// logIn logs in with the password and returns the tokens
+func (ts *testServer) logIn(t *testing.T, email string, password string) tokenPair {
+	t.Helper()
+
+	recorder := ts.do(http.MethodPost, "/login", "", `{"email": "`+email+`", "password": "`+password+`"}`)
+	expectStatus(t, recorder, http.StatusOK)
+
+	var tokens tokenPair
+	decode(t, recorder, &tokens)
+	if tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("login response without tokens: %s", recorder.Body)
+	}
+	return tokens
+}
+
+func TestSignupLoginRefreshAndLogout(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		useMemoryMailer(t)
+
+		recorder := ts.do(http.MethodPost, "/signup", "", `{"email": "Ada@Example.com", "password": "`+testPassword+`"}`)
+		expectStatus(t, recorder, http.StatusCreated)
+
+		tokens := ts.logIn(t, "ada@example.com", testPassword)
+		expectStatus(t, ts.do(http.MethodGet, "/me/mfa", tokens.Token, ""), http.StatusOK)
+
+		recorder = ts.do(http.MethodPost, "/token/refresh", "", `{"refreshToken": "`+tokens.RefreshToken+`"}`)
+		expectStatus(t, recorder, http.StatusOK)
+
+		var refreshed tokenPair
+		decode(t, recorder, &refreshed)
+		if refreshed.RefreshToken == "" || refreshed.RefreshToken == tokens.RefreshToken {
+			t.Fatalf("refresh did not rotate the refresh token: %s", recorder.Body)
+		}
+
+		expectStatus(t, ts.do(http.MethodPost, "/logout", "", `{"refreshToken": "`+refreshed.RefreshToken+`"}`), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodPost, "/token/refresh", "", `{"refreshToken": "`+refreshed.RefreshToken+`"}`), http.StatusUnauthorized)
+	})
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		ts.signUp(t, "ada@example.com")
+		tokens := ts.logIn(t, "ada@example.com", testPassword)
+
+		recorder := ts.do(http.MethodPost, "/token/refresh", "", `{"refreshToken": "`+tokens.RefreshToken+`"}`)
+		expectStatus(t, recorder, http.StatusOK)
+		var refreshed tokenPair
+		decode(t, recorder, &refreshed)
+
+		// Presenting the rotated token again looks like theft, so the newer token stops working too
+		expectStatus(t, ts.do(http.MethodPost, "/token/refresh", "", `{"refreshToken": "`+tokens.RefreshToken+`"}`), http.StatusUnauthorized)
+		expectStatus(t, ts.do(http.MethodPost, "/token/refresh", "", `{"refreshToken": "`+refreshed.RefreshToken+`"}`), http.StatusUnauthorized)
+	})
+}
+
+func TestLoginThrottling(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		ts.signUp(t, "ada@example.com")
+
+		wrong := `{"email": "ada@example.com", "password": "wrong password"}`
+		for range 6 {
+			expectStatus(t, ts.do(http.MethodPost, "/login", "", wrong), http.StatusUnauthorized)
+		}
+
+		// The sixth failure locked the account, so even the right password is turned away
+		recorder := ts.do(http.MethodPost, "/login", "", `{"email": "ada@example.com", "password": "`+testPassword+`"}`)
+		expectStatus(t, recorder, http.StatusTooManyRequests)
+		if recorder.Header().Get("Retry-After") == "" {
+			t.Error("429 without a Retry-After header")
+		}
+
+		// Unknown addresses are throttled the same way
+		unknown := `{"email": "nobody@example.com", "password": "wrong password"}`
+		for range 6 {
+			expectStatus(t, ts.do(http.MethodPost, "/login", "", unknown), http.StatusUnauthorized)
+		}
+		expectStatus(t, ts.do(http.MethodPost, "/login", "", unknown), http.StatusTooManyRequests)
+	})
+}
+
+// slowPasswords makes checking a password take a while, so that parallel logins overlap
+type slowPasswords struct {
+	models.UserRepository
+}
+
+func (u slowPasswords) ValidateCredentials(ctx context.Context, user *models.User) error {
+	time.Sleep(50 * time.Millisecond)
+	return u.UserRepository.ValidateCredentials(ctx, user)
+}
+
+func TestParallelLoginsCannotOvertakeTheLock(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		ts.signUp(t, "ada@example.com")
+		ts.Users = slowPasswords{ts.Users}
+
+		var wg sync.WaitGroup
+		statuses := make(chan int, 30)
+		for range 30 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				statuses <- ts.do(http.MethodPost, "/login", "", `{"email": "ada@example.com", "password": "wrong password"}`).Code
+			}()
+		}
+		wg.Wait()
+		close(statuses)
+
+		// 5 free failures plus the one that set the lock
+		checked := 0
+		for status := range statuses {
+			if status == http.StatusUnauthorized {
+				checked++
+			}
+		}
+		if checked != 6 {
+			t.Errorf("%d of 30 parallel guesses had their password checked, want 6", checked)
+		}
+	})
+}
+
+func TestCorrectPasswordDoesNotCountAsFailure(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		_, token := ts.signUp(t, "ada@example.com")
+		ts.enableMFA(t, token)
+
+		wrong := `{"email": "ada@example.com", "password": "wrong password"}`
+		for range 5 {
+			expectStatus(t, ts.do(http.MethodPost, "/login", "", wrong), http.StatusUnauthorized)
+		}
+
+		// Waiting for the two-factor code gives the failure back, however often it happens
+		right := `{"email": "ada@example.com", "password": "` + testPassword + `"}`
+		for range 3 {
+			expectStatus(t, ts.do(http.MethodPost, "/login", "", right), http.StatusOK)
+		}
+
+		// The sixth failure is the first to lock
+		expectStatus(t, ts.do(http.MethodPost, "/login", "", wrong), http.StatusUnauthorized)
+		expectStatus(t, ts.do(http.MethodPost, "/login", "", right), http.StatusTooManyRequests)
+	})
+}
+
+func TestEmailVerification(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, ts *testServer) {
+		memory := useMemoryMailer(t)
+
+		recorder := ts.do(http.MethodPost, "/signup", "", `{"email": "ada@example.com", "password": "`+testPassword+`"}`)
+		expectStatus(t, recorder, http.StatusCreated)
+		tokens := ts.logIn(t, "ada@example.com", testPassword)
+
+		// Unverified accounts cannot create events
+		expectStatus(t, ts.do(http.MethodPost, "/events", tokens.Token, eventJSON("Too early")), http.StatusForbidden)
+
+		// The signup email was the first of the day, so another has to wait a minute
+		expectStatus(t, ts.do(http.MethodPost, "/me/verification-email", tokens.Token, ""), http.StatusTooManyRequests)
+
+		messages := sentMessages(t, memory)
+		if len(messages) != 1 {
+			t.Fatalf("sent %d emails, want 1", len(messages))
+		}
+
+		// Links point at the public URL, whichever Host the signup request was sent to
+		if !strings.Contains(messages[0].Body, "https://api.example.com/verify-email?token=") {
+			t.Errorf("verification email does not link to the public URL: %q", messages[0].Body)
+		}
+
+		token := linkToken(t, messages[0].Body)
+		expectStatus(t, ts.do(http.MethodGet, "/verify-email?token=x"+url.QueryEscape(token), "", ""), http.StatusBadRequest)
+		expectStatus(t, ts.do(http.MethodGet, "/verify-email?token="+url.QueryEscape(token), "", ""), http.StatusOK)
+		expectStatus(t, ts.do(http.MethodGet, "/verify-email?token="+url.QueryEscape(token), "", ""), http.StatusOK)
+
+		expectStatus(t, ts.do(http.MethodPost, "/me/verification-email", tokens.Token, ""), http.StatusConflict)
+		ts.createTestEvent(t, tokens.Token, eventJSON("Verified"))
+	})
+}
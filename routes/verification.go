@@ -0,0 +1,143 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/PaulFWatts/rest_api_golang/mailer"
+	"github.com/PaulFWatts/rest_api_golang/models"
+	"github.com/PaulFWatts/rest_api_golang/problem"
+	"github.com/PaulFWatts/rest_api_golang/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// verifyEmail handles GET /verify-email - verifies an email address with the link from a verification email
+//
+// HTTP Method: GET
+// Endpoint: /verify-email?token=...
+// Authentication: Verification token in query string
+//
+// Query Parameters:
+//   - token (string, required): The token from the verification email
+//
+// Response Codes:
+//   - 200 OK: Email address verified (also returned if it already was)
+//   - 400 Bad Request: Token missing, invalid, expired, or issued for an address the account no longer uses
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Email address verified!"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) verifyEmail(context *gin.Context) {
+	userId, email, err := utils.VerifyEmailVerificationToken(context.Query("token"))
+	if err == nil {
+		err = s.Users.VerifyEmail(userId, email)
+	} else {
+		err = models.ErrEmailVerificationInvalid
+	}
+
+	if errors.Is(err, models.ErrEmailVerificationInvalid) {
+		problem.Respond(context, http.StatusBadRequest, problem.CodeInvalidToken, "Invalid or expired verification link.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not verify email address.", err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Email address verified!"})
+}
+
+// resendVerificationEmail handles POST /me/verification-email - sends a new verification email
+//
+// Signing up sends the first verification email; this endpoint sends another,
+// for example when the first link expired. At most one email per minute and
+// five per day are sent to a user, including the one sent on signup.
+//
+// HTTP Method: POST
+// Endpoint: /me/verification-email
+// Authentication: Required (JWT middleware validates Authorization header)
+//
+// Response Codes:
+//   - 202 Accepted: Verification email queued
+//   - 409 Conflict: Email address already verified
+//   - 429 Too Many Requests: Rate limit reached; the Retry-After header gives the seconds to wait
+//   - 500 Internal Server Error: Database operation failed
+//
+// Response Body:
+//
+//	Success: {"message": "Verification email sent!"}
+//	Error: {"code": "error_code", "detail": "error description", ...} (application/problem+json)
+func (s *Server) resendVerificationEmail(context *gin.Context) {
+	userId := context.GetInt64("userId")
+
+	user, err := s.Users.GetByID(userId)
+
+	if err != nil {
+		internalError(context, "Could not send verification email.", err)
+		return
+	}
+
+	wait, err := s.Users.ReserveVerificationEmail(userId)
+
+	if errors.Is(err, models.ErrEmailAlreadyVerified) {
+		problem.Respond(context, http.StatusConflict, problem.CodeEmailAlreadyVerified, "Email address already verified.")
+		return
+	}
+
+	if err != nil {
+		internalError(context, "Could not send verification email.", err)
+		return
+	}
+
+	if wait > 0 {
+		setRetryAfter(context, wait)
+		problem.Respond(context, http.StatusTooManyRequests, problem.CodeRateLimited, "Too many verification emails. Try again later.")
+		return
+	}
+
+	err = s.sendVerificationEmail(user)
+
+	if err != nil {
+		internalError(context, "Could not send verification email.", err)
+		return
+	}
+
+	context.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent!"})
+}
+
+// sendVerificationEmail emails the user a signed verification link in the background
+//
+// The link points at GET /verify-email under app.public_url, or at VERIFY_EMAIL_URL
+// when that is set, with the token set as its "token" query parameter.
+// Callers must reserve the email with Users.ReserveVerificationEmail first.
+func (s *Server) sendVerificationEmail(user *models.User) error {
+	token, err := utils.GenerateEmailVerificationToken(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	base := s.publicURL("/verify-email")
+	if s.App.VerifyEmailURL != "" {
+		base = s.App.VerifyEmailURL
+	}
+	link, err := withToken(base, token)
+	if err != nil {
+		return err
+	}
+
+	message := mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Welcome! Confirm your email address to start creating and registering for events:\n\n%s\n\n"+
+			"This link expires in %d hours. If you didn't sign up, ignore this email.\n",
+			link, int(utils.EmailVerificationTTL.Hours())),
+	}
+
+	mailer.SendInBackground(message, "verification email")
+
+	return nil
+}
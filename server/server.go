@@ -0,0 +1,185 @@
+// Package server runs the API's HTTP server and manages its lifecycle.
+//
+// Run serves until the process receives SIGINT or SIGTERM, then stops
+// accepting connections and waits for active requests to finish, so rolling
+// deploys never cut a request off halfway. When TLS is configured, the
+// certificate and key are read again on SIGHUP without dropping connections.
+// Metrics are served on a listener of their own, so they can be kept off the
+// network the API is exposed to.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+)
+
+// ErrShutdownTimeout is returned by Run when active requests were still
+// running at the shutdown deadline and their connections had to be closed
+var ErrShutdownTimeout = errors.New("active requests did not finish before the shutdown timeout")
+
+// New returns an http.Server for the handler with the configured address and timeouts
+func New(cfg config.Server, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Address,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+}
+
+// Run serves the handler until SIGINT or SIGTERM and then shuts down gracefully
+//
+// Lifecycle:
+//  1. Listens on the configured address, with HTTPS when a certificate and key are configured,
+//     and serves metricsHandler over HTTP on the metrics address unless it is empty
+//  2. On SIGINT or SIGTERM, calls onDrain (which makes readiness probes fail) and
+//     keeps serving for the shutdown delay, so load balancers can stop routing here
+//  3. Stops accepting new connections and closes idle ones
+//  4. Waits up to the shutdown timeout for active requests to complete
+//  5. Stops the metrics listener, which kept serving while requests drained
+//  6. Returns, leaving the caller to release resources such as the database
+//
+// Run returns nil after a clean shutdown, ErrShutdownTimeout if requests had
+// to be cut off, or the error that stopped the server from listening.
+func Run(cfg config.Server, handler http.Handler, metricsHandler http.Handler, onDrain func()) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	srv := New(cfg, handler)
+
+	var certificate *certificateReloader
+	if cfg.TLSCertFile != "" {
+		var err error
+		certificate, err = newCertificateReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = certificate.tlsConfig()
+
+		// Keep reloading until Run returns, including while requests drain
+		reloadCtx, stopReloading := context.WithCancel(context.Background())
+		defer stopReloading()
+		go certificate.reloadOnSIGHUP(reloadCtx)
+	}
+
+	// Listening before serving reports a busy port as an error from Run
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return err
+	}
+
+	serve := func() error { return srv.Serve(listener) }
+	if certificate != nil {
+		// The certificate comes from TLSConfig, so no files are passed here
+		serve = func() error { return srv.ServeTLS(listener, "", "") }
+	}
+
+	metrics, err := listenMetrics(cfg, metricsHandler)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	defer metrics.Close()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- serve()
+	}()
+
+	slog.Info("Listening", "address", cfg.Address, "tls", cfg.TLSCertFile != "")
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server stopped: %w", err)
+	case err := <-metrics.serveErr:
+		srv.Close()
+		return fmt.Errorf("metrics server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	// A second signal falls back to the default behaviour and exits immediately
+	stop()
+	onDrain()
+
+	if cfg.ShutdownDelay > 0 {
+		slog.Info("Draining before shutdown", "delay", cfg.ShutdownDelay.String())
+		time.Sleep(cfg.ShutdownDelay)
+	}
+
+	slog.Info("Shutting down, waiting for active requests", "timeout", cfg.ShutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	err = srv.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		srv.Close()
+		return ErrShutdownTimeout
+	}
+	if err != nil {
+		return err
+	}
+
+	// Scrapes are cheap and never hold up the shutdown
+	metrics.Close()
+
+	// Serve returns http.ErrServerClosed once Shutdown has begun
+	if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	slog.Info("Server stopped")
+	return nil
+}
+
+// metricsServer is the HTTP server of the metrics listener; its zero value
+// stands for a disabled listener
+type metricsServer struct {
+	srv      *http.Server
+	serveErr chan error
+}
+
+// listenMetrics starts serving the metrics handler on the metrics address,
+// or returns a disabled metricsServer if the address is empty
+func listenMetrics(cfg config.Server, handler http.Handler) (*metricsServer, error) {
+	if cfg.MetricsAddress == "" || handler == nil {
+		return &metricsServer{}, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", handler)
+
+	metricsCfg := cfg
+	metricsCfg.Address = cfg.MetricsAddress
+	m := &metricsServer{srv: New(metricsCfg, mux), serveErr: make(chan error, 1)}
+
+	listener, err := net.Listen("tcp", cfg.MetricsAddress)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %w", err)
+	}
+
+	go func() {
+		m.serveErr <- m.srv.Serve(listener)
+	}()
+
+	slog.Info("Serving metrics", "address", cfg.MetricsAddress)
+	return m, nil
+}
+
+// Close stops the metrics listener and closes its connections
+func (m *metricsServer) Close() {
+	if m.srv != nil {
+		m.srv.Close()
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+)
+
+// freeAddress returns a loopback address with a port nothing listens on
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestListenMetricsServesOnlyMetrics(t *testing.T) {
+	cfg := config.Default().Server
+	cfg.MetricsAddress = freeAddress(t)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "api_signups_total 1\n")
+	})
+
+	metrics, err := listenMetrics(cfg, handler)
+	if err != nil {
+		t.Fatalf("listenMetrics: %v", err)
+	}
+	defer metrics.Close()
+
+	client := http.Client{Timeout: 5 * time.Second}
+	for path, status := range map[string]int{"/metrics": http.StatusOK, "/events": http.StatusNotFound} {
+		response, err := client.Get("http://" + cfg.MetricsAddress + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		response.Body.Close()
+
+		if response.StatusCode != status {
+			t.Errorf("GET %s = %d, want %d", path, response.StatusCode, status)
+		}
+	}
+}
+
+func TestListenMetricsDisabled(t *testing.T) {
+	cfg := config.Default().Server
+	cfg.MetricsAddress = ""
+
+	metrics, err := listenMetrics(cfg, http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("listenMetrics: %v", err)
+	}
+	metrics.Close()
+
+	if metrics.srv != nil || metrics.serveErr != nil {
+		t.Errorf("listenMetrics without an address started a server")
+	}
+}
+
+func TestListenMetricsBusyPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer listener.Close()
+
+	cfg := config.Default().Server
+	cfg.MetricsAddress = listener.Addr().String()
+
+	_, err = listenMetrics(cfg, http.NotFoundHandler())
+	if err == nil {
+		t.Fatal("listenMetrics on a busy port succeeded")
+	}
+}
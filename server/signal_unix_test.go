@@ -0,0 +1,248 @@
+//go:build linux || darwin || freebsd
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+)
+
+// testClient returns a client for the server Run starts with the configuration
+// and the server's base URL; over TLS, the client trusts any certificate
+func testClient(cfg config.Server) (*http.Client, string) {
+	if cfg.TLSCertFile == "" {
+		return &http.Client{Timeout: 5 * time.Second}, "http://" + cfg.Address
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	return &http.Client{Timeout: 5 * time.Second, Transport: transport}, "https://" + cfg.Address
+}
+
+// runInBackground starts Run and returns the channel its error arrives on,
+// once the server answers requests
+func runInBackground(t *testing.T, cfg config.Server, handler http.Handler, onDrain func()) <-chan error {
+	t.Helper()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(cfg, handler, nil, onDrain)
+	}()
+
+	// Run listens for SIGTERM before it serves, so once it answers, the
+	// signal sent by the test stops the server rather than the test process
+	client, base := testClient(cfg)
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		response, err := client.Get(base + "/readyz")
+		if err == nil {
+			response.Body.Close()
+			return done
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("server did not start: %v", err)
+		}
+	}
+}
+
+// stopServer sends SIGTERM to the test process, which Run is listening for
+func stopServer(t *testing.T) {
+	t.Helper()
+
+	err := syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatalf("sending SIGTERM: %v", err)
+	}
+}
+
+func TestRunDrainsAndFinishesActiveRequests(t *testing.T) {
+	cfg := config.Default().Server
+	cfg.Address = freeAddress(t)
+	cfg.MetricsAddress = ""
+	cfg.ShutdownDelay = 300 * time.Millisecond
+
+	var drained atomic.Bool
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if drained.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	mux.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "finished")
+	})
+
+	done := runInBackground(t, cfg, mux, func() { drained.Store(true) })
+	client := http.Client{Timeout: 5 * time.Second}
+
+	type result struct {
+		body string
+		err  error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		response, err := client.Get("http://" + cfg.Address + "/slow")
+		if err != nil {
+			slow <- result{err: err}
+			return
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		slow <- result{string(body), err}
+	}()
+	<-started
+
+	stopServer(t)
+
+	// During the shutdown delay the server still answers, but is not ready
+	for start := time.Now(); !drained.Load(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("onDrain was not called")
+		}
+	}
+	response, err := client.Get("http://" + cfg.Address + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz while draining: %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz while draining = %d, want %d", response.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	// Shutdown waits for the request that was already running
+	time.Sleep(cfg.ShutdownDelay + 100*time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned with a request still running: %v", err)
+	default:
+	}
+	close(release)
+
+	if r := <-slow; r.err != nil || r.body != "finished" {
+		t.Errorf("active request = %q, %v; want it to finish", r.body, r.err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Run = %v, want nil after a clean shutdown", err)
+	}
+}
+
+func TestRunTimesOutRequestsThatDoNotFinish(t *testing.T) {
+	cfg := config.Default().Server
+	cfg.Address = freeAddress(t)
+	cfg.MetricsAddress = ""
+	cfg.ShutdownDelay = 0
+	cfg.ShutdownTimeout = 200 * time.Millisecond
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /stuck", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	done := runInBackground(t, cfg, mux, func() {})
+	client, base := testClient(cfg)
+
+	stuck := make(chan error, 1)
+	go func() {
+		response, err := client.Get(base + "/stuck")
+		if err == nil {
+			response.Body.Close()
+		}
+		stuck <- err
+	}()
+	<-started
+
+	stopServer(t)
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrShutdownTimeout) {
+			t.Errorf("Run = %v, want ErrShutdownTimeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the shutdown timeout")
+	}
+	if err := <-stuck; err == nil {
+		t.Error("the stuck request got a response; want its connection closed")
+	}
+}
+
+// peerSerial returns the serial number of the certificate the server presents to a new connection
+func peerSerial(t *testing.T, address string) int64 {
+	t.Helper()
+
+	connection, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("TLS handshake: %v", err)
+	}
+	defer connection.Close()
+	return connection.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestRunReloadsTheCertificateOnSIGHUP(t *testing.T) {
+	// Run only starts listening for SIGHUP once it serves TLS; until then
+	// this keeps the signal from ending the test process
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	dir := t.TempDir()
+	cfg := config.Default().Server
+	cfg.Address = freeAddress(t)
+	cfg.MetricsAddress = ""
+	cfg.ShutdownDelay = 0
+	cfg.TLSCertFile, cfg.TLSKeyFile = writeCertificate(t, dir, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {})
+
+	done := runInBackground(t, cfg, mux, func() {})
+	if serial := peerSerial(t, cfg.Address); serial != 1 {
+		t.Fatalf("server presents certificate %d, want 1", serial)
+	}
+
+	// Renew the certificate and signal until new connections get it
+	writeCertificate(t, dir, 2)
+	for start := time.Now(); peerSerial(t, cfg.Address) != 2; time.Sleep(20 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("server still presents the old certificate after SIGHUP")
+		}
+		err := syscall.Kill(os.Getpid(), syscall.SIGHUP)
+		if err != nil {
+			t.Fatalf("sending SIGHUP: %v", err)
+		}
+	}
+
+	client, base := testClient(cfg)
+	response, err := client.Get(base + "/readyz")
+	if err != nil {
+		t.Fatalf("GET /readyz after the reload: %v", err)
+	}
+	response.Body.Close()
+
+	stopServer(t)
+	if err := <-done; err != nil {
+		t.Errorf("Run = %v, want nil after a clean shutdown", err)
+	}
+}
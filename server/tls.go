@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// certificateReloader holds the TLS certificate served to clients and reads
+// it again from its files on request
+//
+// Connections that are already established keep the certificate they were
+// set up with; new handshakes use the latest one.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// newCertificateReloader loads the certificate and key, failing if they cannot be used
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// reload reads the certificate and key files and swaps them in
+//
+// If either file is missing or they do not match, the previous certificate
+// stays in use, so a half-finished renewal cannot take the server down.
+func (r *certificateReloader) reload() error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.certificate = &certificate
+	r.mu.Unlock()
+
+	return nil
+}
+
+// getCertificate returns the current certificate for each TLS handshake
+func (r *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.certificate, nil
+}
+
+// tlsConfig returns a TLS configuration that serves the current certificate
+func (r *certificateReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+	}
+}
+
+// reloadOnSIGHUP reloads the certificate every time the process receives
+// SIGHUP, until the context is cancelled
+func (r *certificateReloader) reloadOnSIGHUP(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			err := r.reload()
+			if err != nil {
+				slog.Error("Keeping the current TLS certificate", "error", err)
+				continue
+			}
+			slog.Info("Reloaded TLS certificate", "file", r.certFile)
+		}
+	}
+}
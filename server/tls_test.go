@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 with the
+// serial number and its key to cert.pem and key.pem in the directory
+func writeCertificate(t *testing.T, dir string, serial int64) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+// writePEM writes the DER bytes to the file as a single PEM block
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	t.Helper()
+
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+}
+
+// servedSerial returns the serial number of the certificate the reloader hands to a new handshake
+func servedSerial(t *testing.T, reloader *certificateReloader) int64 {
+	t.Helper()
+
+	certificate, err := reloader.tlsConfig().GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return parsed.SerialNumber.Int64()
+}
+
+func TestCertificateReloaderKeepsTheLastGoodCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, 1)
+
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertificateReloader: %v", err)
+	}
+	if serial := servedSerial(t, reloader); serial != 1 {
+		t.Fatalf("serving certificate %d, want 1", serial)
+	}
+
+	writeCertificate(t, dir, 2)
+	err = reloader.reload()
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if serial := servedSerial(t, reloader); serial != 2 {
+		t.Errorf("serving certificate %d after a reload, want 2", serial)
+	}
+
+	// A renewal that has written the certificate but not yet its key
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatalf("reading key: %v", err)
+	}
+	writeCertificate(t, dir, 3)
+	err = os.WriteFile(keyFile, key, 0o600)
+	if err != nil {
+		t.Fatalf("restoring key: %v", err)
+	}
+	if err := reloader.reload(); err == nil {
+		t.Error("reload of a certificate with another certificate's key succeeded")
+	}
+	if serial := servedSerial(t, reloader); serial != 2 {
+		t.Errorf("serving certificate %d after a failed reload, want 2", serial)
+	}
+}
+
+func TestNewCertificateReloaderRejectsMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, err := newCertificateReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err == nil {
+		t.Error("newCertificateReloader without certificate files succeeded")
+	}
+}
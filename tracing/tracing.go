@@ -0,0 +1,92 @@
+// Package tracing sets up OpenTelemetry tracing.
+//
+// Spans go to an exporter chosen at startup by Init: OTLP over HTTP for a
+// collector such as Jaeger or Tempo, stdout for local debugging, or none.
+// Tests call Use with an in-memory exporter from
+// go.opentelemetry.io/otel/sdk/trace/tracetest to assert on the spans:
+//
+//	exporter := tracetest.NewInMemoryExporter()
+//	tracing.Use(exporter)
+//	...
+//	spans := exporter.GetSpans()
+//
+// W3C traceparent and baggage headers are always propagated, so this service
+// continues traces started upstream even when it exports nothing itself.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/PaulFWatts/rest_api_golang/buildinfo"
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// propagator reads and writes W3C traceparent, tracestate and baggage headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Init installs the tracer provider and propagators for the tracing configuration
+//
+// The returned function flushes buffered spans and stops the exporter; call
+// it after the server has stopped. With the "none" exporter, spans are not
+// recorded at all.
+func Init(cfg config.Tracing) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagator)
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		var options []otlptracehttp.Option
+		if cfg.OTLPEndpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	serviceResource, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(buildinfo.Get().Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource),
+		// Follow the caller's sampling decision; sample new traces by ratio
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(float64(cfg.SamplePercent)/100))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Use records every span with the exporter as soon as it ends
+//
+// It is meant for tests, with an in-memory exporter; spans are exported
+// synchronously so they can be inspected right after the request returns.
+// Call it once per test binary: tracers created at package initialisation
+// keep the first provider installed, so reset the exporter between tests
+// instead of installing another.
+func Use(exporter sdktrace.SpanExporter) {
+	otel.SetTextMapPropagator(propagator)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+}
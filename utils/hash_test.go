@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2id has small parameters so the tests stay fast
+var testArgon2id = Argon2idHasher{Time: 1, Memory: 1024, Threads: 2, KeyLength: 32}
+
+// useHasher makes the hasher hash new passwords until the test ends
+func useHasher(t *testing.T, hasher PasswordHasher) {
+	previous := passwordHasher
+	passwordHasher = hasher
+	t.Cleanup(func() { passwordHasher = previous })
+}
+
+var phcString = regexp.MustCompile(`^\$argon2id\$v=19\$m=1024,t=1,p=2\$([A-Za-z0-9+/]{22})\$([A-Za-z0-9+/]{43})$`)
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	hash, err := testArgon2id.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	match := phcString.FindStringSubmatch(hash)
+	if match == nil {
+		t.Fatalf("hash %q is not a PHC string with the hasher's parameters, a 16-byte salt and a 32-byte key", hash)
+	}
+
+	// The key is Argon2id of the password with the encoded salt and parameters
+	salt, err := base64.RawStdEncoding.DecodeString(match[1])
+	if err != nil {
+		t.Fatalf("decoding salt: %v", err)
+	}
+	key := argon2.IDKey([]byte("correct horse battery staple"), salt, 1, 1024, 2, 32)
+	if match[2] != base64.RawStdEncoding.EncodeToString(key) {
+		t.Errorf("hash key %s does not match Argon2id of the password and salt", match[2])
+	}
+
+	for password, want := range map[string]bool{"correct horse battery staple": true, "correct horse battery stapl": false, "": false} {
+		valid, err := testArgon2id.Verify(password, hash)
+		if err != nil || valid != want {
+			t.Errorf("Verify(%q) = %v, %v, want %v", password, valid, err, want)
+		}
+	}
+
+	again, err := testArgon2id.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	if again == hash {
+		t.Error("hashing the same password twice gave the same hash; salts must be random")
+	}
+}
+
+func TestArgon2idVerifiesWithTheStoredParameters(t *testing.T) {
+	hash, err := testArgon2id.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	stronger := Argon2idHasher{Time: 2, Memory: 2048, Threads: 1, KeyLength: 32}
+	valid, err := stronger.Verify("correct horse battery staple", hash)
+	if err != nil || !valid {
+		t.Errorf("Verify with other configured parameters = %v, %v, want true", valid, err)
+	}
+	if !stronger.NeedsRehash(hash) {
+		t.Error("NeedsRehash = false for a hash made with other parameters")
+	}
+	if testArgon2id.NeedsRehash(hash) {
+		t.Error("NeedsRehash = true for a hash made with the same parameters")
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	hash, err := testArgon2id.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	parts := strings.Split(hash, "$")
+
+	hashes := map[string]string{
+		"argon2i":         strings.Replace(hash, "$argon2id$", "$argon2i$", 1),
+		"other version":   strings.Replace(hash, "$v=19$", "$v=16$", 1),
+		"bad parameters":  strings.Replace(hash, "m=1024,t=1,p=2", "m=x,t=1,p=2", 1),
+		"bad salt":        strings.Join([]string{"", parts[1], parts[2], parts[3], "!!!", parts[5]}, "$"),
+		"empty key":       strings.Join([]string{"", parts[1], parts[2], parts[3], parts[4], ""}, "$"),
+		"missing section": strings.Join(parts[:5], "$"),
+	}
+	for name, malformed := range hashes {
+		if valid, err := testArgon2id.Verify("correct horse battery staple", malformed); valid || err == nil {
+			t.Errorf("%s: Verify = %v, %v, want an error", name, valid, err)
+		}
+		if !testArgon2id.NeedsRehash(malformed) {
+			t.Errorf("%s: NeedsRehash = false", name)
+		}
+		if CheckPasswordHash(context.Background(), "correct horse battery staple", malformed) {
+			t.Errorf("%s: CheckPasswordHash accepted it", name)
+		}
+	}
+}
+
+func TestCheckPasswordHashAcceptsEitherScheme(t *testing.T) {
+	bcryptHash, err := BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("bcrypt Hash: %v", err)
+	}
+	argon2Hash, err := testArgon2id.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Argon2id Hash: %v", err)
+	}
+
+	for _, hash := range []string{bcryptHash, argon2Hash, strings.Replace(bcryptHash, "$2a$", "$2b$", 1)} {
+		if !CheckPasswordHash(context.Background(), "correct horse battery staple", hash) {
+			t.Errorf("CheckPasswordHash rejected the right password for %q", hash)
+		}
+		if CheckPasswordHash(context.Background(), "Correct horse battery staple", hash) {
+			t.Errorf("CheckPasswordHash accepted the wrong password for %q", hash)
+		}
+	}
+	if CheckPasswordHash(context.Background(), "correct horse battery staple", "correct horse battery staple") {
+		t.Error("CheckPasswordHash accepted a plain text password as its hash")
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	bcryptHash := func(cost int) string {
+		hash, err := BcryptHasher{Cost: cost}.Hash("correct horse battery staple")
+		if err != nil {
+			t.Fatalf("bcrypt Hash: %v", err)
+		}
+		return hash
+	}
+	argon2Hash := func(hasher Argon2idHasher) string {
+		hash, err := hasher.Hash("correct horse battery staple")
+		if err != nil {
+			t.Fatalf("Argon2id Hash: %v", err)
+		}
+		return hash
+	}
+	otherArgon2id := testArgon2id
+	otherArgon2id.Time = 2
+
+	tests := []struct {
+		current PasswordHasher
+		hash    string
+		want    bool
+	}{
+		{BcryptHasher{Cost: 4}, bcryptHash(4), false},
+		{BcryptHasher{Cost: 5}, bcryptHash(4), true},
+		{BcryptHasher{Cost: 4}, argon2Hash(testArgon2id), true},
+		{testArgon2id, argon2Hash(testArgon2id), false},
+		{testArgon2id, argon2Hash(otherArgon2id), true},
+		{testArgon2id, bcryptHash(4), true},
+	}
+
+	for _, test := range tests {
+		useHasher(t, test.current)
+		if got := PasswordNeedsRehash(test.hash); got != test.want {
+			t.Errorf("with %s, PasswordNeedsRehash(%s) = %v, want %v", describeHasher(test.current), test.hash, got, test.want)
+		}
+	}
+}
+
+// describeHasher names the hasher's scheme and parameters for test failures
+func describeHasher(hasher PasswordHasher) string {
+	return fmt.Sprintf("%s %+v", schemeName(hasher), hasher)
+}
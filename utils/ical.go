@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// iCalendar date-time format for UTC values (RFC 5545 section 3.3.5)
+const icalTimeFormat = "20060102T150405Z"
+
+// Calendar is an iCalendar (RFC 5545) VCALENDAR object
+type Calendar struct {
+	Name            string        // Display name for calendar apps (X-WR-CALNAME); optional
+	RefreshInterval time.Duration // How often subscribers should poll the feed; zero to omit
+	Events          []CalendarEvent
+}
+
+// CalendarEvent is a VEVENT component
+//
+// UID and Sequence identify a revision of an event across exports: calendar
+// apps replace their copy when they see the same UID with a higher Sequence.
+// A VEVENT with a RecurrenceID overrides that occurrence of the recurring
+// VEVENT with the same UID.
+type CalendarEvent struct {
+	UID          string
+	Sequence     int64
+	Stamp        time.Time // When the event was last revised (DTSTAMP and LAST-MODIFIED)
+	Start        time.Time
+	Summary      string
+	Description  string
+	Location     string
+	RRule        string
+	ExDates      []time.Time
+	RecurrenceID *time.Time
+}
+
+// Encode renders the calendar as iCalendar text with CRLF line endings and folded lines
+func (c Calendar) Encode() []byte {
+	var w icalWriter
+
+	w.line("BEGIN", "VCALENDAR")
+	w.line("VERSION", "2.0")
+	w.line("PRODID", "-//PaulFWatts//rest_api_golang//EN")
+	w.line("CALSCALE", "GREGORIAN")
+	if c.Name != "" {
+		w.line("X-WR-CALNAME", escapeText(c.Name))
+	}
+	if c.RefreshInterval > 0 {
+		duration := fmt.Sprintf("PT%dM", int(c.RefreshInterval.Minutes()))
+		w.line("REFRESH-INTERVAL;VALUE=DURATION", duration)
+		w.line("X-PUBLISHED-TTL", duration)
+	}
+
+	for _, event := range c.Events {
+		w.line("BEGIN", "VEVENT")
+		w.line("UID", escapeText(event.UID))
+		w.line("SEQUENCE", fmt.Sprint(event.Sequence))
+		w.line("DTSTAMP", formatICalTime(event.Stamp))
+		w.line("LAST-MODIFIED", formatICalTime(event.Stamp))
+		if event.RecurrenceID != nil {
+			w.line("RECURRENCE-ID", formatICalTime(*event.RecurrenceID))
+		}
+		w.line("DTSTART", formatICalTime(event.Start))
+		if event.RRule != "" {
+			w.line("RRULE", event.RRule)
+		}
+		for _, exdate := range event.ExDates {
+			w.line("EXDATE", formatICalTime(exdate))
+		}
+		w.line("SUMMARY", escapeText(event.Summary))
+		if event.Description != "" {
+			w.line("DESCRIPTION", escapeText(event.Description))
+		}
+		if event.Location != "" {
+			w.line("LOCATION", escapeText(event.Location))
+		}
+		w.line("END", "VEVENT")
+	}
+
+	w.line("END", "VCALENDAR")
+	return []byte(w.String())
+}
+
+func formatICalTime(t time.Time) string {
+	return t.UTC().Format(icalTimeFormat)
+}
+
+// escapeText escapes a TEXT value (RFC 5545 section 3.3.11)
+func escapeText(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `;`, `\;`, `,`, `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+	return replacer.Replace(s)
+}
+
+// icalWriter writes content lines folded to 75 octets (RFC 5545 section 3.1)
+type icalWriter struct {
+	strings.Builder
+}
+
+func (w *icalWriter) line(name, value string) {
+	line := name + ":" + value
+	limit := 75
+
+	for len(line) > limit {
+		// Never split a multi-byte UTF-8 sequence across lines
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74 // The leading space of a continuation line counts towards its length
+	}
+
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
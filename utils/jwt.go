@@ -7,19 +7,47 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// secretKey is the key used for signing and verifying JWT tokens
-// TODO: Move to environment variable for production security
-const secretKey = "your_secret_key"
+// accessTokenTTL is how long an access token stays valid after it is issued, set by InitKeys
+var accessTokenTTL = 15 * time.Minute
+
+// RefreshTokenTTL is how long a refresh token can be exchanged for new tokens, set by InitKeys
+var RefreshTokenTTL = 30 * 24 * time.Hour
+
+// EmailVerificationTTL is how long the link in a verification email stays valid
+const EmailVerificationTTL = 24 * time.Hour
+
+// MFATokenTTL is how long the second login step can be completed after the password was checked
+const MFATokenTTL = 5 * time.Minute
+
+// Values of the "purpose" claim, which marks tokens that are not access tokens
+const (
+	purposeEmailVerification = "email_verification"
+	purposeMFA               = "mfa_pending"
+)
+
+// TokenClaims are the identity and authorization claims carried by an access token
+//
+// TokenVersion is the user's token version when the token was issued; the
+// Authenticate middleware rejects tokens whose version is no longer current,
+// which is how a password reset signs the user out everywhere.
+type TokenClaims struct {
+	UserID       int64
+	Email        string
+	Roles        []string
+	Permissions  []string
+	TokenVersion int64
+}
 
 // GenerateToken creates a new JWT token for user authentication
 //
-// This function generates a JWT token containing the user's email and ID,
-// with an expiration time of 2 hours from the current time. The token uses
-// HMAC-SHA256 signing method for security.
+// This function generates a short-lived JWT access token containing the user's
+// email, ID, roles, permissions and token version, with an expiration time of
+// 15 minutes (configurable) from the current time. Clients renew it with a refresh token via
+// POST /token/refresh. The token is signed with the active key loaded by
+// InitKeys and carries that key's kid header.
 //
 // Parameters:
-//   - email: The user's email address to embed in the token claims
-//   - userId: The user's unique ID to embed in the token claims
+//   - claims: The user's identity, roles, permissions and token version
 //
 // Returns:
 //   - string: The signed JWT token string ready for HTTP headers
@@ -28,85 +56,240 @@ const secretKey = "your_secret_key"
 // Token Claims:
 //   - email: User's email address
 //   - userId: User's unique identifier
-//   - exp: Token expiration timestamp (2 hours from creation)
+//   - roles: User's role names
+//   - permissions: User's permissions, checked by middlewares.RequirePermission
+//   - tokenVersion: User's token version, checked by middlewares.Authenticate
+//   - exp: Token expiration timestamp (15 minutes from creation by default)
+//
+// Because permissions are embedded in the token, role changes take effect
+// when the client next refreshes its access token.
 //
 // Example usage:
 //
-//	token, err := GenerateToken("user@example.com", 123)
+//	token, err := GenerateToken(TokenClaims{UserID: 123, Email: "user@example.com", Roles: []string{"user"}})
 //	if err != nil {
 //	    log.Printf("Token generation failed: %v", err)
 //	    return
 //	}
 //	// Use token in Authorization header: "Bearer " + token
-func GenerateToken(email string, userId int64) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email":  email,
-		"userId": userId,
-		"exp":    time.Now().Add(time.Hour * 2).Unix(),
+func GenerateToken(claims TokenClaims) (string, error) {
+	if keys == nil {
+		return "", errors.New("signing keys not initialised")
+	}
+
+	return keys.Sign(jwt.MapClaims{
+		"email":        claims.Email,
+		"userId":       claims.UserID,
+		"roles":        claims.Roles,
+		"permissions":  claims.Permissions,
+		"tokenVersion": claims.TokenVersion,
+		"exp":          time.Now().Add(accessTokenTTL).Unix(),
 	})
-	return token.SignedString([]byte(secretKey))
 }
 
-// VerifyToken validates and parses a JWT token, returning the user ID
+// VerifyToken validates and parses a JWT token, returning its claims
 //
 // This function verifies the signature and validity of a JWT token,
-// ensuring it was signed with the correct secret key and uses HMAC signing method.
-// It performs comprehensive validation and extracts the user ID from the token claims.
+// ensuring it was signed by a key in the loaded key set using that key's algorithm.
+// It performs comprehensive validation and extracts the user ID, email, roles
+// and permissions from the token claims.
 //
 // Parameters:
 //   - token: The JWT token string to verify
 //
 // Returns:
-//   - int64: The user ID extracted from the token claims
+//   - *TokenClaims: The claims extracted from the token
 //   - error: nil if token is valid, otherwise an error describing the validation failure
 //     Possible errors include "invalid token" for parsing/signature failures,
 //     "invalid token claims" for malformed claims, or "userId not found in token"
 //
 // Security Features:
-//   - Validates HMAC signing method to prevent algorithm confusion attacks
+//   - Selects the verification key by kid and requires the matching algorithm,
+//     preventing algorithm confusion attacks
 //   - Automatically checks token expiration via jwt.Parse
-//   - Verifies token signature against the secret key
-//   - Safe type checking for userId extraction
+//   - Verifies token signature against the public key (or HMAC secret) for that kid
+//   - Safe type checking for every extracted claim
 //
 // Example usage:
 //
-//	userId, err := VerifyToken(tokenString)
+//	claims, err := VerifyToken(tokenString)
 //	if err != nil {
 //	    // Token is invalid - deny access
 //	    return
 //	}
-//	// Token is valid - use userId for authorization
-func VerifyToken(token string) (int64, error) {
-	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC) // Ensure token method is HMAC
-		if !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(secretKey), nil
-	})
+//	// Token is valid - use claims.UserID and claims.Permissions for authorization
+func VerifyToken(token string) (*TokenClaims, error) {
+	if keys == nil {
+		return nil, errors.New("signing keys not initialised")
+	}
+
+	parsedToken, err := keys.Parse(token)
 	if err != nil {
-		return 0, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 	tokenIsValid := parsedToken.Valid
 	if !tokenIsValid {
-		return 0, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	claims, ok := parsedToken.Claims.(jwt.MapClaims)
 	if !ok {
-		return 0, errors.New("invalid token claims")
+		return nil, errors.New("invalid token claims")
+	}
+
+	// Tokens issued for another purpose, such as email verification or the MFA step, are not access tokens
+	if _, exists := claims["purpose"]; exists {
+		return nil, errors.New("invalid token")
 	}
 
 	// Extract userId from claims with type checking
 	userIdFloat, exists := claims["userId"]
 	if !exists {
-		return 0, errors.New("userId not found in token")
+		return nil, errors.New("userId not found in token")
 	}
 
 	userId, ok := userIdFloat.(float64)
 	if !ok {
-		return 0, errors.New("invalid userId format in token")
+		return nil, errors.New("invalid userId format in token")
+	}
+
+	email, _ := claims["email"].(string)
+
+	roles, err := stringSliceClaim(claims, "roles")
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := stringSliceClaim(claims, "permissions")
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens issued before token versions existed have version 0
+	tokenVersion, _ := claims["tokenVersion"].(float64)
+
+	return &TokenClaims{UserID: int64(userId), Email: email, Roles: roles, Permissions: permissions, TokenVersion: int64(tokenVersion)}, nil
+}
+
+// stringSliceClaim reads a claim that is a JSON array of strings; a missing claim is an empty slice
+func stringSliceClaim(claims jwt.MapClaims, name string) ([]string, error) {
+	raw, exists := claims[name]
+	if !exists || raw == nil {
+		return []string{}, nil
+	}
+
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, errors.New("invalid " + name + " format in token")
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		value, ok := item.(string)
+		if !ok {
+			return nil, errors.New("invalid " + name + " format in token")
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// GenerateEmailVerificationToken creates a signed token for the link in a verification email
+//
+// The token is a JWT signed with the active key, valid for EmailVerificationTTL,
+// and bound to the address it was sent to, so it stops working if the user's
+// email changes. Its "purpose" claim keeps it from being accepted as an access token.
+func GenerateEmailVerificationToken(userId int64, email string) (string, error) {
+	if keys == nil {
+		return "", errors.New("signing keys not initialised")
+	}
+
+	return keys.Sign(jwt.MapClaims{
+		"purpose": purposeEmailVerification,
+		"userId":  userId,
+		"email":   email,
+		"exp":     time.Now().Add(EmailVerificationTTL).Unix(),
+	})
+}
+
+// VerifyEmailVerificationToken validates a token from a verification email and
+// returns the user ID and email address it was issued for
+func VerifyEmailVerificationToken(token string) (int64, string, error) {
+	claims, err := parsePurposeToken(token, purposeEmailVerification)
+	if err != nil {
+		return 0, "", err
+	}
+
+	userId, ok := claims["userId"].(float64)
+	if !ok {
+		return 0, "", errors.New("invalid userId format in token")
+	}
+
+	email, ok := claims["email"].(string)
+	if !ok {
+		return 0, "", errors.New("invalid email format in token")
+	}
+
+	return int64(userId), email, nil
+}
+
+// GenerateMFAToken creates the short-lived "MFA pending" token returned by /login
+// when the account has two-factor authentication enabled
+//
+// The token only proves that the password was checked; POST /login/mfa exchanges
+// it, together with a valid code, for an access token. It expires after MFATokenTTL
+// and carries the user's token version so a password reset also invalidates it.
+func GenerateMFAToken(userId int64, tokenVersion int64) (string, error) {
+	if keys == nil {
+		return "", errors.New("signing keys not initialised")
+	}
+
+	return keys.Sign(jwt.MapClaims{
+		"purpose":      purposeMFA,
+		"userId":       userId,
+		"tokenVersion": tokenVersion,
+		"exp":          time.Now().Add(MFATokenTTL).Unix(),
+	})
+}
+
+// VerifyMFAToken validates an "MFA pending" token and returns the user ID and
+// token version it was issued for
+func VerifyMFAToken(token string) (int64, int64, error) {
+	claims, err := parsePurposeToken(token, purposeMFA)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	userId, ok := claims["userId"].(float64)
+	if !ok {
+		return 0, 0, errors.New("invalid userId format in token")
+	}
+
+	tokenVersion, _ := claims["tokenVersion"].(float64)
+
+	return int64(userId), int64(tokenVersion), nil
+}
+
+// parsePurposeToken verifies a token and checks that it was issued for the given purpose
+func parsePurposeToken(token string, purpose string) (jwt.MapClaims, error) {
+	if keys == nil {
+		return nil, errors.New("signing keys not initialised")
+	}
+
+	parsedToken, err := keys.Parse(token)
+	if err != nil || !parsedToken.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+
+	if value, _ := claims["purpose"].(string); value != purpose {
+		return nil, errors.New("invalid token")
 	}
 
-	return int64(userId), nil
+	return claims, nil
 }
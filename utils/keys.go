@@ -0,0 +1,330 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/PaulFWatts/rest_api_golang/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultKeyID is the kid of the HMAC key used when no asymmetric keys are configured
+const defaultKeyID = "default"
+
+// keys is the key set used by GenerateToken and VerifyToken, loaded by InitKeys
+var keys *KeySet
+
+// SigningKey is a single JWT key identified by its kid
+//
+// A key loaded from a private key PEM block can both sign and verify tokens.
+// A key loaded from a public key PEM block can only verify tokens, which is
+// how a retired key is kept around until every token it signed has expired.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	method    jwt.SigningMethod
+	private   any
+	public    any
+}
+
+// CanSign reports whether the key holds private material
+func (k *SigningKey) CanSign() bool {
+	return k.private != nil
+}
+
+// KeySet holds every key accepted for verification and the key used for signing
+type KeySet struct {
+	keys     map[string]*SigningKey
+	order    []string
+	activeID string
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set as served from /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// InitKeys loads the signing keys and sets the token lifetimes from the auth configuration
+//
+// Settings:
+//   - JWTKeys: Comma-separated "kid=source" entries. A source is either a path
+//     to a PEM file or "env:NAME" to read the PEM block from environment variable NAME.
+//     Private keys (PKCS#8, PKCS#1 RSA or SEC1 EC) sign and verify; public keys only verify.
+//   - JWTActiveKID: The kid used to sign new tokens (defaults to the first private key)
+//   - JWTSecret: HMAC secret used with HS256 when JWTKeys is not set (development only)
+//   - AccessTokenTTL, RefreshTokenTTL: How long issued tokens stay valid
+//
+// Supported algorithms are RS256 (RSA), ES256 (ECDSA P-256) and EdDSA (Ed25519).
+// Listing several keys lets a new key be introduced before the old one is retired,
+// so keys can be rotated without invalidating tokens that are still in use.
+//
+// One of JWTKeys and JWTSecret must be set; config.Load rejects configurations
+// with neither, so there is no built-in secret anyone could forge tokens with.
+func InitKeys(cfg config.Auth) {
+	var err error
+
+	switch {
+	case cfg.JWTKeys == "" && cfg.JWTSecret == "":
+		panic("No signing keys configured: set auth.jwt_keys, or auth.jwt_secret for development.")
+	case cfg.JWTKeys == "":
+		keys, err = NewHMACKeySet(defaultKeyID, []byte(cfg.JWTSecret))
+	default:
+		keys, err = LoadKeySet(cfg.JWTKeys, cfg.JWTActiveKID)
+	}
+
+	accessTokenTTL = cfg.AccessTokenTTL
+	RefreshTokenTTL = cfg.RefreshTokenTTL
+
+	if err != nil {
+		panic("Could not load signing keys: " + err.Error())
+	}
+}
+
+// NewHMACKeySet returns a key set containing a single HS256 key
+func NewHMACKeySet(kid string, secret []byte) (*KeySet, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("HMAC secret is empty")
+	}
+
+	key := &SigningKey{ID: kid, Algorithm: "HS256", method: jwt.SigningMethodHS256, private: secret, public: secret}
+	return newKeySet([]*SigningKey{key}, kid)
+}
+
+// LoadKeySet parses a JWT_KEYS-style specification and loads every key it names
+func LoadKeySet(specs string, activeID string) (*KeySet, error) {
+	var loaded []*SigningKey
+
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		kid, source, found := strings.Cut(spec, "=")
+		if !found || kid == "" || source == "" {
+			return nil, fmt.Errorf("invalid key entry %q, expected kid=source", spec)
+		}
+
+		data, err := readKeySource(source)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kid, err)
+		}
+
+		key, err := ParseKeyPEM(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", kid, err)
+		}
+
+		loaded = append(loaded, key)
+	}
+
+	return newKeySet(loaded, activeID)
+}
+
+func readKeySource(source string) ([]byte, error) {
+	name, fromEnv := strings.CutPrefix(source, "env:")
+	if !fromEnv {
+		return os.ReadFile(source)
+	}
+
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("environment variable %s is empty", name)
+	}
+	return []byte(value), nil
+}
+
+func newKeySet(loaded []*SigningKey, activeID string) (*KeySet, error) {
+	set := &KeySet{keys: map[string]*SigningKey{}}
+
+	for _, key := range loaded {
+		if _, exists := set.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		set.keys[key.ID] = key
+		set.order = append(set.order, key.ID)
+
+		if activeID == "" && key.CanSign() {
+			activeID = key.ID
+		}
+	}
+
+	active, ok := set.keys[activeID]
+	if !ok {
+		return nil, errors.New("no signing key configured")
+	}
+	if !active.CanSign() {
+		return nil, fmt.Errorf("active key %q has no private key", activeID)
+	}
+
+	set.activeID = activeID
+	return set, nil
+}
+
+// ParseKeyPEM parses a single PEM-encoded private or public key
+func ParseKeyPEM(kid string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: kid}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private, key.public = k, &k.PublicKey
+	case *ecdsa.PrivateKey:
+		key.private, key.public = k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.private, key.public = k, k.Public()
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		key.public = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		key.Algorithm, key.method = "RS256", jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 ECDSA keys are supported")
+		}
+		key.Algorithm, key.method = "ES256", jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		key.Algorithm, key.method = "EdDSA", jwt.SigningMethodEdDSA
+	}
+
+	return key, nil
+}
+
+// Sign signs the claims with the active key and sets the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.keys[ks.activeID]
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// Parse verifies a token against the key named by its kid header
+//
+// The token's alg header must match the algorithm of that key, which rules out
+// algorithm confusion attacks such as presenting an RSA public key as an HMAC secret.
+func (ks *KeySet) Parse(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, ks.keyFunc, jwt.WithValidMethods(ks.algorithms()))
+}
+
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("missing kid header")
+	}
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.public, nil
+}
+
+func (ks *KeySet) algorithms() []string {
+	var algs []string
+	for _, kid := range ks.order {
+		algs = append(algs, ks.keys[kid].Algorithm)
+	}
+	return algs
+}
+
+// JWKS returns the public keys of the set; HMAC keys are secret and never included
+func (ks *KeySet) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+
+	for _, kid := range ks.order {
+		key := ks.keys[kid]
+		jwk := JWK{Kid: key.ID, Use: "sig", Alg: key.Algorithm}
+
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			point, err := pub.ECDH()
+			if err != nil {
+				continue
+			}
+			raw := point.Bytes() // 0x04 || X || Y
+			jwk.Kty, jwk.Crv = "EC", "P-256"
+			jwk.X = base64.RawURLEncoding.EncodeToString(raw[1:33])
+			jwk.Y = base64.RawURLEncoding.EncodeToString(raw[33:])
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// PublicJWKS returns the JSON Web Key Set for the keys loaded by InitKeys
+func PublicJWKS() JWKS {
+	if keys == nil {
+		return JWKS{Keys: []JWK{}}
+	}
+	return keys.JWKS()
+}
@@ -0,0 +1,326 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testKeys are generated once, since RSA key generation is slow
+var testKeys = struct {
+	rsa     *rsa.PrivateKey
+	ecdsa   *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}{}
+
+func init() {
+	var err error
+	testKeys.rsa, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	testKeys.ecdsa, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	_, testKeys.ed25519, err = ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// privatePEM encodes a private key as a PKCS#8 PEM block
+func privatePEM(t *testing.T, key any) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// publicPEM encodes a public key as a PKIX PEM block
+func publicPEM(t *testing.T, key crypto.PublicKey) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// keyFile writes the PEM block to a temporary file and returns its path
+func keyFile(t *testing.T, data []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	err := os.WriteFile(path, data, 0o600)
+	if err != nil {
+		t.Fatalf("writing key file: %v", err)
+	}
+	return path
+}
+
+// loadKeySet loads the JWT_KEYS-style specification or fails the test
+func loadKeySet(t *testing.T, specs string, activeID string) *KeySet {
+	t.Helper()
+
+	set, err := LoadKeySet(specs, activeID)
+	if err != nil {
+		t.Fatalf("LoadKeySet(%q, %q): %v", specs, activeID, err)
+	}
+	return set
+}
+
+// testClaims are valid for a minute
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"userId": 1, "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestKeySetSignsWithTheActiveKeyAndVerifiesByKid(t *testing.T) {
+	t.Setenv("TEST_ED25519_KEY", string(privatePEM(t, testKeys.ed25519)))
+	rsaFile := keyFile(t, privatePEM(t, testKeys.rsa))
+	ecdsaFile := keyFile(t, privatePEM(t, testKeys.ecdsa))
+	specs := "rsa=" + rsaFile + ", ec=" + ecdsaFile + ", ed=env:TEST_ED25519_KEY"
+
+	tests := []struct {
+		activeID string
+		wantKid  string
+		wantAlg  string
+	}{
+		{"", "rsa", "RS256"}, // The first private key is active by default
+		{"ec", "ec", "ES256"},
+		{"ed", "ed", "EdDSA"},
+	}
+
+	verifier := loadKeySet(t, specs, "")
+	for _, test := range tests {
+		signer := loadKeySet(t, specs, test.activeID)
+		token, err := signer.Sign(testClaims())
+		if err != nil {
+			t.Fatalf("Sign with %q: %v", test.activeID, err)
+		}
+
+		parsed, err := verifier.Parse(token)
+		if err != nil {
+			t.Fatalf("Parse a token signed by %q: %v", test.wantKid, err)
+		}
+		if kid := parsed.Header["kid"]; kid != test.wantKid {
+			t.Errorf("active key %q signed with kid %v, want %q", test.activeID, kid, test.wantKid)
+		}
+		if alg := parsed.Method.Alg(); alg != test.wantAlg {
+			t.Errorf("active key %q signed with %s, want %s", test.activeID, alg, test.wantAlg)
+		}
+	}
+
+	// A retired key kept as a public key still verifies the tokens it signed
+	retiring := loadKeySet(t, "old="+rsaFile, "")
+	token, err := retiring.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	rotated := loadKeySet(t, "new="+ecdsaFile+",old="+keyFile(t, publicPEM(t, &testKeys.rsa.PublicKey)), "new")
+	if _, err := rotated.Parse(token); err != nil {
+		t.Errorf("Parse a token signed by a retired key: %v", err)
+	}
+}
+
+func TestKeySetRejectsUnknownKeys(t *testing.T) {
+	ecdsaFile := keyFile(t, privatePEM(t, testKeys.ecdsa))
+	verifier := loadKeySet(t, "ec="+ecdsaFile, "")
+
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	otherFile := keyFile(t, privatePEM(t, other))
+
+	unknown, err := loadKeySet(t, "other="+otherFile, "").Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	forged, err := loadKeySet(t, "ec="+otherFile, "").Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	withoutKid, err := jwt.NewWithClaims(jwt.SigningMethodES256, testClaims()).SignedString(testKeys.ecdsa)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	tokens := map[string]string{
+		"unknown kid":             unknown,
+		"known kid, another key":  forged,
+		"no kid":                  withoutKid,
+		"not a token":             "not.a.token",
+		"expired":                 mustSign(t, verifier, jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}),
+		"no algorithm (alg=none)": mustSignNone(t, "ec"),
+	}
+	for name, token := range tokens {
+		if _, err := verifier.Parse(token); err == nil {
+			t.Errorf("Parse accepted a token with %s", name)
+		}
+	}
+}
+
+func TestKeySetRejectsAlgorithmConfusion(t *testing.T) {
+	rsaPublic := publicPEM(t, &testKeys.rsa.PublicKey)
+	rsaKey, err := ParseKeyPEM("rsa", rsaPublic)
+	if err != nil {
+		t.Fatalf("ParseKeyPEM: %v", err)
+	}
+
+	// With an HMAC key in the set HS256 is an accepted algorithm, so the token
+	// must be checked against the algorithm of the key its kid names
+	hmacKey := &SigningKey{ID: "hmac", Algorithm: "HS256", method: jwt.SigningMethodHS256, private: []byte("a development secret"), public: []byte("a development secret")}
+	edKey := &SigningKey{ID: "ed", Algorithm: "EdDSA", method: jwt.SigningMethodEdDSA, private: testKeys.ed25519, public: testKeys.ed25519.Public()}
+
+	sets := map[string]*KeySet{}
+	for name, signer := range map[string]*SigningKey{"RSA and HMAC keys": hmacKey, "RSA and EdDSA keys": edKey} {
+		sets[name], err = newKeySet([]*SigningKey{rsaKey, signer}, signer.ID)
+		if err != nil {
+			t.Fatalf("newKeySet: %v", err)
+		}
+	}
+
+	for name, set := range sets {
+		for _, secret := range [][]byte{rsaPublic, testKeys.rsa.PublicKey.N.Bytes()} {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+			token.Header["kid"] = "rsa"
+			signed, err := token.SignedString(secret)
+			if err != nil {
+				t.Fatalf("SignedString: %v", err)
+			}
+
+			if _, err := set.Parse(signed); err == nil {
+				t.Errorf("%s: Parse accepted an HS256 token for the RS256 key", name)
+			}
+		}
+	}
+}
+
+// mustSign signs the claims with the set's active key
+func mustSign(t *testing.T, set *KeySet, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := set.Sign(claims)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return token
+}
+
+// mustSignNone returns an unsigned token with the kid
+func mustSignNone(t *testing.T, kid string) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims())
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func TestJWKS(t *testing.T) {
+	t.Setenv("TEST_RSA_PUBLIC_KEY", string(publicPEM(t, &testKeys.rsa.PublicKey)))
+	specs := "ec=" + keyFile(t, privatePEM(t, testKeys.ecdsa)) +
+		",ed=" + keyFile(t, privatePEM(t, testKeys.ed25519)) +
+		",retired=env:TEST_RSA_PUBLIC_KEY"
+	jwks := loadKeySet(t, specs, "ed").JWKS()
+
+	if len(jwks.Keys) != 3 {
+		t.Fatalf("JWKS has %d keys, want 3: %+v", len(jwks.Keys), jwks.Keys)
+	}
+
+	decode := func(field string, value string) []byte {
+		t.Helper()
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			t.Fatalf("%s is not base64url without padding: %q", field, value)
+		}
+		return data
+	}
+
+	ec := jwks.Keys[0]
+	if ec.Kid != "ec" || ec.Kty != "EC" || ec.Crv != "P-256" || ec.Alg != "ES256" || ec.Use != "sig" {
+		t.Errorf("EC key = %+v", ec)
+	}
+	x, y := decode("x", ec.X), decode("y", ec.Y)
+	if len(x) != 32 || len(y) != 32 || new(big.Int).SetBytes(x).Cmp(testKeys.ecdsa.X) != 0 || new(big.Int).SetBytes(y).Cmp(testKeys.ecdsa.Y) != 0 {
+		t.Errorf("EC key point = (%s, %s), want the public key's", ec.X, ec.Y)
+	}
+
+	ed := jwks.Keys[1]
+	if ed.Kid != "ed" || ed.Kty != "OKP" || ed.Crv != "Ed25519" || ed.Alg != "EdDSA" || ed.Use != "sig" {
+		t.Errorf("Ed25519 key = %+v", ed)
+	}
+	if !testKeys.ed25519.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(decode("x", ed.X))) {
+		t.Errorf("Ed25519 key x = %s, want the public key", ed.X)
+	}
+
+	retired := jwks.Keys[2]
+	if retired.Kid != "retired" || retired.Kty != "RSA" || retired.Alg != "RS256" || retired.Use != "sig" {
+		t.Errorf("RSA key = %+v", retired)
+	}
+	if new(big.Int).SetBytes(decode("n", retired.N)).Cmp(testKeys.rsa.N) != 0 || new(big.Int).SetBytes(decode("e", retired.E)).Int64() != int64(testKeys.rsa.E) {
+		t.Errorf("RSA key n, e = %s, %s, want the public key's", retired.N, retired.E)
+	}
+
+	// HMAC secrets are never published
+	hmacSet, err := NewHMACKeySet("default", []byte("a development secret"))
+	if err != nil {
+		t.Fatalf("NewHMACKeySet: %v", err)
+	}
+	if keys := hmacSet.JWKS().Keys; len(keys) != 0 {
+		t.Errorf("JWKS of an HMAC key set = %+v, want no keys", keys)
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	privateFile := keyFile(t, privatePEM(t, testKeys.ecdsa))
+	publicFile := keyFile(t, publicPEM(t, &testKeys.rsa.PublicKey))
+
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	tests := map[string]struct {
+		specs    string
+		activeID string
+	}{
+		"only public keys":      {"old=" + publicFile, ""},
+		"public active key":     {"new=" + privateFile + ",old=" + publicFile, "old"},
+		"unknown active key":    {"new=" + privateFile, "missing"},
+		"duplicate kid":         {"a=" + privateFile + ",a=" + publicFile, ""},
+		"entry without a kid":   {privateFile, ""},
+		"missing file":          {"a=" + filepath.Join(t.TempDir(), "missing.pem"), ""},
+		"empty environment":     {"a=env:TEST_UNSET_KEY", ""},
+		"short RSA key":         {"a=" + keyFile(t, privatePEM(t, small)), ""},
+		"not a PEM file":        {"a=" + keyFile(t, []byte("secret")), ""},
+		"unsupported PEM block": {"a=" + keyFile(t, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte{1}})), ""},
+	}
+
+	for name, test := range tests {
+		if _, err := LoadKeySet(test.specs, test.activeID); err == nil {
+			t.Errorf("%s: LoadKeySet(%q, %q) succeeded", name, test.specs, test.activeID)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateOpaqueToken returns a random, URL-safe token string
+//
+// The token carries 256 bits of entropy from crypto/rand and has no internal
+// structure, so it can only be validated by looking up its hash in the database.
+func GenerateOpaqueToken() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 digest of an opaque token
+//
+// Only this digest is persisted, so a leaked database does not expose
+// tokens that can be replayed against the API.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}